@@ -0,0 +1,222 @@
+// Package authz provides authorization middlewares that run after the authentication
+// middleware and check the principals it stored in the request context
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bancodobrasil/goauth"
+	"github.com/bancodobrasil/goauth/handler"
+	"github.com/bancodobrasil/goauth/principal"
+)
+
+var (
+	// ErrUnauthenticated is returned when the request has no principal
+	ErrUnauthenticated = errors.New("Unauthenticated")
+	// ErrInsufficientScope is returned when the principal does not have the required scopes
+	ErrInsufficientScope = handler.ErrInsufficientScope
+	// ErrMissingRole is returned when the principal does not have any of the required roles
+	ErrMissingRole = errors.New("Missing role")
+	// ErrClaimMismatch is returned when a claim of the principal does not satisfy the predicate
+	ErrClaimMismatch = errors.New("Claim mismatch")
+)
+
+var defaultAuthorizer = New()
+
+// Authorizer creates authorization middlewares. The middlewares allow the request if any of
+// the principals stored by the authentication middleware satisfies the requirement
+type Authorizer struct {
+	responder   goauth.ErrorResponder
+	logger      DecisionLogger
+	scopeClaims []string
+	roleClaims  []string
+}
+
+// Option configures an Authorizer
+type Option func(a *Authorizer)
+
+// WithErrorResponder sets the ErrorResponder used to write the response of denied requests.
+// Defaults to the ErrorResponder of the default goauth middleware
+func WithErrorResponder(responder goauth.ErrorResponder) Option {
+	return func(a *Authorizer) {
+		a.responder = responder
+	}
+}
+
+// WithDecisionLogger sets the DecisionLogger that records the decisions of the policies.
+// Defaults to logging the decisions with the logger of the library
+func WithDecisionLogger(logger DecisionLogger) Option {
+	return func(a *Authorizer) {
+		a.logger = logger
+	}
+}
+
+// WithScopeClaims sets the claim paths (e.g. scp or scope) the scopes are read from, instead
+// of the scopes of the principal. The values may be lists or space-separated strings
+func WithScopeClaims(paths ...string) Option {
+	return func(a *Authorizer) {
+		a.scopeClaims = paths
+	}
+}
+
+// WithRoleClaims sets the claim paths (e.g. realm_access.roles) the roles are read from,
+// instead of the roles of the principal. The values may be lists or space-separated strings
+func WithRoleClaims(paths ...string) Option {
+	return func(a *Authorizer) {
+		a.roleClaims = paths
+	}
+}
+
+// New returns a new Authorizer instance
+func New(opts ...Option) *Authorizer {
+	a := &Authorizer{}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Default returns the Authorizer used by the package-level functions
+func Default() *Authorizer {
+	return defaultAuthorizer
+}
+
+// Scopes returns the scopes of the principal, read from the scope claims if they are configured
+func (a *Authorizer) Scopes(p *principal.Principal) []string {
+	if len(a.scopeClaims) == 0 {
+		return p.Scopes
+	}
+	return claimValues(p.Claims, a.scopeClaims)
+}
+
+// Roles returns the roles of the principal, read from the role claims if they are configured
+func (a *Authorizer) Roles(p *principal.Principal) []string {
+	if len(a.roleClaims) == 0 {
+		return p.Roles
+	}
+	return claimValues(p.Claims, a.roleClaims)
+}
+
+// RequireScopes returns a middleware that requires a principal with all the scopes.
+// Denied requests get a 403 response with an insufficient_scope challenge (RFC 6750)
+func (a *Authorizer) RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return a.require(func(p *principal.Principal) error {
+		granted := a.Scopes(p)
+		for _, scope := range scopes {
+			if !contains(granted, scope) {
+				return ErrInsufficientScope
+			}
+		}
+		return nil
+	}, scopes)
+}
+
+// RequireAnyRole returns a middleware that requires a principal with at least one of the roles
+func (a *Authorizer) RequireAnyRole(roles ...string) func(http.Handler) http.Handler {
+	return a.require(func(p *principal.Principal) error {
+		granted := a.Roles(p)
+		for _, role := range roles {
+			if contains(granted, role) {
+				return nil
+			}
+		}
+		return ErrMissingRole
+	}, nil)
+}
+
+// RequireClaim returns a middleware that requires a principal whose claim on the path satisfies the predicate
+func (a *Authorizer) RequireClaim(path string, predicate ClaimPredicate) func(http.Handler) http.Handler {
+	return a.require(func(p *principal.Principal) error {
+		value, ok := Claim(p.Claims, path)
+		if !predicate(value, ok) {
+			return fmt.Errorf("%w: %s", ErrClaimMismatch, path)
+		}
+		return nil
+	}, nil)
+}
+
+// require returns a middleware that allows the request if any of its principals passes the check
+func (a *Authorizer) require(check func(p *principal.Principal) error, scopes []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principals := principal.AllFromContext(r.Context())
+			if len(principals) == 0 {
+				a.deny(w, r, http.StatusUnauthorized, ErrUnauthenticated, nil)
+				return
+			}
+
+			var err error
+			for _, p := range principals {
+				if err = check(p); err == nil {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			a.deny(w, r, http.StatusForbidden, err, scopes)
+		})
+	}
+}
+
+// errorResponder returns the ErrorResponder of the Authorizer or, if it is not set, of the default goauth middleware
+func (a *Authorizer) errorResponder() goauth.ErrorResponder {
+	if a.responder != nil {
+		return a.responder
+	}
+	return goauth.Default().ErrorResponder()
+}
+
+// decisionLogger returns the DecisionLogger of the Authorizer
+func (a *Authorizer) decisionLogger() DecisionLogger {
+	if a.logger != nil {
+		return a.logger
+	}
+	return DecisionLoggerFunc(logDecision)
+}
+
+// deny writes the response of a denied request using the ErrorResponder
+func (a *Authorizer) deny(w http.ResponseWriter, r *http.Request, statusCode int, err error, scopes []string) {
+	responder := a.errorResponder()
+
+	authErr := &goauth.AuthMiddlewareError{
+		Code:    statusCode,
+		Message: err.Error(),
+	}
+	if statusCode == http.StatusUnauthorized {
+		authErr.Challenges = []handler.Challenge{{Scheme: "Bearer"}}
+	} else if errors.Is(err, ErrInsufficientScope) {
+		authErr.Challenges = []handler.Challenge{{
+			Scheme: "Bearer",
+			Error:  "insufficient_scope",
+			Scope:  strings.Join(scopes, " "),
+		}}
+	}
+	responder.Respond(w, r, authErr)
+}
+
+// RequireScopes returns a middleware of the default Authorizer that requires a principal with all the scopes
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return defaultAuthorizer.RequireScopes(scopes...)
+}
+
+// RequireAnyRole returns a middleware of the default Authorizer that requires a principal with at least one of the roles
+func RequireAnyRole(roles ...string) func(http.Handler) http.Handler {
+	return defaultAuthorizer.RequireAnyRole(roles...)
+}
+
+// RequireClaim returns a middleware of the default Authorizer that requires a principal
+// whose claim on the path satisfies the predicate
+func RequireClaim(path string, predicate ClaimPredicate) func(http.Handler) http.Handler {
+	return defaultAuthorizer.RequireClaim(path, predicate)
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package authz
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bancodobrasil/goauth"
+	"github.com/bancodobrasil/goauth/principal"
+)
+
+// serve runs the middleware on a request with the principals and returns the response
+func serve(middleware func(http.Handler) http.Handler, principals ...*principal.Principal) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	ctx := r.Context()
+	for _, p := range principals {
+		ctx = principal.NewContext(ctx, p)
+	}
+	w := httptest.NewRecorder()
+	middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(w, r.WithContext(ctx))
+	return w
+}
+
+// errorMessage returns the message of the JSON error response
+func errorMessage(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid error response %q: %s", w.Body, err)
+	}
+	return body["error"]
+}
+
+func TestRequireScopes(t *testing.T) {
+	claimScopes := New(WithScopeClaims("scope", "scp"), WithErrorResponder(goauth.JSONErrorResponder{}))
+
+	tests := []struct {
+		name       string
+		authorizer *Authorizer
+		scopes     []string
+		principals []*principal.Principal
+		wantStatus int
+	}{
+		{
+			name:       "all scopes",
+			authorizer: New(),
+			scopes:     []string{"orders:read", "orders:write"},
+			principals: []*principal.Principal{{Subject: "user", Scopes: []string{"orders:write", "orders:read"}}},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "missing one scope",
+			authorizer: New(),
+			scopes:     []string{"orders:read", "orders:write"},
+			principals: []*principal.Principal{{Subject: "user", Scopes: []string{"orders:read"}}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "space-separated scope claim",
+			authorizer: claimScopes,
+			scopes:     []string{"orders:read", "orders:write"},
+			principals: []*principal.Principal{{Subject: "user", Claims: map[string]any{"scope": "openid orders:read orders:write"}}},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "array scope claim",
+			authorizer: claimScopes,
+			scopes:     []string{"orders:read"},
+			principals: []*principal.Principal{{Subject: "user", Claims: map[string]any{"scp": []any{"orders:read", "orders:write"}}}},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "scopes split between the claims",
+			authorizer: claimScopes,
+			scopes:     []string{"orders:read", "orders:write"},
+			principals: []*principal.Principal{{Subject: "user", Claims: map[string]any{"scope": "orders:read", "scp": []string{"orders:write"}}}},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "scope claim ignores the principal scopes",
+			authorizer: claimScopes,
+			scopes:     []string{"orders:read"},
+			principals: []*principal.Principal{{Subject: "user", Scopes: []string{"orders:read"}, Claims: map[string]any{"scope": "openid"}}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "scope prefix",
+			authorizer: claimScopes,
+			scopes:     []string{"orders"},
+			principals: []*principal.Principal{{Subject: "user", Claims: map[string]any{"scope": "orders:read"}}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "any principal",
+			authorizer: New(),
+			scopes:     []string{"orders:read"},
+			principals: []*principal.Principal{{Subject: "service"}, {Subject: "user", Scopes: []string{"orders:read"}}},
+			wantStatus: http.StatusNoContent,
+		},
+		{name: "missing principal", authorizer: New(), scopes: []string{"orders:read"}, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.authorizer.RequireScopes(tt.scopes...), tt.principals...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRequireScopesChallenges(t *testing.T) {
+	a := New(WithErrorResponder(goauth.JSONErrorResponder{}))
+	middleware := a.RequireScopes("orders:read", "orders:write")
+
+	w := serve(middleware, &principal.Principal{Subject: "user", Scopes: []string{"orders:read"}})
+	if w.Code != http.StatusForbidden || errorMessage(t, w) != ErrInsufficientScope.Error() {
+		t.Fatalf("response = %d %s, want 403 %s", w.Code, w.Body, ErrInsufficientScope)
+	}
+	want := `Bearer scope="orders:read orders:write", error="insufficient_scope"`
+	if got := w.Header().Get("WWW-Authenticate"); got != want {
+		t.Fatalf("WWW-Authenticate = %q, want %q", got, want)
+	}
+
+	w = serve(middleware)
+	if w.Code != http.StatusUnauthorized || errorMessage(t, w) != ErrUnauthenticated.Error() {
+		t.Fatalf("response = %d %s, want 401 %s", w.Code, w.Body, ErrUnauthenticated)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); got != "Bearer" {
+		t.Fatalf("WWW-Authenticate = %q, want Bearer", got)
+	}
+}
+
+func TestRequireAnyRole(t *testing.T) {
+	realmRoles := New(WithRoleClaims("realm_access.roles", "resource_access.orders.roles"))
+
+	tests := []struct {
+		name       string
+		authorizer *Authorizer
+		roles      []string
+		principals []*principal.Principal
+		wantStatus int
+	}{
+		{
+			name:       "one of the roles",
+			authorizer: New(),
+			roles:      []string{"admin", "operator"},
+			principals: []*principal.Principal{{Subject: "user", Roles: []string{"viewer", "operator"}}},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "none of the roles",
+			authorizer: New(),
+			roles:      []string{"admin", "operator"},
+			principals: []*principal.Principal{{Subject: "user", Roles: []string{"viewer"}}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "nested role claim",
+			authorizer: realmRoles,
+			roles:      []string{"admin"},
+			principals: []*principal.Principal{{Subject: "user", Claims: map[string]any{"realm_access": map[string]any{"roles": []any{"admin"}}}}},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "second nested role claim",
+			authorizer: realmRoles,
+			roles:      []string{"admin"},
+			principals: []*principal.Principal{{Subject: "user", Claims: map[string]any{
+				"realm_access":    map[string]any{"roles": []any{"viewer"}},
+				"resource_access": map[string]any{"orders": map[string]any{"roles": "viewer admin"}},
+			}}},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "role claim of another path",
+			authorizer: realmRoles,
+			roles:      []string{"admin"},
+			principals: []*principal.Principal{{Subject: "user", Claims: map[string]any{"resource_access": map[string]any{"billing": map[string]any{"roles": []any{"admin"}}}}}},
+			wantStatus: http.StatusForbidden,
+		},
+		{name: "missing principal", authorizer: New(), roles: []string{"admin"}, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.authorizer.RequireAnyRole(tt.roles...), tt.principals...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code == http.StatusForbidden && w.Header().Get("WWW-Authenticate") != "" {
+				t.Fatalf("WWW-Authenticate = %q, want no challenge for a missing role", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestRequireClaim(t *testing.T) {
+	user := &principal.Principal{Subject: "user", Claims: map[string]any{
+		"email_verified": true,
+		"tenant":         map[string]any{"id": "acme", "plan": "pro"},
+		"groups":         []any{"staff", "billing"},
+		"amr":            "pwd otp",
+	}}
+
+	tests := []struct {
+		name       string
+		path       string
+		predicate  ClaimPredicate
+		principals []*principal.Principal
+		wantStatus int
+	}{
+		{name: "exists", path: "tenant.id", predicate: Exists(), principals: []*principal.Principal{user}, wantStatus: http.StatusNoContent},
+		{name: "missing", path: "tenant.region", predicate: Exists(), principals: []*principal.Principal{user}, wantStatus: http.StatusForbidden},
+		{name: "path through a scalar", path: "tenant.id.value", predicate: Exists(), principals: []*principal.Principal{user}, wantStatus: http.StatusForbidden},
+		{name: "equals bool", path: "email_verified", predicate: Equals("true"), principals: []*principal.Principal{user}, wantStatus: http.StatusNoContent},
+		{name: "equals nested", path: "tenant.plan", predicate: Equals("pro"), principals: []*principal.Principal{user}, wantStatus: http.StatusNoContent},
+		{name: "not equal", path: "tenant.plan", predicate: Equals("free"), principals: []*principal.Principal{user}, wantStatus: http.StatusForbidden},
+		{name: "contains list", path: "groups", predicate: Contains("billing"), principals: []*principal.Principal{user}, wantStatus: http.StatusNoContent},
+		{name: "contains space-separated", path: "amr", predicate: Contains("otp"), principals: []*principal.Principal{user}, wantStatus: http.StatusNoContent},
+		{name: "does not contain", path: "groups", predicate: Contains("admin"), principals: []*principal.Principal{user}, wantStatus: http.StatusForbidden},
+		{name: "one of", path: "tenant.id", predicate: OneOf("globex", "acme"), principals: []*principal.Principal{user}, wantStatus: http.StatusNoContent},
+		{name: "none of", path: "tenant.id", predicate: OneOf("globex"), principals: []*principal.Principal{user}, wantStatus: http.StatusForbidden},
+		{name: "missing principal", path: "tenant.id", predicate: Exists(), wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(New(WithErrorResponder(goauth.JSONErrorResponder{})).RequireClaim(tt.path, tt.predicate), tt.principals...)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code == http.StatusForbidden && errorMessage(t, w) != ErrClaimMismatch.Error()+": "+tt.path {
+				t.Fatalf("error = %q, want the claim mismatch of %s", errorMessage(t, w), tt.path)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package authz
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ClaimPredicate reports whether the value of a claim is acceptable. ok is false when the claim is missing
+type ClaimPredicate func(value any, ok bool) bool
+
+// Exists returns a ClaimPredicate that accepts any value of the claim
+func Exists() ClaimPredicate {
+	return func(value any, ok bool) bool {
+		return ok
+	}
+}
+
+// Equals returns a ClaimPredicate that accepts the claims whose string representation is the value
+func Equals(expected string) ClaimPredicate {
+	return func(value any, ok bool) bool {
+		return ok && fmt.Sprint(value) == expected
+	}
+}
+
+// Contains returns a ClaimPredicate that accepts the list claims (or space-separated strings) with the value
+func Contains(expected string) ClaimPredicate {
+	return func(value any, ok bool) bool {
+		return ok && contains(values(value), expected)
+	}
+}
+
+// OneOf returns a ClaimPredicate that accepts the claims whose string representation is one of the values
+func OneOf(expected ...string) ClaimPredicate {
+	return func(value any, ok bool) bool {
+		return ok && contains(expected, fmt.Sprint(value))
+	}
+}
+
+// Claim returns the value of the claim on the dot-separated path (e.g. realm_access.roles)
+func Claim(claims map[string]any, path string) (any, bool) {
+	var value any = claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		value, ok = object[name]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// claimValues returns the values of the claims on the paths
+func claimValues(claims map[string]any, paths []string) []string {
+	result := []string{}
+	for _, path := range paths {
+		if value, ok := Claim(claims, path); ok {
+			result = append(result, values(value)...)
+		}
+	}
+	return result
+}
+
+// values returns the string values of a list claim or the fields of a space-separated string claim
+func values(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []string:
+		return v
+	case []any:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			result = append(result, fmt.Sprint(item))
+		}
+		return result
+	default:
+		return nil
+	}
+}
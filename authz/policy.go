@@ -0,0 +1,255 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/bancodobrasil/goauth/log"
+	"github.com/bancodobrasil/goauth/pkg/expr"
+	"github.com/bancodobrasil/goauth/principal"
+)
+
+// ErrPolicyDenied is returned when the policy denies the request
+var ErrPolicyDenied = errors.New("Access denied by policy")
+
+// Input is the request the policies decide on
+type Input struct {
+	// Principals are the principals stored by the authentication middleware
+	Principals []*principal.Principal
+	// Request is the HTTP request
+	Request *http.Request
+	// Params are the path parameters of the matched route
+	Params map[string]string
+}
+
+// NewInput returns the Input of the request
+func NewInput(r *http.Request) *Input {
+	return &Input{
+		Principals: principal.AllFromContext(r.Context()),
+		Request:    r,
+		Params:     PathParams(r.Context()),
+	}
+}
+
+// Attributes returns the variables of the expressions: principal (the first principal, or null),
+// principals and request (method, path, host, ip, headers, query and params)
+func (in *Input) Attributes() map[string]any {
+	principals := make([]any, 0, len(in.Principals))
+	for _, p := range in.Principals {
+		principals = append(principals, principalAttributes(p))
+	}
+	var first any
+	if len(principals) > 0 {
+		first = principals[0]
+	}
+	return map[string]any{
+		"principal":  first,
+		"principals": principals,
+		"request":    requestAttributes(in.Request, in.Params),
+	}
+}
+
+// principalAttributes returns the attributes of the principal
+func principalAttributes(p *principal.Principal) map[string]any {
+	claims := p.Claims
+	if claims == nil {
+		claims = map[string]any{}
+	}
+	return map[string]any{
+		"subject":    p.Subject,
+		"issuer":     p.Issuer,
+		"audiences":  p.Audiences,
+		"scopes":     p.Scopes,
+		"roles":      p.Roles,
+		"claims":     claims,
+		"handler":    p.Handler,
+		"api_key_id": p.APIKeyID,
+	}
+}
+
+// requestAttributes returns the attributes of the request. The header names are lower case
+func requestAttributes(r *http.Request, params map[string]string) map[string]any {
+	headers := map[string]any{}
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+	query := map[string]any{}
+	for name, values := range r.URL.Query() {
+		query[name] = values[0]
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if params == nil {
+		params = map[string]string{}
+	}
+	return map[string]any{
+		"method":  r.Method,
+		"path":    r.URL.Path,
+		"host":    r.Host,
+		"ip":      ip,
+		"headers": headers,
+		"query":   query,
+		"params":  params,
+	}
+}
+
+// Decision is the result of a policy
+type Decision struct {
+	// Allowed is true if the request is allowed
+	Allowed bool
+	// Policy is the name of the policy
+	Policy string
+	// Rule is the name of the rule that allowed or denied the request. Empty if no rule matched
+	Rule string
+	// Reason explains the decision
+	Reason string
+}
+
+// Policy decides whether a request is allowed
+type Policy interface {
+	Decide(in *Input) Decision
+}
+
+// PolicyFunc is an adapter to use ordinary functions as Policy
+type PolicyFunc func(in *Input) Decision
+
+// Decide calls f(in)
+func (f PolicyFunc) Decide(in *Input) Decision {
+	return f(in)
+}
+
+// Rule is a rule of an ExpressionPolicy
+type Rule struct {
+	// Name identifies the rule on the decision log
+	Name string `mapstructure:"name"`
+	// Effect is allow or deny
+	Effect string `mapstructure:"effect"`
+	// When is the boolean expression (see package github.com/bancodobrasil/goauth/pkg/expr)
+	// that makes the rule apply to the request
+	When string `mapstructure:"when"`
+}
+
+// compiledRule is a Rule with the compiled expression
+type compiledRule struct {
+	name  string
+	allow bool
+	when  *expr.Expression
+}
+
+// ExpressionPolicy is a Policy of rules with expressions over the principal and the request attributes
+// (see Input.Attributes). The first rule whose expression is true decides; if none is, the request is denied
+type ExpressionPolicy struct {
+	name  string
+	rules []compiledRule
+}
+
+// NewExpressionPolicy compiles the rules of the policy
+func NewExpressionPolicy(name string, rules []Rule) (*ExpressionPolicy, error) {
+	policy := &ExpressionPolicy{name: name}
+	for i, rule := range rules {
+		ruleName := rule.Name
+		if ruleName == "" {
+			ruleName = fmt.Sprintf("#%d", i)
+		}
+		var allow bool
+		switch strings.ToLower(rule.Effect) {
+		case "allow":
+			allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("invalid effect %q on rule %s", rule.Effect, ruleName)
+		}
+		when, err := expr.Compile(rule.When)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression on rule %s: %w", ruleName, err)
+		}
+		policy.rules = append(policy.rules, compiledRule{name: ruleName, allow: allow, when: when})
+	}
+	return policy, nil
+}
+
+// Decide implements the Policy interface. A rule whose expression fails denies the request
+func (p *ExpressionPolicy) Decide(in *Input) Decision {
+	attributes := in.Attributes()
+	for _, rule := range p.rules {
+		matched, err := rule.when.EvalBool(attributes)
+		if err != nil {
+			return Decision{Policy: p.name, Rule: rule.name, Reason: err.Error()}
+		}
+		if matched {
+			return Decision{Allowed: rule.allow, Policy: p.name, Rule: rule.name, Reason: rule.when.String()}
+		}
+	}
+	return Decision{Policy: p.name, Reason: "No rule matched"}
+}
+
+// DecisionLogger records the decisions of the policies
+type DecisionLogger interface {
+	LogDecision(r *http.Request, d Decision)
+}
+
+// DecisionLoggerFunc is an adapter to use ordinary functions as DecisionLogger
+type DecisionLoggerFunc func(r *http.Request, d Decision)
+
+// LogDecision calls f(r, d)
+func (f DecisionLoggerFunc) LogDecision(r *http.Request, d Decision) {
+	f(r, d)
+}
+
+// logDecision is the default DecisionLogger, which logs the decisions with the logger of the library
+func logDecision(r *http.Request, d Decision) {
+	effect := "denied"
+	if d.Allowed {
+		effect = "allowed"
+	}
+	subject := ""
+	if p, ok := principal.FromContext(r.Context()); ok {
+		subject = p.Subject
+	}
+	log.Logf(log.Info, "authz: %s %s %s for %q by policy %q rule %q: %s", r.Method, r.URL.Path, effect, subject, d.Policy, d.Rule, d.Reason)
+}
+
+// Require returns a middleware that lets the request proceed if the policy allows it
+func (a *Authorizer) Require(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			in := NewInput(r)
+			decision := policy.Decide(in)
+			a.decisionLogger().LogDecision(r, decision)
+			if decision.Allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+			statusCode := http.StatusForbidden
+			if len(in.Principals) == 0 {
+				statusCode = http.StatusUnauthorized
+			}
+			a.deny(w, r, statusCode, ErrPolicyDenied, nil)
+		})
+	}
+}
+
+// Require returns a middleware of the default Authorizer that lets the request proceed if the policy allows it
+func Require(policy Policy) func(http.Handler) http.Handler {
+	return defaultAuthorizer.Require(policy)
+}
+
+// paramsContextKey is the context key of the path parameters
+type paramsContextKey struct{}
+
+// WithPathParams returns a copy of ctx that carries the path parameters
+func WithPathParams(ctx context.Context, params map[string]string) context.Context {
+	return context.WithValue(ctx, paramsContextKey{}, params)
+}
+
+// PathParams returns the path parameters of the route matched by the RouteTable
+func PathParams(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(paramsContextKey{}).(map[string]string)
+	return params
+}
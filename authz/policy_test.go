@@ -0,0 +1,183 @@
+package authz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bancodobrasil/goauth"
+	"github.com/bancodobrasil/goauth/principal"
+)
+
+// decisionRecorder is a DecisionLogger that records the decisions
+type decisionRecorder struct {
+	decisions []Decision
+	requests  []*http.Request
+}
+
+// LogDecision implements the DecisionLogger interface
+func (d *decisionRecorder) LogDecision(r *http.Request, decision Decision) {
+	d.decisions = append(d.decisions, decision)
+	d.requests = append(d.requests, r)
+}
+
+func TestRequire(t *testing.T) {
+	policy, err := NewExpressionPolicy("tenants", []Rule{
+		{Name: "block-partners-network", Effect: "deny", When: `inCIDR(request.ip, "198.51.100.0/24")`},
+		{Name: "risk", Effect: "deny", When: `has(principal.claims.risk) && principal.claims.risk > 3`},
+		{Name: "tenant-admin", Effect: "allow", When: `principal.claims.tenant == request.params.tenant && "admin" in principal.roles`},
+		{Name: "tenant-read", Effect: "Allow", When: `principal.claims.tenant == request.params.tenant && request.method == "GET"`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &principal.Principal{Subject: "alice", Roles: []string{"admin"}, Claims: map[string]any{"tenant": "acme"}}
+	viewer := &principal.Principal{Subject: "bob", Claims: map[string]any{"tenant": "acme", "risk": float64(1)}}
+	other := &principal.Principal{Subject: "carol", Roles: []string{"admin"}, Claims: map[string]any{"tenant": "globex"}}
+	invalid := &principal.Principal{Subject: "dave", Roles: []string{"admin"}, Claims: map[string]any{"tenant": "acme", "risk": "high"}}
+
+	tests := []struct {
+		name       string
+		method     string
+		remoteAddr string
+		principal  *principal.Principal
+		wantStatus int
+		wantRule   string
+		wantReason string
+	}{
+		{
+			name:       "allowed by the admin rule",
+			method:     http.MethodDelete,
+			principal:  admin,
+			wantStatus: http.StatusNoContent,
+			wantRule:   "tenant-admin",
+			wantReason: `principal.claims.tenant == request.params.tenant && "admin" in principal.roles`,
+		},
+		{name: "allowed by the read rule", method: http.MethodGet, principal: viewer, wantStatus: http.StatusNoContent, wantRule: "tenant-read"},
+		{name: "no rule matched", method: http.MethodDelete, principal: viewer, wantStatus: http.StatusForbidden, wantReason: "No rule matched"},
+		{name: "other tenant", method: http.MethodGet, principal: other, wantStatus: http.StatusForbidden, wantReason: "No rule matched"},
+		{
+			name:       "denied by the network rule",
+			method:     http.MethodGet,
+			remoteAddr: "198.51.100.7:5000",
+			principal:  admin,
+			wantStatus: http.StatusForbidden,
+			wantRule:   "block-partners-network",
+		},
+		{
+			name:       "evaluation error fails closed",
+			method:     http.MethodGet,
+			principal:  invalid,
+			wantStatus: http.StatusForbidden,
+			wantRule:   "risk",
+			wantReason: "string",
+		},
+		{name: "anonymous", method: http.MethodGet, wantStatus: http.StatusUnauthorized, wantReason: "No rule matched"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &decisionRecorder{}
+			a := New(WithDecisionLogger(recorder), WithErrorResponder(goauth.JSONErrorResponder{}))
+
+			r := httptest.NewRequest(tt.method, "/tenants/acme/orders", nil)
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			ctx := WithPathParams(r.Context(), map[string]string{"tenant": "acme"})
+			if tt.principal != nil {
+				ctx = principal.NewContext(ctx, tt.principal)
+			}
+			w := httptest.NewRecorder()
+			a.Require(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(w, r.WithContext(ctx))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code != http.StatusNoContent && errorMessage(t, w) != ErrPolicyDenied.Error() {
+				t.Fatalf("error = %q, want %q", errorMessage(t, w), ErrPolicyDenied)
+			}
+
+			if len(recorder.decisions) != 1 {
+				t.Fatalf("logged %d decisions, want 1", len(recorder.decisions))
+			}
+			d := recorder.decisions[0]
+			if d.Allowed != (tt.wantStatus == http.StatusNoContent) || d.Policy != "tenants" || d.Rule != tt.wantRule {
+				t.Fatalf("decision = %+v, want the rule %q", d, tt.wantRule)
+			}
+			if !strings.Contains(d.Reason, tt.wantReason) {
+				t.Fatalf("decision reason = %q, want %q", d.Reason, tt.wantReason)
+			}
+			if recorder.requests[0].URL.Path != "/tenants/acme/orders" {
+				t.Fatalf("decision logged for %s", recorder.requests[0].URL)
+			}
+		})
+	}
+}
+
+func TestRequirePolicyFunc(t *testing.T) {
+	recorder := &decisionRecorder{}
+	a := New(WithDecisionLogger(recorder), WithErrorResponder(goauth.JSONErrorResponder{}))
+	policy := PolicyFunc(func(in *Input) Decision {
+		attributes := in.Attributes()
+		request := attributes["request"].(map[string]any)
+		headers := request["headers"].(map[string]any)
+		query := request["query"].(map[string]any)
+		allowed := headers["x-tenant"] == "acme" && query["page"] == "1" && attributes["principal"] != nil
+		return Decision{Allowed: allowed, Policy: "func", Reason: "tenant header"}
+	})
+	middleware := a.Require(policy)
+
+	w := serve(middleware, &principal.Principal{Subject: "alice"})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/orders?page=1", nil)
+	r.Header.Set("X-Tenant", "acme")
+	w = httptest.NewRecorder()
+	middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(w, r.WithContext(principal.NewContext(r.Context(), &principal.Principal{Subject: "alice"})))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204", w.Code)
+	}
+
+	if len(recorder.decisions) != 2 || recorder.decisions[0].Allowed || !recorder.decisions[1].Allowed {
+		t.Fatalf("decisions = %+v, want a denial and an allowance", recorder.decisions)
+	}
+}
+
+func TestNewExpressionPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []Rule
+		wantErr string
+	}{
+		{name: "invalid effect", rules: []Rule{{Name: "r", Effect: "permit", When: "true"}}, wantErr: `invalid effect "permit" on rule r`},
+		{name: "invalid expression", rules: []Rule{{Effect: "allow", When: "true"}, {Effect: "allow", When: "request.method =="}}, wantErr: "invalid expression on rule #1"},
+		{name: "empty expression", rules: []Rule{{Name: "r", Effect: "deny"}}, wantErr: "invalid expression on rule r"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewExpressionPolicy("policy", tt.rules)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("NewExpressionPolicy() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	policy, err := NewExpressionPolicy("policy", []Rule{{Effect: "deny", When: `request.method == "DELETE"`}, {Effect: "allow", When: "true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := policy.Decide(NewInput(httptest.NewRequest(http.MethodDelete, "/", nil)))
+	if d.Allowed || d.Rule != "#0" {
+		t.Fatalf("Decide() = %+v, want denied by the rule #0", d)
+	}
+}
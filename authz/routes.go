@@ -0,0 +1,235 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/bancodobrasil/goauth"
+	"github.com/spf13/viper"
+)
+
+// ErrNoRoute is returned when the request does not match any route of the RouteTable
+var ErrNoRoute = errors.New("No route policy")
+
+// Route is the authentication and authorization policy of a set of routes
+type Route struct {
+	// Path is the path pattern of the route. Each segment is matched with path.Match (e.g. /users/*/orders),
+	// a {name} segment matches any segment and captures it as a path parameter (see PathParams),
+	// and a final /** segment matches any number of segments (e.g. /internal/**)
+	Path string `mapstructure:"path"`
+	// Methods are the HTTP methods of the route. If empty, all methods match
+	Methods []string `mapstructure:"methods"`
+	// Handlers are the names of the authentication handlers of the route (see goauth.NamedHandlers).
+	// If empty, the handlers of the default goauth middleware are used
+	Handlers []string `mapstructure:"handlers"`
+	// RequireAll makes the route require all the handlers to succeed, instead of any of them
+	RequireAll bool `mapstructure:"require_all"`
+	// Scopes are the scopes the principal must have
+	Scopes []string `mapstructure:"scopes"`
+	// Roles are the roles the principal must have at least one of
+	Roles []string `mapstructure:"roles"`
+	// Anonymous lets the requests without credentials proceed without a principal
+	Anonymous bool `mapstructure:"anonymous"`
+	// Rules are the rules of the ExpressionPolicy of the route, checked after the scopes and roles
+	Rules []Rule `mapstructure:"rules"`
+}
+
+// match reports whether the route matches the request, and returns its path parameters
+func (rt *Route) match(r *http.Request) (map[string]string, bool) {
+	if len(rt.Methods) > 0 {
+		found := false
+		for _, method := range rt.Methods {
+			if strings.EqualFold(method, r.Method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+
+	patternSegments := strings.Split(strings.Trim(rt.Path, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path.Clean("/"+r.URL.Path), "/"), "/")
+	params := map[string]string{}
+	for i, pattern := range patternSegments {
+		if pattern == "**" && i == len(patternSegments)-1 {
+			return params, true
+		}
+		if i >= len(pathSegments) {
+			return nil, false
+		}
+		if strings.HasPrefix(pattern, "{") && strings.HasSuffix(pattern, "}") {
+			params[pattern[1:len(pattern)-1]] = pathSegments[i]
+			continue
+		}
+		if matched, _ := path.Match(pattern, pathSegments[i]); !matched {
+			return nil, false
+		}
+	}
+	if len(pathSegments) != len(patternSegments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// RouteTable applies the policy of the first route that matches the request.
+// Requests that do not match any route are denied
+type RouteTable struct {
+	routes     []Route
+	policies   []Policy
+	handlers   map[string]goauth.AuthHandler
+	authorizer *Authorizer
+}
+
+// NewRouteTable returns a new RouteTable. The handler names of the routes are looked up on handlers
+func NewRouteTable(routes []Route, handlers map[string]goauth.AuthHandler, opts ...Option) (*RouteTable, error) {
+	policies := make([]Policy, len(routes))
+	for i, route := range routes {
+		if _, err := path.Match(route.Path, "/"); err != nil {
+			return nil, fmt.Errorf("invalid route path %s: %w", route.Path, err)
+		}
+		if len(route.Rules) > 0 {
+			policy, err := NewExpressionPolicy(route.Path, route.Rules)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rules on route %s: %w", route.Path, err)
+			}
+			policies[i] = policy
+		}
+		if route.Anonymous && (len(route.Scopes) > 0 || len(route.Roles) > 0) {
+			return nil, fmt.Errorf("route %s allows anonymous requests, so it can not require scopes or roles", route.Path)
+		}
+		for _, name := range route.Handlers {
+			if _, ok := handlers[strings.ToLower(name)]; !ok {
+				return nil, fmt.Errorf("unknown handler %s on route %s", name, route.Path)
+			}
+		}
+	}
+	return &RouteTable{
+		routes:     routes,
+		policies:   policies,
+		handlers:   handlers,
+		authorizer: New(opts...),
+	}, nil
+}
+
+// LoadRoutes loads the routes from the routes key of a YAML or JSON file
+func LoadRoutes(file string) ([]Route, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	routes := []Route{}
+	if err := v.UnmarshalKey("routes", &routes); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// BootstrapRoutes returns the RouteTable of the routes file on GOAUTH_ROUTES_FILE, with the handlers
+// set up by goauth.BootstrapMiddleware. It must be called after goauth.BootstrapMiddleware
+func BootstrapRoutes(opts ...Option) (*RouteTable, error) {
+	viper.BindEnv("GOAUTH_ROUTES_FILE")
+	file := viper.GetString("GOAUTH_ROUTES_FILE")
+	if file == "" {
+		return nil, errors.New("GOAUTH_ROUTES_FILE is required when using the route table")
+	}
+	routes, err := LoadRoutes(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewRouteTable(routes, goauth.NamedHandlers(), opts...)
+}
+
+// Handler runs the authentication handlers and the authorization checks of the route that matches the request
+func (t *RouteTable) Handler(next http.Handler) http.Handler {
+	routeHandlers := make([]http.Handler, len(t.routes))
+	for i := range t.routes {
+		routeHandlers[i] = t.routeHandler(&t.routes[i], t.policies[i], next)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := range t.routes {
+			if params, ok := t.routes[i].match(r); ok {
+				routeHandlers[i].ServeHTTP(w, r.WithContext(WithPathParams(r.Context(), params)))
+				return
+			}
+		}
+		t.authorizer.deny(w, r, http.StatusForbidden, ErrNoRoute, nil)
+	})
+}
+
+// routeHandler returns the handler that applies the policy of the route
+func (t *RouteTable) routeHandler(route *Route, policy Policy, next http.Handler) http.Handler {
+	h := next
+	if policy != nil {
+		h = t.authorizer.Require(policy)(h)
+	}
+	if len(route.Roles) > 0 {
+		h = t.authorizer.RequireAnyRole(route.Roles...)(h)
+	}
+	if len(route.Scopes) > 0 {
+		h = t.authorizer.RequireScopes(route.Scopes...)(h)
+	}
+
+	unauthenticated := h
+	if !route.Anonymous {
+		unauthenticated = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.authorizer.deny(w, r, http.StatusUnauthorized, ErrUnauthenticated, nil)
+		})
+	}
+
+	if len(route.Handlers) == 0 {
+		authenticated := t.authenticate(route, defaultHandlers{requireAll: route.RequireAll}, h)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(goauth.GetHandlers()) == 0 {
+				unauthenticated.ServeHTTP(w, r)
+				return
+			}
+			authenticated.ServeHTTP(w, r)
+		})
+	}
+
+	handlers := make([]goauth.AuthHandler, 0, len(route.Handlers))
+	for _, name := range route.Handlers {
+		handlers = append(handlers, t.handlers[strings.ToLower(name)])
+	}
+	chain := goauth.Any(handlers...)
+	if route.RequireAll {
+		chain = goauth.All(handlers...)
+	}
+	return t.authenticate(route, chain, h)
+}
+
+// authenticate returns the handler that runs the authentication handler of the route before h
+func (t *RouteTable) authenticate(route *Route, chain goauth.AuthHandler, h http.Handler) http.Handler {
+	return goauth.New(
+		goauth.WithHandlers(chain),
+		goauth.WithAnonymous(route.Anonymous),
+		goauth.WithErrorResponder(goauth.ErrorResponderFunc(func(w http.ResponseWriter, r *http.Request, err *goauth.AuthMiddlewareError) {
+			t.authorizer.errorResponder().Respond(w, r, err)
+		})),
+	).Handler(h)
+}
+
+// defaultHandlers runs the handlers of the default goauth middleware, looked up on each request,
+// so the handlers set later by goauth.SetHandlers or goauth.BootstrapMiddleware are used
+type defaultHandlers struct {
+	requireAll bool
+}
+
+// Handle runs the handlers of the default goauth middleware
+func (d defaultHandlers) Handle(r *http.Request) (*http.Request, int, error) {
+	handlers := goauth.GetHandlers()
+	if len(handlers) == 0 {
+		return r, http.StatusUnauthorized, ErrUnauthenticated
+	}
+	if d.requireAll {
+		return goauth.All(handlers...).Handle(r)
+	}
+	return goauth.Any(handlers...).Handle(r)
+}
@@ -0,0 +1,64 @@
+package authz
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bancodobrasil/goauth"
+	"github.com/bancodobrasil/goauth/principal"
+)
+
+// headerHandler authenticates the requests with the header, and skips the others
+type headerHandler string
+
+func (h headerHandler) Handle(r *http.Request) (*http.Request, int, error) {
+	subject := r.Header.Get(string(h))
+	if subject == "" {
+		return r, http.StatusUnauthorized, errors.New("Missing " + string(h) + " Header")
+	}
+	return r.WithContext(principal.NewContext(r.Context(), &principal.Principal{Subject: subject})), 0, nil
+}
+
+func TestRouteTableDefaultHandlersAreLookedUpOnEachRequest(t *testing.T) {
+	defer goauth.SetHandlers(goauth.GetHandlers())
+	goauth.SetHandlers(nil)
+
+	table, err := NewRouteTable([]Route{{Path: "/**"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := table.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(header, value string) int {
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := serve("X-User", "alice"); code != http.StatusUnauthorized {
+		t.Fatalf("without default handlers: status = %d, want 401", code)
+	}
+
+	goauth.SetHandlers([]goauth.AuthHandler{headerHandler("X-User")})
+	if code := serve("X-User", "alice"); code != http.StatusNoContent {
+		t.Fatalf("after SetHandlers: status = %d, want 204", code)
+	}
+	if code := serve("", ""); code != http.StatusUnauthorized {
+		t.Fatalf("after SetHandlers, without credentials: status = %d, want 401", code)
+	}
+
+	goauth.SetHandlers([]goauth.AuthHandler{headerHandler("X-Service")})
+	if code := serve("X-User", "alice"); code != http.StatusUnauthorized {
+		t.Fatalf("after replacing the handlers: status = %d, want 401", code)
+	}
+	if code := serve("X-Service", "billing"); code != http.StatusNoContent {
+		t.Fatalf("after replacing the handlers: status = %d, want 204", code)
+	}
+}
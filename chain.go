@@ -0,0 +1,101 @@
+package goauth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bancodobrasil/goauth/handler"
+	"github.com/bancodobrasil/goauth/log"
+)
+
+// chain is an AuthHandler that requires a minimum number of its handlers to succeed
+type chain struct {
+	handlers   []AuthHandler
+	required   int
+	failClosed bool
+}
+
+// Any returns an AuthHandler that succeeds on the first of the handlers that succeeds.
+// The handlers are executed in order and each one receives the original request.
+func Any(handlers ...AuthHandler) AuthHandler {
+	return newChain("Any", handlers, 1)
+}
+
+// All returns an AuthHandler that succeeds only if all the handlers succeed
+// (e.g. require a client certificate AND a JWT). The handlers are executed in order
+// and each one receives the request enriched by the previous one, so the principals
+// of all handlers are stored in the request context.
+func All(handlers ...AuthHandler) AuthHandler {
+	return newChain("All", handlers, len(handlers))
+}
+
+// AtLeast returns an AuthHandler that succeeds once n of the handlers succeed.
+// The handlers are executed in order and each one receives the request enriched by
+// the previous successful one, so the principals of the successful handlers are
+// stored in the request context. n must be at least 1.
+func AtLeast(n int, handlers ...AuthHandler) AuthHandler {
+	return newChain("AtLeast", handlers, n)
+}
+
+// newChain returns a chain that requires the successes of required handlers. A chain without
+// handlers or that requires less than one success would authenticate every request, so it is rejected
+func newChain(name string, handlers []AuthHandler, required int) *chain {
+	if len(handlers) == 0 {
+		log.Logf(log.Panic, "%s requires at least one handler", name)
+	}
+	if required < 1 {
+		log.Logf(log.Panic, "%s requires at least one handler to succeed, got %d", name, required)
+	}
+	return &chain{handlers: handlers, required: required}
+}
+
+// Handle runs the handlers of the chain. When the chain fails, the error of the first
+// handler that denied the request is returned, so a request with invalid credentials
+// is not reported as missing the credentials of the other handlers. The returned error
+// carries the WWW-Authenticate challenges of all the handlers that were executed.
+// If the chain fails closed, it stops on the first handler that denies the request.
+func (c *chain) Handle(r *http.Request) (request *http.Request, statusCode int, err error) {
+	request = r
+	successes := 0
+	outcome := Authenticated
+	challenges := []handler.Challenge{}
+
+	if c.required < 1 || len(c.handlers) == 0 {
+		return r, http.StatusUnauthorized, errors.New("Unauthorized")
+	}
+
+	for i, h := range c.handlers {
+		if successes >= c.required || len(c.handlers)-i < c.required-successes {
+			break
+		}
+
+		enriched, code, handlerErr := h.Handle(request)
+		challenges = append(challenges, handler.ChallengesOf(handlerErr)...)
+		switch OutcomeOf(handlerErr) {
+		case Authenticated:
+			request = enriched
+			successes++
+			continue
+		case Denied:
+			if outcome != Denied {
+				statusCode, err, outcome = code, handlerErr, Denied
+			}
+			if c.failClosed {
+				return r, statusCode, handler.WithChallenge(err, challenges...)
+			}
+		case Skipped:
+			if outcome != Denied {
+				statusCode, err, outcome = code, handlerErr, Skipped
+			}
+		}
+	}
+
+	if successes >= c.required {
+		return request, 0, nil
+	}
+
+	if err == nil {
+		statusCode, err = http.StatusUnauthorized, errors.New("Unauthorized")
+	}
+	return r, statusCode, handler.WithChallenge(err, challenges...)
+}
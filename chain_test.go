@@ -0,0 +1,83 @@
+package goauth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bancodobrasil/goauth/log"
+)
+
+// allowHandler is an AuthHandler that authenticates every request
+type allowHandler struct{}
+
+func (allowHandler) Handle(r *http.Request) (*http.Request, int, error) {
+	return r, 0, nil
+}
+
+// denyHandler is an AuthHandler that denies every request
+type denyHandler struct{}
+
+func (denyHandler) Handle(r *http.Request) (*http.Request, int, error) {
+	return r, http.StatusUnauthorized, errors.New("Invalid credentials")
+}
+
+func TestMiddlewareFailClosed(t *testing.T) {
+	tests := []struct {
+		name       string
+		failClosed bool
+		wantStatus int
+	}{
+		{name: "next handlers run by default", wantStatus: http.StatusNoContent},
+		{name: "fail closed", failClosed: true, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(WithHandlers(denyHandler{}, allowHandler{}), WithFailClosed(tt.failClosed))
+			w := httptest.NewRecorder()
+			m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestChainRejectsEmptyRequirements(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() AuthHandler
+	}{
+		{name: "AtLeast zero", build: func() AuthHandler { return AtLeast(0, allowHandler{}) }},
+		{name: "AtLeast negative", build: func() AuthHandler { return AtLeast(-1, allowHandler{}) }},
+		{name: "AtLeast without handlers", build: func() AuthHandler { return AtLeast(1) }},
+		{name: "All without handlers", build: func() AuthHandler { return All() }},
+		{name: "Any without handlers", build: func() AuthHandler { return Any() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Run("panics when built", func(t *testing.T) {
+				log.SetLogger(log.NewDefaultLogger(log.Panic))
+				defer log.SetLogger(nil)
+				defer func() {
+					if recover() == nil {
+						t.Fatal("the chain was built, want a panic")
+					}
+				}()
+				tt.build()
+			})
+
+			t.Run("fails closed without a panicking logger", func(t *testing.T) {
+				_, status, err := tt.build().Handle(httptest.NewRequest(http.MethodGet, "/", nil))
+				if err == nil || status != http.StatusUnauthorized {
+					t.Fatalf("Handle() = %d, %v, want 401 and an error", status, err)
+				}
+			})
+		})
+	}
+}
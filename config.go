@@ -2,11 +2,17 @@ package goauth
 
 import (
 	"context"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bancodobrasil/goauth/handler"
 	"github.com/bancodobrasil/goauth/log"
+	"github.com/bancodobrasil/goauth/pkg/htpasswd"
 	"github.com/spf13/viper"
 )
 
@@ -14,8 +20,143 @@ import (
 type APIKeyConfig struct {
 	// Header is the header to be used on the VerifyAPIKey handler. Defaults to X-API-Key
 	Header string `mapstructure:"GOAUTH_API_KEY_HEADER"`
+	// Sources is the list of places the API key is read from, tried in order, separated by comma.
+	// Each entry is header:<name>, query:<name>, cookie:<name> or authorization:<scheme>. Defaults to the Header
+	Sources []string `mapstructure:"GOAUTH_API_KEY_SOURCES"`
 	// KeyList is the list of API keys to be used on the VerifyAPIKey handler, separated by comma
 	KeyList []string `mapstructure:"GOAUTH_API_KEY_LIST"`
+	// KeyFile is the path of a JSON or YAML file with the hashed API keys and their metadata
+	KeyFile string `mapstructure:"GOAUTH_API_KEY_FILE"`
+}
+
+// BasicConfig is the config to be used on the VerifyBasic handler
+type BasicConfig struct {
+	// Realm is the realm sent on the WWW-Authenticate challenge
+	Realm string `mapstructure:"GOAUTH_BASIC_REALM"`
+	// Users is the list of users in the htpasswd format, one user:hash entry per line
+	Users string `mapstructure:"GOAUTH_BASIC_USERS"`
+	// HtpasswdFile is the path of an htpasswd file, reloaded when it changes
+	HtpasswdFile string `mapstructure:"GOAUTH_BASIC_HTPASSWD_FILE"`
+}
+
+// ClientCertConfig is the config to be used on the VerifyClientCert handler
+type ClientCertConfig struct {
+	// CAFile is the path of a PEM file with the trusted CA certificates. It is required
+	CAFile string `mapstructure:"GOAUTH_CLIENT_CERT_CA_FILE"`
+	// AllowedSubjects is the list of patterns of the allowed subject common or distinguished names, separated by comma
+	AllowedSubjects []string `mapstructure:"GOAUTH_CLIENT_CERT_ALLOWED_SUBJECTS"`
+	// AllowedSANs is the list of patterns of the allowed subject alternative names, separated by comma
+	AllowedSANs []string `mapstructure:"GOAUTH_CLIENT_CERT_ALLOWED_SANS"`
+	// AllowedSPIFFEIDs is the list of patterns of the allowed SPIFFE IDs, separated by comma
+	AllowedSPIFFEIDs []string `mapstructure:"GOAUTH_CLIENT_CERT_ALLOWED_SPIFFE_IDS"`
+	// ProxyHeader is the header with the client certificate set by a trusted proxy, e.g. X-Forwarded-Client-Cert
+	ProxyHeader string `mapstructure:"GOAUTH_CLIENT_CERT_PROXY_HEADER"`
+	// TrustedProxies is the list of IP addresses or CIDR ranges of the trusted proxies, separated by comma
+	TrustedProxies []string `mapstructure:"GOAUTH_CLIENT_CERT_TRUSTED_PROXIES"`
+}
+
+// DPoPConfig is the config to be used on the VerifyDPoP handler
+type DPoPConfig struct {
+	// Handlers is the list of token handlers (introspection, jwks or jwt) wrapped by the DPoP verification, separated by comma
+	Handlers []string `mapstructure:"GOAUTH_DPOP_HANDLERS"`
+	// Required makes the requests without a DPoP proof be rejected. Defaults to false (only the DPoP-bound tokens require a proof)
+	Required bool `mapstructure:"GOAUTH_DPOP_REQUIRED"`
+	// Algorithms is the allow-list of the signature algorithms of the proofs, separated by comma. Defaults to all the asymmetric algorithms
+	Algorithms []string `mapstructure:"GOAUTH_DPOP_ALGORITHMS"`
+	// MaxAge is the maximum age of the proofs, in seconds. Defaults to 300
+	MaxAge int `mapstructure:"GOAUTH_DPOP_MAX_AGE"`
+	// ClockSkew is the allowed clock skew when validating the proofs, in seconds. Defaults to 0
+	ClockSkew int `mapstructure:"GOAUTH_DPOP_CLOCK_SKEW"`
+	// NonceTTL is how long the DPoP nonces are valid, in seconds. Defaults to 0 (nonces are not required)
+	NonceTTL int `mapstructure:"GOAUTH_DPOP_NONCE_TTL"`
+	// BaseURL is the external URL of the server, used to check the htu claim of the proofs behind a proxy
+	BaseURL string `mapstructure:"GOAUTH_DPOP_BASE_URL"`
+	// Realm is the realm sent on the WWW-Authenticate challenge
+	Realm string `mapstructure:"GOAUTH_DPOP_REALM"`
+}
+
+// HMACConfig is the config to be used on the VerifyHMAC handler
+type HMACConfig struct {
+	// Style presets the settings for the webhooks of a provider: github or stripe
+	Style string `mapstructure:"GOAUTH_HMAC_STYLE"`
+	// Secrets is the list of shared secrets, separated by comma. A signature made with any of them is accepted
+	Secrets []string `mapstructure:"GOAUTH_HMAC_SECRETS"`
+	// Hash is the hash function of the HMAC: sha1, sha256 or sha512. Defaults to sha256
+	Hash string `mapstructure:"GOAUTH_HMAC_HASH"`
+	// Header is the header with the signature. Defaults to the header of the Style
+	Header string `mapstructure:"GOAUTH_HMAC_HEADER"`
+	// Prefix is the prefix of the signature on the Header, e.g. sha256=
+	Prefix string `mapstructure:"GOAUTH_HMAC_PREFIX"`
+	// Encoding is the encoding of the signature: hex or base64. Defaults to hex
+	Encoding string `mapstructure:"GOAUTH_HMAC_ENCODING"`
+	// TimestampHeader is the header with the Unix timestamp of the request. The Payload must include the {timestamp}
+	TimestampHeader string `mapstructure:"GOAUTH_HMAC_TIMESTAMP_HEADER"`
+	// Payload is the template of the signed string, with the {timestamp} and {body} placeholders. Defaults to {body}
+	Payload string `mapstructure:"GOAUTH_HMAC_PAYLOAD"`
+	// Tolerance is the maximum difference between the timestamp and the current time, in seconds. Defaults to 300
+	Tolerance int `mapstructure:"GOAUTH_HMAC_TOLERANCE"`
+	// MaxBodySize is the maximum size of the signed body, in bytes. Defaults to 10485760
+	MaxBodySize int64 `mapstructure:"GOAUTH_HMAC_MAX_BODY_SIZE"`
+	// Subject is the subject of the principal, e.g. the name of the integration
+	Subject string `mapstructure:"GOAUTH_HMAC_SUBJECT"`
+}
+
+// HTTPSignatureConfig is the config to be used on the VerifyHTTPSignature handler
+type HTTPSignatureConfig struct {
+	// JWKSURL is the JWKS endpoint with the keys of the signatures, looked up by their keyid
+	JWKSURL string `mapstructure:"GOAUTH_HTTP_SIGNATURE_JWKS_URL"`
+	// Keys are the keys of the signatures, as a JWK Set whose keys have their kid. Used when JWKSURL is not set
+	Keys string `mapstructure:"GOAUTH_HTTP_SIGNATURE_KEYS"`
+	// KeyFiles is the list of files with the keys of the signatures, separated by comma. Used when JWKSURL is not set
+	KeyFiles []string `mapstructure:"GOAUTH_HTTP_SIGNATURE_KEY_FILES"`
+	// Algorithms is the allow-list of signature algorithms (e.g. ecdsa-p256-sha256), separated by comma. Defaults to all the asymmetric algorithms
+	Algorithms []string `mapstructure:"GOAUTH_HTTP_SIGNATURE_ALGORITHMS"`
+	// RequiredComponents is the list of components the signatures must cover, separated by comma. Defaults to @method, @authority and @path
+	RequiredComponents []string `mapstructure:"GOAUTH_HTTP_SIGNATURE_REQUIRED_COMPONENTS"`
+	// Label is the label of the signature to verify. Defaults to the first signature
+	Label string `mapstructure:"GOAUTH_HTTP_SIGNATURE_LABEL"`
+	// Tag is the required tag parameter of the signatures
+	Tag string `mapstructure:"GOAUTH_HTTP_SIGNATURE_TAG"`
+	// MaxAge is the maximum time since the signature was created, in seconds. Defaults to 300
+	MaxAge int `mapstructure:"GOAUTH_HTTP_SIGNATURE_MAX_AGE"`
+	// ClockSkew is the allowed clock skew when validating the created and expires parameters, in seconds. Defaults to 0
+	ClockSkew int `mapstructure:"GOAUTH_HTTP_SIGNATURE_CLOCK_SKEW"`
+	// MaxBodySize is the maximum size of the body verified against the Content-Digest, in bytes. Defaults to 10485760
+	MaxBodySize int64 `mapstructure:"GOAUTH_HTTP_SIGNATURE_MAX_BODY_SIZE"`
+	// AllowUnsignedBody accepts the requests with a body whose signature does not cover content-digest. Defaults to false
+	AllowUnsignedBody bool `mapstructure:"GOAUTH_HTTP_SIGNATURE_ALLOW_UNSIGNED_BODY"`
+	// RefreshWindow is the time window before checking if the JWKS cache needs to be refreshed, in seconds. Defaults to 60
+	RefreshWindow int `mapstructure:"GOAUTH_HTTP_SIGNATURE_REFRESH_WINDOW"`
+	// MinRefreshInterval is the minimum interval between JWKS refreshes, in seconds. Defaults to 300
+	MinRefreshInterval int `mapstructure:"GOAUTH_HTTP_SIGNATURE_MIN_REFRESH_INTERVAL"`
+}
+
+// IntrospectionConfig is the config to be used on the VerifyIntrospection handler
+type IntrospectionConfig struct {
+	// Header is the header to be used on the VerifyIntrospection handler. Defaults to Authorization
+	Header string `mapstructure:"GOAUTH_INTROSPECTION_HEADER"`
+	// TokenType is the token type to be used on the VerifyIntrospection handler. Defaults to Bearer
+	TokenType string `mapstructure:"GOAUTH_INTROSPECTION_TOKEN_TYPE"`
+	// Sources is the list of places the token is read from, tried in order, separated by comma (see APIKeyConfig.Sources). Defaults to the Header and TokenType
+	Sources []string `mapstructure:"GOAUTH_INTROSPECTION_SOURCES"`
+	// URL is the token introspection endpoint
+	URL string `mapstructure:"GOAUTH_INTROSPECTION_URL"`
+	// ClientID is the client ID used to call the introspection endpoint
+	ClientID string `mapstructure:"GOAUTH_INTROSPECTION_CLIENT_ID"`
+	// ClientSecret is the client secret used to call the introspection endpoint
+	ClientSecret string `mapstructure:"GOAUTH_INTROSPECTION_CLIENT_SECRET"`
+	// Audiences is the list of accepted token audiences, separated by comma
+	Audiences []string `mapstructure:"GOAUTH_INTROSPECTION_AUDIENCES"`
+	// Scopes is the list of scopes required on the token, separated by comma
+	Scopes []string `mapstructure:"GOAUTH_INTROSPECTION_SCOPES"`
+	// CacheTTL is how long active tokens are cached, in seconds. Defaults to 60
+	CacheTTL int `mapstructure:"GOAUTH_INTROSPECTION_CACHE_TTL"`
+	// NegativeCacheTTL is how long inactive tokens are cached, in seconds. Defaults to 10
+	NegativeCacheTTL int `mapstructure:"GOAUTH_INTROSPECTION_NEGATIVE_CACHE_TTL"`
+	// Timeout is the timeout of the introspection requests, in seconds. Defaults to 10
+	Timeout int `mapstructure:"GOAUTH_INTROSPECTION_TIMEOUT"`
+	// Realm is the realm sent on the WWW-Authenticate challenge
+	Realm string `mapstructure:"GOAUTH_INTROSPECTION_REALM"`
 }
 
 // JWKSConfig is the config to be used on the VerifyJWKS handler
@@ -24,14 +165,41 @@ type JWKSConfig struct {
 	Header string `mapstructure:"GOAUTH_JWKS_HEADER"`
 	// TokenType is the token type to be used on the VerifyJWKS handler. Defaults to Bearer
 	TokenType string `mapstructure:"GOAUTH_JWKS_TOKEN_TYPE"`
+	// Sources is the list of places the token is read from, tried in order, separated by comma (see APIKeyConfig.Sources). Defaults to the Header and TokenType
+	Sources []string `mapstructure:"GOAUTH_JWKS_SOURCES"`
 	// URL is the JWKS endpoint to be used on the VerifyJWKS handler
 	URL string `mapstructure:"GOAUTH_JWKS_URL"`
+	// Issuer is the OpenID Connect issuer URL used to discover the JWKS endpoint
+	Issuer string `mapstructure:"GOAUTH_JWKS_ISSUER"`
+	// IssuerList is the list of accepted issuers, separated by comma. Each entry is either an OpenID Connect
+	// issuer URL, whose JWKS endpoint is discovered, or an issuer=jwks_url pair, optionally followed by its own
+	// ;refresh_window=<seconds> and ;min_refresh_interval=<seconds>
+	IssuerList []string `mapstructure:"GOAUTH_JWKS_ISSUER_LIST"`
+	// AllowOtherIssuers makes the tokens of the issuers not in the IssuerList (or Issuer) be verified with the
+	// key set of the URL, instead of refused. Defaults to false
+	AllowOtherIssuers bool `mapstructure:"GOAUTH_JWKS_ALLOW_OTHER_ISSUERS"`
+	// IssuerHeader is the request header used to select the issuer instead of the iss claim
+	IssuerHeader string `mapstructure:"GOAUTH_JWKS_ISSUER_HEADER"`
+	// Algorithms is the allow-list of signature algorithms, separated by comma. Defaults to all the asymmetric algorithms
+	Algorithms []string `mapstructure:"GOAUTH_JWKS_ALGORITHMS"`
 	// RefreshWindow is the time window before checking if the JWKS cache needs to be refreshed, in seconds. Defaults to 60
 	RefreshWindow int `mapstructure:"GOAUTH_JWKS_REFRESH_WINDOW"`
 	// MinRefreshInterval is the minimum interval between JWKS refreshes, in seconds. Defaults to 300
 	MinRefreshInterval int `mapstructure:"GOAUTH_JWKS_MIN_REFRESH_INTERVAL"`
-	// PayloadContextKey is the context key to store the JWT payload. Defaults to USER
+	// PayloadContextKey is the legacy context key to store the raw JWT payload. Disabled by default
 	PayloadContextKey string `mapstructure:"GOAUTH_JWKS_PAYLOAD_CONTEXT_KEY"`
+	// Issuers is the list of accepted token issuers, separated by comma
+	Issuers []string `mapstructure:"GOAUTH_JWKS_ISSUERS"`
+	// Audiences is the list of accepted token audiences, separated by comma
+	Audiences []string `mapstructure:"GOAUTH_JWKS_AUDIENCES"`
+	// ClockSkew is the allowed clock skew when validating the token time claims, in seconds. Defaults to 0
+	ClockSkew int `mapstructure:"GOAUTH_JWKS_CLOCK_SKEW"`
+	// MaxTokenAge is the maximum time since the token was issued, in seconds. Defaults to 0 (unlimited)
+	MaxTokenAge int `mapstructure:"GOAUTH_JWKS_MAX_TOKEN_AGE"`
+	// Realm is the realm sent on the WWW-Authenticate challenge
+	Realm string `mapstructure:"GOAUTH_JWKS_REALM"`
+	// RequireCertificateBinding rejects the tokens without a cnf.x5t#S256 claim. The claim is always verified against the client certificate (RFC 8705)
+	RequireCertificateBinding bool `mapstructure:"GOAUTH_JWKS_REQUIRE_CERTIFICATE_BINDING"`
 }
 
 // JWTConfig is the config to be used on the VerifyJWT handler
@@ -40,12 +208,36 @@ type JWTConfig struct {
 	Header string `mapstructure:"GOAUTH_JWT_HEADER"`
 	// TokenType is the token type to be used on the VerifyJWT handler. Defaults to Bearer
 	TokenType string `mapstructure:"GOAUTH_JWT_TOKEN_TYPE"`
+	// Sources is the list of places the token is read from, tried in order, separated by comma (see APIKeyConfig.Sources). Defaults to the Header and TokenType
+	Sources []string `mapstructure:"GOAUTH_JWT_SOURCES"`
 	// SignatureKey is the signature key to be used on the VerifyJWT handler
 	SignatureKey string `mapstructure:"GOAUTH_JWT_SIGNATURE_KEY"`
+	// SignatureKeyFile is the path of a file with signature keys. The keys are reloaded when the file changes
+	SignatureKeyFile string `mapstructure:"GOAUTH_JWT_SIGNATURE_KEY_FILE"`
+	// SignatureKeyFiles is the list of files with signature keys, separated by comma. The keys are reloaded when the files change
+	SignatureKeyFiles []string `mapstructure:"GOAUTH_JWT_SIGNATURE_KEY_FILES"`
+	// VerifyCertificates makes the x509 certificates of the signature keys be verified
+	VerifyCertificates bool `mapstructure:"GOAUTH_JWT_VERIFY_CERTIFICATES"`
+	// CertificateRootsFile is the path of a PEM file with the trusted root certificates. Defaults to the system roots
+	CertificateRootsFile string `mapstructure:"GOAUTH_JWT_CERTIFICATE_ROOTS_FILE"`
 	// SignatureAlgorithm is the algorithm used to sign the JWT. Defaults to RS256
 	SignatureAlgorithm string `mapstructure:"GOAUTH_JWT_SIGNATURE_ALGORITHM"`
-	// PayloadContextKey is the context key to store the JWT payload. Defaults to USER
+	// SignatureAlgorithms is the allow-list of signature algorithms, separated by comma. Overrides SignatureAlgorithm
+	SignatureAlgorithms []string `mapstructure:"GOAUTH_JWT_SIGNATURE_ALGORITHMS"`
+	// PayloadContextKey is the legacy context key to store the raw JWT payload. Disabled by default
 	PayloadContextKey string `mapstructure:"GOAUTH_JWT_PAYLOAD_CONTEXT_KEY"`
+	// Issuers is the list of accepted token issuers, separated by comma
+	Issuers []string `mapstructure:"GOAUTH_JWT_ISSUERS"`
+	// Audiences is the list of accepted token audiences, separated by comma
+	Audiences []string `mapstructure:"GOAUTH_JWT_AUDIENCES"`
+	// ClockSkew is the allowed clock skew when validating the token time claims, in seconds. Defaults to 0
+	ClockSkew int `mapstructure:"GOAUTH_JWT_CLOCK_SKEW"`
+	// MaxTokenAge is the maximum time since the token was issued, in seconds. Defaults to 0 (unlimited)
+	MaxTokenAge int `mapstructure:"GOAUTH_JWT_MAX_TOKEN_AGE"`
+	// Realm is the realm sent on the WWW-Authenticate challenge
+	Realm string `mapstructure:"GOAUTH_JWT_REALM"`
+	// RequireCertificateBinding rejects the tokens without a cnf.x5t#S256 claim. The claim is always verified against the client certificate (RFC 8705)
+	RequireCertificateBinding bool `mapstructure:"GOAUTH_JWT_REQUIRE_CERTIFICATE_BINDING"`
 }
 
 // Config stores the configuration for the Goauth middleware
@@ -53,9 +245,34 @@ type Config struct {
 	// AuthHandlers is the list of authentication handlers to be used
 	Handlers []string `mapstructure:"GOAUTH_HANDLERS"`
 
+	// FailClosed aborts the request on the first handler that finds invalid credentials. Defaults to false,
+	// so the next handlers still run and may authenticate the request
+	FailClosed bool `mapstructure:"GOAUTH_FAIL_CLOSED"`
+
+	// ErrorFormat is the format of the error responses: json, problem (RFC 7807) or text. Defaults to json
+	ErrorFormat string `mapstructure:"GOAUTH_ERROR_FORMAT"`
+
 	// APIKeyConfig stores the configuration for the VerifyAPIKey handler
 	APIKeyConfig APIKeyConfig `mapstructure:",squash"`
 
+	// BasicConfig stores the configuration for the VerifyBasic handler
+	BasicConfig BasicConfig `mapstructure:",squash"`
+
+	// ClientCertConfig stores the configuration for the VerifyClientCert handler
+	ClientCertConfig ClientCertConfig `mapstructure:",squash"`
+
+	// DPoPConfig stores the configuration for the VerifyDPoP handler
+	DPoPConfig DPoPConfig `mapstructure:",squash"`
+
+	// HMACConfig stores the configuration for the VerifyHMAC handler
+	HMACConfig HMACConfig `mapstructure:",squash"`
+
+	// HTTPSignatureConfig stores the configuration for the VerifyHTTPSignature handler
+	HTTPSignatureConfig HTTPSignatureConfig `mapstructure:",squash"`
+
+	// IntrospectionConfig stores the configuration for the VerifyIntrospection handler
+	IntrospectionConfig IntrospectionConfig `mapstructure:",squash"`
+
 	// JWKSConfig stores the configuration for the VerifyJWKS handler
 	JWKSConfig JWKSConfig `mapstructure:",squash"`
 
@@ -65,24 +282,108 @@ type Config struct {
 
 var config = &Config{}
 
+// namedHandlers are the handlers set up by BootstrapMiddleware, by name
+var namedHandlers = map[string]AuthHandler{}
+
 // LoadConfig loads the configuration from the environment variables
 func loadConfig() {
 	viper.AutomaticEnv()
 
 	viper.SetDefault("GOAUTH_HANDLERS", []string{})
+	viper.SetDefault("GOAUTH_FAIL_CLOSED", false)
+	viper.SetDefault("GOAUTH_ERROR_FORMAT", "json")
 	viper.SetDefault("GOAUTH_API_KEY_HEADER", "X-API-Key")
+	viper.SetDefault("GOAUTH_API_KEY_SOURCES", []string{})
 	viper.SetDefault("GOAUTH_API_KEY_LIST", []string{})
+	viper.SetDefault("GOAUTH_API_KEY_FILE", "")
+	viper.SetDefault("GOAUTH_BASIC_REALM", "")
+	viper.SetDefault("GOAUTH_BASIC_USERS", "")
+	viper.SetDefault("GOAUTH_BASIC_HTPASSWD_FILE", "")
+	viper.SetDefault("GOAUTH_CLIENT_CERT_CA_FILE", "")
+	viper.SetDefault("GOAUTH_CLIENT_CERT_ALLOWED_SUBJECTS", []string{})
+	viper.SetDefault("GOAUTH_CLIENT_CERT_ALLOWED_SANS", []string{})
+	viper.SetDefault("GOAUTH_CLIENT_CERT_ALLOWED_SPIFFE_IDS", []string{})
+	viper.SetDefault("GOAUTH_CLIENT_CERT_PROXY_HEADER", "")
+	viper.SetDefault("GOAUTH_CLIENT_CERT_TRUSTED_PROXIES", []string{})
+	viper.SetDefault("GOAUTH_DPOP_HANDLERS", []string{})
+	viper.SetDefault("GOAUTH_DPOP_REQUIRED", false)
+	viper.SetDefault("GOAUTH_DPOP_ALGORITHMS", []string{})
+	viper.SetDefault("GOAUTH_DPOP_MAX_AGE", 300)
+	viper.SetDefault("GOAUTH_DPOP_CLOCK_SKEW", 0)
+	viper.SetDefault("GOAUTH_DPOP_NONCE_TTL", 0)
+	viper.SetDefault("GOAUTH_DPOP_BASE_URL", "")
+	viper.SetDefault("GOAUTH_DPOP_REALM", "")
+	viper.SetDefault("GOAUTH_HMAC_STYLE", "")
+	viper.SetDefault("GOAUTH_HMAC_SECRETS", []string{})
+	viper.SetDefault("GOAUTH_HMAC_HASH", "sha256")
+	viper.SetDefault("GOAUTH_HMAC_HEADER", "")
+	viper.SetDefault("GOAUTH_HMAC_PREFIX", "")
+	viper.SetDefault("GOAUTH_HMAC_ENCODING", "hex")
+	viper.SetDefault("GOAUTH_HMAC_TIMESTAMP_HEADER", "")
+	viper.SetDefault("GOAUTH_HMAC_PAYLOAD", "")
+	viper.SetDefault("GOAUTH_HMAC_TOLERANCE", 300)
+	viper.SetDefault("GOAUTH_HMAC_MAX_BODY_SIZE", 10<<20)
+	viper.SetDefault("GOAUTH_HMAC_SUBJECT", "")
+	viper.SetDefault("GOAUTH_HTTP_SIGNATURE_JWKS_URL", "")
+	viper.SetDefault("GOAUTH_HTTP_SIGNATURE_KEYS", "")
+	viper.SetDefault("GOAUTH_HTTP_SIGNATURE_KEY_FILES", []string{})
+	viper.SetDefault("GOAUTH_HTTP_SIGNATURE_ALGORITHMS", []string{})
+	viper.SetDefault("GOAUTH_HTTP_SIGNATURE_REQUIRED_COMPONENTS", []string{})
+	viper.SetDefault("GOAUTH_HTTP_SIGNATURE_LABEL", "")
+	viper.SetDefault("GOAUTH_HTTP_SIGNATURE_TAG", "")
+	viper.SetDefault("GOAUTH_HTTP_SIGNATURE_MAX_AGE", 300)
+	viper.SetDefault("GOAUTH_HTTP_SIGNATURE_CLOCK_SKEW", 0)
+	viper.SetDefault("GOAUTH_HTTP_SIGNATURE_MAX_BODY_SIZE", 10<<20)
+	viper.SetDefault("GOAUTH_HTTP_SIGNATURE_ALLOW_UNSIGNED_BODY", false)
+	viper.SetDefault("GOAUTH_HTTP_SIGNATURE_REFRESH_WINDOW", 60)
+	viper.SetDefault("GOAUTH_HTTP_SIGNATURE_MIN_REFRESH_INTERVAL", 300)
+	viper.SetDefault("GOAUTH_INTROSPECTION_HEADER", "Authorization")
+	viper.SetDefault("GOAUTH_INTROSPECTION_TOKEN_TYPE", "Bearer")
+	viper.SetDefault("GOAUTH_INTROSPECTION_SOURCES", []string{})
+	viper.SetDefault("GOAUTH_INTROSPECTION_URL", "")
+	viper.SetDefault("GOAUTH_INTROSPECTION_CLIENT_ID", "")
+	viper.SetDefault("GOAUTH_INTROSPECTION_CLIENT_SECRET", "")
+	viper.SetDefault("GOAUTH_INTROSPECTION_AUDIENCES", []string{})
+	viper.SetDefault("GOAUTH_INTROSPECTION_SCOPES", []string{})
+	viper.SetDefault("GOAUTH_INTROSPECTION_CACHE_TTL", 60)
+	viper.SetDefault("GOAUTH_INTROSPECTION_NEGATIVE_CACHE_TTL", 10)
+	viper.SetDefault("GOAUTH_INTROSPECTION_TIMEOUT", 10)
+	viper.SetDefault("GOAUTH_INTROSPECTION_REALM", "")
 	viper.SetDefault("GOAUTH_JWKS_HEADER", "Authorization")
 	viper.SetDefault("GOAUTH_JWKS_TOKEN_TYPE", "Bearer")
+	viper.SetDefault("GOAUTH_JWKS_SOURCES", []string{})
 	viper.SetDefault("GOAUTH_JWKS_URL", "")
-	viper.SetDefault("GOAUTH_JWKS_REFRESH_WINDOW", 1*time.Minute)
-	viper.SetDefault("GOAUTH_JWKS_MIN_REFRESH_INTERVAL", 5*time.Minute)
-	viper.SetDefault("GOAUTH_JWKS_PAYLOAD_CONTEXT_KEY", "USER")
+	viper.SetDefault("GOAUTH_JWKS_ISSUER", "")
+	viper.SetDefault("GOAUTH_JWKS_ISSUER_LIST", []string{})
+	viper.SetDefault("GOAUTH_JWKS_ALLOW_OTHER_ISSUERS", false)
+	viper.SetDefault("GOAUTH_JWKS_ISSUER_HEADER", "")
+	viper.SetDefault("GOAUTH_JWKS_ALGORITHMS", []string{})
+	viper.SetDefault("GOAUTH_JWKS_REFRESH_WINDOW", 60)
+	viper.SetDefault("GOAUTH_JWKS_MIN_REFRESH_INTERVAL", 300)
+	viper.SetDefault("GOAUTH_JWKS_PAYLOAD_CONTEXT_KEY", "")
+	viper.SetDefault("GOAUTH_JWKS_ISSUERS", []string{})
+	viper.SetDefault("GOAUTH_JWKS_AUDIENCES", []string{})
+	viper.SetDefault("GOAUTH_JWKS_CLOCK_SKEW", 0)
+	viper.SetDefault("GOAUTH_JWKS_MAX_TOKEN_AGE", 0)
+	viper.SetDefault("GOAUTH_JWKS_REALM", "")
+	viper.SetDefault("GOAUTH_JWKS_REQUIRE_CERTIFICATE_BINDING", false)
 	viper.SetDefault("GOAUTH_JWT_HEADER", "Authorization")
 	viper.SetDefault("GOAUTH_JWT_TOKEN_TYPE", "Bearer")
+	viper.SetDefault("GOAUTH_JWT_SOURCES", []string{})
 	viper.SetDefault("GOAUTH_JWT_SIGNATURE_KEY", "")
+	viper.SetDefault("GOAUTH_JWT_SIGNATURE_KEY_FILE", "")
+	viper.SetDefault("GOAUTH_JWT_SIGNATURE_KEY_FILES", []string{})
+	viper.SetDefault("GOAUTH_JWT_VERIFY_CERTIFICATES", false)
+	viper.SetDefault("GOAUTH_JWT_CERTIFICATE_ROOTS_FILE", "")
 	viper.SetDefault("GOAUTH_JWT_SIGNATURE_ALGORITHM", "RS256")
-	viper.SetDefault("GOAUTH_JWT_PAYLOAD_CONTEXT_KEY", "USER")
+	viper.SetDefault("GOAUTH_JWT_SIGNATURE_ALGORITHMS", []string{})
+	viper.SetDefault("GOAUTH_JWT_PAYLOAD_CONTEXT_KEY", "")
+	viper.SetDefault("GOAUTH_JWT_ISSUERS", []string{})
+	viper.SetDefault("GOAUTH_JWT_AUDIENCES", []string{})
+	viper.SetDefault("GOAUTH_JWT_CLOCK_SKEW", 0)
+	viper.SetDefault("GOAUTH_JWT_MAX_TOKEN_AGE", 0)
+	viper.SetDefault("GOAUTH_JWT_REALM", "")
+	viper.SetDefault("GOAUTH_JWT_REQUIRE_CERTIFICATE_BINDING", false)
 
 	viper.Unmarshal(config)
 }
@@ -98,48 +399,309 @@ func BootstrapMiddleware(ctx context.Context) {
 	}
 	log.Logf(log.Info, "Handlers: %s", config.Handlers)
 	handlers := []AuthHandler{}
+	named := map[string]AuthHandler{}
 	for _, h := range config.Handlers {
+		count := len(handlers)
 		switch strings.ToLower(h) {
 		case "api_key":
-			if len(config.APIKeyConfig.KeyList) == 0 {
-				log.Log(log.Panic, "GOAUTH_API_KEY_LIST is required when using the API Key handler")
+			if len(config.APIKeyConfig.KeyList) == 0 && config.APIKeyConfig.KeyFile == "" {
+				log.Log(log.Panic, "GOAUTH_API_KEY_LIST or GOAUTH_API_KEY_FILE is required when using the API Key handler")
 			}
 			cfg := handler.VerifyAPIKeyConfig{
-				Header: config.APIKeyConfig.Header,
-				Keys:   config.APIKeyConfig.KeyList,
+				Header:  config.APIKeyConfig.Header,
+				Sources: credentialSources("GOAUTH_API_KEY_SOURCES", config.APIKeyConfig.Sources),
+				Keys:    config.APIKeyConfig.KeyList,
+				KeyFile: config.APIKeyConfig.KeyFile,
+				Context: ctx,
 			}
-			handlers = append(handlers, handler.NewVerifyAPIKey(cfg))
-			log.Log(log.Info, "Using API Key authentication")
-		case "jwks":
-			if config.JWKSConfig.URL == "" {
-				log.Log(log.Panic, "GOAUTH_JWKS_URL is required when using the JWKS handler")
+			handlers = appendHandler(handlers, "API Key", handler.NewVerifyAPIKey(cfg))
+		case "basic":
+			if config.BasicConfig.Users == "" && config.BasicConfig.HtpasswdFile == "" {
+				log.Log(log.Panic, "GOAUTH_BASIC_USERS or GOAUTH_BASIC_HTPASSWD_FILE is required when using the Basic handler")
 			}
-			cfg := handler.VerifyJWKSConfig{
-				Header:    config.JWKSConfig.Header,
-				TokenType: config.JWKSConfig.TokenType,
-				URL:       config.JWKSConfig.URL,
+			users, err := htpasswd.Parse(strings.NewReader(config.BasicConfig.Users))
+			if err != nil {
+				log.Logf(log.Panic, "Invalid GOAUTH_BASIC_USERS: %s", err)
+			}
+			cfg := handler.VerifyBasicConfig{
+				Realm:        config.BasicConfig.Realm,
+				Users:        users,
+				HtpasswdFile: config.BasicConfig.HtpasswdFile,
+				Context:      ctx,
+			}
+			handlers = appendHandler(handlers, "Basic", handler.NewVerifyBasic(cfg))
+		case "client_cert":
+			if config.ClientCertConfig.CAFile == "" {
+				log.Log(log.Panic, "GOAUTH_CLIENT_CERT_CA_FILE is required when using the Client Certificate handler")
+			}
+			cfg := handler.VerifyClientCertConfig{
+				Roots:            certPool("GOAUTH_CLIENT_CERT_CA_FILE", config.ClientCertConfig.CAFile),
+				AllowedSubjects:  config.ClientCertConfig.AllowedSubjects,
+				AllowedSANs:      config.ClientCertConfig.AllowedSANs,
+				AllowedSPIFFEIDs: config.ClientCertConfig.AllowedSPIFFEIDs,
+				ProxyHeader:      config.ClientCertConfig.ProxyHeader,
+				TrustedProxies:   config.ClientCertConfig.TrustedProxies,
+			}
+			handlers = appendHandler(handlers, "Client Certificate", handler.NewVerifyClientCert(cfg))
+		case "hmac":
+			if len(config.HMACConfig.Secrets) == 0 {
+				log.Log(log.Panic, "GOAUTH_HMAC_SECRETS is required when using the HMAC handler")
+			}
+			cfg := handler.VerifyHMACConfig{
+				Style:           config.HMACConfig.Style,
+				Secrets:         config.HMACConfig.Secrets,
+				Hash:            config.HMACConfig.Hash,
+				Header:          config.HMACConfig.Header,
+				Prefix:          config.HMACConfig.Prefix,
+				Encoding:        config.HMACConfig.Encoding,
+				TimestampHeader: config.HMACConfig.TimestampHeader,
+				Payload:         config.HMACConfig.Payload,
+				Tolerance:       time.Duration(config.HMACConfig.Tolerance) * time.Second,
+				MaxBodySize:     config.HMACConfig.MaxBodySize,
+				Subject:         config.HMACConfig.Subject,
+			}
+			handlers = appendHandler(handlers, "HMAC", handler.NewVerifyHMAC(cfg))
+		case "http_signature":
+			if config.HTTPSignatureConfig.JWKSURL == "" && config.HTTPSignatureConfig.Keys == "" && len(config.HTTPSignatureConfig.KeyFiles) == 0 {
+				log.Log(log.Panic, "GOAUTH_HTTP_SIGNATURE_JWKS_URL, GOAUTH_HTTP_SIGNATURE_KEYS or GOAUTH_HTTP_SIGNATURE_KEY_FILES is required when using the HTTP signature handler")
+			}
+			keys := []string{}
+			if config.HTTPSignatureConfig.Keys != "" {
+				keys = append(keys, config.HTTPSignatureConfig.Keys)
+			}
+			cfg := handler.VerifyHTTPSignatureConfig{
+				URL:                config.HTTPSignatureConfig.JWKSURL,
+				Keys:               keys,
+				KeyFiles:           config.HTTPSignatureConfig.KeyFiles,
+				Algorithms:         config.HTTPSignatureConfig.Algorithms,
+				RequiredComponents: config.HTTPSignatureConfig.RequiredComponents,
+				Label:              config.HTTPSignatureConfig.Label,
+				Tag:                config.HTTPSignatureConfig.Tag,
+				MaxAge:             time.Duration(config.HTTPSignatureConfig.MaxAge) * time.Second,
+				ClockSkew:          time.Duration(config.HTTPSignatureConfig.ClockSkew) * time.Second,
+				MaxBodySize:        config.HTTPSignatureConfig.MaxBodySize,
+				AllowUnsignedBody:  config.HTTPSignatureConfig.AllowUnsignedBody,
 				CacheConfig: handler.CacheConfig{
-					RefreshWindow:      time.Duration(config.JWKSConfig.RefreshWindow),
-					MinRefreshInterval: time.Duration(config.JWKSConfig.MinRefreshInterval),
+					RefreshWindow:      time.Duration(config.HTTPSignatureConfig.RefreshWindow) * time.Second,
+					MinRefreshInterval: time.Duration(config.HTTPSignatureConfig.MinRefreshInterval) * time.Second,
 					Context:            ctx,
 				},
 			}
-			handlers = append(handlers, handler.NewVerifyJWKS(cfg))
-			log.Log(log.Info, "Using JWKS authentication")
+			handlers = appendHandler(handlers, "HTTP signature", handler.NewVerifyHTTPSignature(cfg))
+		case "introspection":
+			if config.IntrospectionConfig.URL == "" {
+				log.Log(log.Panic, "GOAUTH_INTROSPECTION_URL is required when using the Introspection handler")
+			}
+			cfg := handler.VerifyIntrospectionConfig{
+				Header:           config.IntrospectionConfig.Header,
+				TokenType:        config.IntrospectionConfig.TokenType,
+				Sources:          credentialSources("GOAUTH_INTROSPECTION_SOURCES", config.IntrospectionConfig.Sources),
+				URL:              config.IntrospectionConfig.URL,
+				ClientID:         config.IntrospectionConfig.ClientID,
+				ClientSecret:     config.IntrospectionConfig.ClientSecret,
+				Audiences:        config.IntrospectionConfig.Audiences,
+				Scopes:           config.IntrospectionConfig.Scopes,
+				CacheTTL:         time.Duration(config.IntrospectionConfig.CacheTTL) * time.Second,
+				NegativeCacheTTL: time.Duration(config.IntrospectionConfig.NegativeCacheTTL) * time.Second,
+				Client:           &http.Client{Timeout: time.Duration(config.IntrospectionConfig.Timeout) * time.Second},
+				Realm:            config.IntrospectionConfig.Realm,
+			}
+			handlers = appendHandler(handlers, "Introspection", handler.NewVerifyIntrospection(cfg))
+		case "jwks":
+			if config.JWKSConfig.URL == "" && config.JWKSConfig.Issuer == "" && len(config.JWKSConfig.IssuerList) == 0 {
+				log.Log(log.Panic, "GOAUTH_JWKS_URL, GOAUTH_JWKS_ISSUER or GOAUTH_JWKS_ISSUER_LIST is required when using the JWKS handler")
+			}
+			cacheConfig := handler.CacheConfig{
+				RefreshWindow:      time.Duration(config.JWKSConfig.RefreshWindow) * time.Second,
+				MinRefreshInterval: time.Duration(config.JWKSConfig.MinRefreshInterval) * time.Second,
+				Context:            ctx,
+			}
+			issuerConfigs := []handler.IssuerConfig{}
+			for _, entry := range config.JWKSConfig.IssuerList {
+				issuerConfigs = append(issuerConfigs, issuerConfig(entry, cacheConfig))
+			}
+			cfg := handler.VerifyJWKSConfig{
+				Header:            config.JWKSConfig.Header,
+				TokenType:         config.JWKSConfig.TokenType,
+				Sources:           credentialSources("GOAUTH_JWKS_SOURCES", config.JWKSConfig.Sources),
+				URL:               config.JWKSConfig.URL,
+				Issuer:            config.JWKSConfig.Issuer,
+				IssuerConfigs:     issuerConfigs,
+				AllowOtherIssuers: config.JWKSConfig.AllowOtherIssuers,
+				IssuerHeader:      config.JWKSConfig.IssuerHeader,
+				Algorithms:        config.JWKSConfig.Algorithms,
+				PayloadContextKey: config.JWKSConfig.PayloadContextKey,
+				Realm:             config.JWKSConfig.Realm,
+				CacheConfig:       cacheConfig,
+				ClaimsConfig: handler.ClaimsConfig{
+					Issuers:     config.JWKSConfig.Issuers,
+					Audiences:   config.JWKSConfig.Audiences,
+					ClockSkew:   time.Duration(config.JWKSConfig.ClockSkew) * time.Second,
+					MaxTokenAge: time.Duration(config.JWKSConfig.MaxTokenAge) * time.Second,
+				},
+				CertificateBindingConfig: handler.CertificateBindingConfig{
+					RequireCertificateBinding: config.JWKSConfig.RequireCertificateBinding,
+					ClientCertReader:          clientCertReader(),
+				},
+			}
+			handlers = appendHandler(handlers, "JWKS", handler.NewVerifyJWKS(cfg))
 		case "jwt":
-			if config.JWTConfig.SignatureKey == "" {
-				log.Log(log.Panic, "GOAUTH_JWT_SIGNATURE_KEY is required when using the JWT handler")
+			if config.JWTConfig.SignatureKey == "" && config.JWTConfig.SignatureKeyFile == "" && len(config.JWTConfig.SignatureKeyFiles) == 0 {
+				log.Log(log.Panic, "GOAUTH_JWT_SIGNATURE_KEY, GOAUTH_JWT_SIGNATURE_KEY_FILE or GOAUTH_JWT_SIGNATURE_KEY_FILES is required when using the JWT handler")
 			}
+			roots := certPool("GOAUTH_JWT_CERTIFICATE_ROOTS_FILE", config.JWTConfig.CertificateRootsFile)
 			cfg := handler.VerifyJWTConfig{
-				Header:             config.JWTConfig.Header,
-				TokenType:          config.JWTConfig.TokenType,
-				SignatureKey:       config.JWTConfig.SignatureKey,
-				SignatureAlgorithm: config.JWTConfig.SignatureAlgorithm,
-				PayloadContextKey:  config.JWTConfig.PayloadContextKey,
-			}
-			handlers = append(handlers, handler.NewVerifyJWT(cfg))
-			log.Log(log.Info, "Using JWT authentication")
+				Header:              config.JWTConfig.Header,
+				TokenType:           config.JWTConfig.TokenType,
+				Sources:             credentialSources("GOAUTH_JWT_SOURCES", config.JWTConfig.Sources),
+				SignatureKey:        config.JWTConfig.SignatureKey,
+				SignatureKeyFile:    config.JWTConfig.SignatureKeyFile,
+				SignatureKeyFiles:   config.JWTConfig.SignatureKeyFiles,
+				VerifyCertificates:  config.JWTConfig.VerifyCertificates,
+				CertificateRoots:    roots,
+				SignatureAlgorithm:  config.JWTConfig.SignatureAlgorithm,
+				SignatureAlgorithms: config.JWTConfig.SignatureAlgorithms,
+				PayloadContextKey:   config.JWTConfig.PayloadContextKey,
+				Realm:               config.JWTConfig.Realm,
+				Context:             ctx,
+				ClaimsConfig: handler.ClaimsConfig{
+					Issuers:     config.JWTConfig.Issuers,
+					Audiences:   config.JWTConfig.Audiences,
+					ClockSkew:   time.Duration(config.JWTConfig.ClockSkew) * time.Second,
+					MaxTokenAge: time.Duration(config.JWTConfig.MaxTokenAge) * time.Second,
+				},
+				CertificateBindingConfig: handler.CertificateBindingConfig{
+					RequireCertificateBinding: config.JWTConfig.RequireCertificateBinding,
+					ClientCertReader:          clientCertReader(),
+				},
+			}
+			handlers = appendHandler(handlers, "JWT", handler.NewVerifyJWT(cfg))
+		}
+		if len(handlers) > count {
+			if dpopEnabled(h) {
+				dpop := dpopHandler(handlers[count])
+				if dpop == nil {
+					log.Logf(log.Error, "The %s handler was not registered: invalid DPoP configuration", h)
+					handlers = handlers[:count]
+					continue
+				}
+				handlers[count] = dpop
+				log.Logf(log.Info, "Using DPoP verification on %s", h)
+			}
+			named[strings.ToLower(h)] = handlers[count]
+		}
+	}
+	responder, ok := ParseErrorResponder(config.ErrorFormat)
+	if !ok {
+		log.Logf(log.Panic, "Invalid GOAUTH_ERROR_FORMAT: %s", config.ErrorFormat)
+	}
+	namedHandlers = named
+	defaultMiddleware.Configure(WithHandlers(handlers...), WithFailClosed(config.FailClosed), WithErrorResponder(responder))
+}
+
+// appendHandler appends the handler, unless its constructor rejected the configuration and returned nil,
+// which happens when no logger panics on log.Panic, so a nil handler is never registered on the chain
+func appendHandler(handlers []AuthHandler, name string, h AuthHandler) []AuthHandler {
+	if v := reflect.ValueOf(h); !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		log.Logf(log.Error, "The %s handler was not registered: invalid configuration", name)
+		return handlers
+	}
+	log.Logf(log.Info, "Using %s authentication", name)
+	return append(handlers, h)
+}
+
+// issuerConfig parses an entry of GOAUTH_JWKS_ISSUER_LIST, issuer[=jwks_url][;refresh_window=<seconds>][;min_refresh_interval=<seconds>].
+// The refresh settings that are not set are the ones of cacheConfig
+func issuerConfig(entry string, cacheConfig handler.CacheConfig) handler.IssuerConfig {
+	params := strings.Split(strings.TrimSpace(entry), ";")
+	issuer, url, _ := strings.Cut(strings.TrimSpace(params[0]), "=")
+	cfg := handler.IssuerConfig{CacheConfig: cacheConfig, Issuer: issuer, URL: url}
+	for _, param := range params[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			log.Logf(log.Panic, "Invalid GOAUTH_JWKS_ISSUER_LIST parameter %s of %s", param, issuer)
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "refresh_window":
+			cfg.RefreshWindow = time.Duration(seconds) * time.Second
+		case "min_refresh_interval":
+			cfg.MinRefreshInterval = time.Duration(seconds) * time.Second
+		default:
+			log.Logf(log.Panic, "Unknown GOAUTH_JWKS_ISSUER_LIST parameter %s of %s", name, issuer)
 		}
 	}
-	SetHandlers(handlers)
+	return cfg
+}
+
+// certPool loads the PEM certificates of the file of the env var, or returns nil if the file is not set
+func certPool(name string, file string) *x509.CertPool {
+	if file == "" {
+		return nil
+	}
+	pemCerts, err := os.ReadFile(file)
+	if err != nil {
+		log.Logf(log.Panic, "Failed to read %s: %s", name, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		log.Logf(log.Panic, "No certificates found on %s", name)
+	}
+	return pool
+}
+
+// clientCertReader returns the reader of the client certificates forwarded by the trusted proxies,
+// or nil if GOAUTH_CLIENT_CERT_PROXY_HEADER is not set
+func clientCertReader() *handler.ClientCertReader {
+	if config.ClientCertConfig.ProxyHeader == "" {
+		return nil
+	}
+	reader, err := handler.NewClientCertReader(config.ClientCertConfig.ProxyHeader, config.ClientCertConfig.TrustedProxies)
+	if err != nil {
+		log.Logf(log.Panic, "Invalid GOAUTH_CLIENT_CERT_TRUSTED_PROXIES: %s", err)
+	}
+	return reader
+}
+
+// dpopEnabled reports whether the handler is on GOAUTH_DPOP_HANDLERS
+func dpopEnabled(name string) bool {
+	for _, h := range config.DPoPConfig.Handlers {
+		if strings.EqualFold(strings.TrimSpace(h), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// dpopHandler wraps the token handler with the DPoP verification
+func dpopHandler(h AuthHandler) *handler.VerifyDPoP {
+	var nonces handler.NonceSource
+	if config.DPoPConfig.NonceTTL > 0 {
+		nonces = handler.NewNonceSource(time.Duration(config.DPoPConfig.NonceTTL) * time.Second)
+	}
+	return handler.NewVerifyDPoP(handler.VerifyDPoPConfig{
+		Handler:    h,
+		Required:   config.DPoPConfig.Required,
+		Algorithms: config.DPoPConfig.Algorithms,
+		MaxAge:     time.Duration(config.DPoPConfig.MaxAge) * time.Second,
+		ClockSkew:  time.Duration(config.DPoPConfig.ClockSkew) * time.Second,
+		Nonces:     nonces,
+		BaseURL:    config.DPoPConfig.BaseURL,
+		Realm:      config.DPoPConfig.Realm,
+	})
+}
+
+// credentialSources parses the credential sources of the env var
+func credentialSources(name string, specs []string) []handler.CredentialSource {
+	sources, err := handler.ParseCredentialSources(specs)
+	if err != nil {
+		log.Logf(log.Panic, "Invalid %s: %s", name, err)
+	}
+	return sources
+}
+
+// NamedHandlers returns the handlers set up by BootstrapMiddleware, by their names on GOAUTH_HANDLERS
+func NamedHandlers() map[string]AuthHandler {
+	return namedHandlers
 }
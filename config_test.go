@@ -0,0 +1,92 @@
+package goauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bancodobrasil/goauth/handler"
+	"github.com/bancodobrasil/goauth/log"
+)
+
+func TestBootstrapMiddlewareSkipsInvalidHandlers(t *testing.T) {
+	defer SetHandlers(GetHandlers())
+	t.Setenv("GOAUTH_HANDLERS", "jwt,api_key")
+	t.Setenv("GOAUTH_JWT_SIGNATURE_KEY", "-----BEGIN PUBLIC KEY-----\nnot a key\n-----END PUBLIC KEY-----")
+	t.Setenv("GOAUTH_API_KEY_LIST", "key-1")
+
+	// without a logger, log.Panic does not panic, so the JWT constructor returns nil
+	BootstrapMiddleware(context.Background())
+
+	handlers := GetHandlers()
+	if len(handlers) != 1 {
+		t.Fatalf("BootstrapMiddleware() registered %d handlers, want only the API key handler", len(handlers))
+	}
+	if _, ok := NamedHandlers()["jwt"]; ok {
+		t.Fatal("BootstrapMiddleware() registered the invalid JWT handler")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	Authenticate(http.NotFoundHandler()).ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", w.Code)
+	}
+}
+
+func TestIssuerConfig(t *testing.T) {
+	defaults := handler.CacheConfig{RefreshWindow: time.Minute, MinRefreshInterval: 5 * time.Minute}
+
+	tests := []struct {
+		entry string
+		want  handler.IssuerConfig
+	}{
+		{
+			entry: " https://accounts.example.com ",
+			want:  handler.IssuerConfig{CacheConfig: defaults, Issuer: "https://accounts.example.com"},
+		},
+		{
+			entry: "https://legacy.example.com=https://legacy.example.com/keys",
+			want:  handler.IssuerConfig{CacheConfig: defaults, Issuer: "https://legacy.example.com", URL: "https://legacy.example.com/keys"},
+		},
+		{
+			entry: "https://legacy.example.com=https://legacy.example.com/keys;refresh_window=30; min_refresh_interval=60",
+			want: handler.IssuerConfig{
+				CacheConfig: handler.CacheConfig{RefreshWindow: 30 * time.Second, MinRefreshInterval: time.Minute},
+				Issuer:      "https://legacy.example.com",
+				URL:         "https://legacy.example.com/keys",
+			},
+		},
+		{
+			entry: "https://accounts.example.com;min_refresh_interval=900",
+			want: handler.IssuerConfig{
+				CacheConfig: handler.CacheConfig{RefreshWindow: time.Minute, MinRefreshInterval: 15 * time.Minute},
+				Issuer:      "https://accounts.example.com",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := issuerConfig(tt.entry, defaults); got != tt.want {
+			t.Errorf("issuerConfig(%q) = %+v, want %+v", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestIssuerConfigRejectsInvalidParameters(t *testing.T) {
+	for _, entry := range []string{"https://a.example.com;refresh=30", "https://a.example.com;refresh_window=soon"} {
+		func() {
+			log.SetLogger(log.NewDefaultLogger(log.Panic))
+			defer log.SetLogger(nil)
+			defer func() {
+				if recover() == nil {
+					t.Errorf("issuerConfig(%q) accepted an invalid parameter", entry)
+				}
+			}()
+			issuerConfig(entry, handler.CacheConfig{})
+		}()
+	}
+}
@@ -14,13 +14,10 @@ func Mux() {
 		Header: "X-API-Key",
 		Keys:   []string{"123", "456"},
 	}
-	h := []goauth.AuthHandler{
-		handler.NewVerifyAPIKey(cfg),
-	}
-	goauth.SetHandlers(h)
+	m := goauth.New(goauth.WithHandlers(handler.NewVerifyAPIKey(cfg)))
 
 	r := mux.NewRouter()
-	r.Use(goauth.Authenticate)
+	r.Use(m.Handler)
 	r.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("pong"))
 	})
@@ -14,9 +14,8 @@ func Mux() {
 	cfg := handler.VerifyJWTConfig{
 		Header:             "Authorization",
 		TokenType:          "Bearer",
-		SignatureKey:       "123456",
+		SignatureKey:       "raw:123456",
 		SignatureAlgorithm: "HS256",
-		PayloadContextKey:  "USER",
 	}
 	h := []goauth.AuthHandler{
 		handler.NewVerifyJWT(cfg),
@@ -26,8 +25,9 @@ func Mux() {
 	r := mux.NewRouter()
 	r.Use(goauth.Authenticate)
 	r.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
-		user := r.Context().Value("USER")
-		log.Log(log.Info, user)
+		if principal, ok := goauth.PrincipalFromContext(r.Context()); ok {
+			log.Log(log.Info, principal.Subject)
+		}
 		w.Write([]byte("pong"))
 	})
 	err := http.ListenAndServe(":8081", r)
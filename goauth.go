@@ -1,11 +1,12 @@
 package goauth
 
 import (
-	"fmt"
 	"net/http"
+
+	"github.com/bancodobrasil/goauth/handler"
 )
 
-var h []AuthHandler = []AuthHandler{}
+var defaultMiddleware = New()
 
 // AuthHandler is the interface that wraps the AuthenticateFunc method
 // and is used to authenticate the request
@@ -19,6 +20,23 @@ type AuthMiddlewareError struct {
 	Code int
 	// Message is the error message
 	Message string
+	// Challenges are the WWW-Authenticate challenges to be sent on the response
+	Challenges []handler.Challenge
+	// Headers are the other headers set by the handlers to be sent on the response
+	Headers http.Header
+}
+
+// newAuthMiddlewareError returns the AuthMiddlewareError for the result of a failed handler
+func newAuthMiddlewareError(statusCode int, err error) *AuthMiddlewareError {
+	if statusCode == 0 {
+		statusCode = http.StatusUnauthorized
+	}
+	return &AuthMiddlewareError{
+		Code:       statusCode,
+		Message:    err.Error(),
+		Challenges: handler.ChallengesOf(err),
+		Headers:    handler.HeadersOf(err),
+	}
 }
 
 // Error implements the error interface
@@ -26,50 +44,34 @@ func (e *AuthMiddlewareError) Error() string {
 	return e.Message
 }
 
-// GetHandlers returns the authentication handlers
-func GetHandlers() []AuthHandler {
-	return h
+// WriteChallenges adds the challenges of the error to the WWW-Authenticate header,
+// and sets the other headers of the error
+func (e *AuthMiddlewareError) WriteChallenges(h http.Header) {
+	for _, c := range e.Challenges {
+		h.Add("WWW-Authenticate", c.String())
+	}
+	for name, values := range e.Headers {
+		h[name] = values
+	}
 }
 
-// SetHandlers sets the authentication handlers
-func SetHandlers(handlers []AuthHandler) {
-	h = handlers
+// Default returns the Middleware instance used by the package-level functions
+func Default() *Middleware {
+	return defaultMiddleware
 }
 
-// Authenticate executes all the authentication handlers in the order they were added.
-// If any of the handlers does not return an error, the request proceeds to the next handler.
-// If the last handler returns an error, the request is aborted.
-func Authenticate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var err error
-		var statusCode int
-		request := r
-
-		for _, handler := range h {
-			request, statusCode, err = handler.Handle(r)
-			if err == nil {
-				break
-			}
-		}
-
-		if err != nil {
-			respondWithError(w, &AuthMiddlewareError{
-				Code:    statusCode,
-				Message: err.Error(),
-			})
-			return
-		}
+// GetHandlers returns the authentication handlers of the default middleware
+func GetHandlers() []AuthHandler {
+	return defaultMiddleware.GetHandlers()
+}
 
-		if next != nil {
-			next.ServeHTTP(w, request)
-		}
-	})
+// SetHandlers sets the authentication handlers of the default middleware
+func SetHandlers(handlers []AuthHandler) {
+	defaultMiddleware.SetHandlers(handlers)
 }
 
-// Helper function to abort the request with an error status code and message
-func respondWithError(w http.ResponseWriter, err *AuthMiddlewareError) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(err.Code)
-	str := `{"error":"%s"}`
-	http.Error(w, fmt.Sprintf(str, err.Message), err.Code)
+// Authenticate runs the authentication handlers of the default middleware.
+// See Middleware.Handler for the rules of the handler chain.
+func Authenticate(next http.Handler) http.Handler {
+	return defaultMiddleware.Handler(next)
 }
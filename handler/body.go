@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ErrBodyTooLarge is returned when the request body is larger than the handler can buffer
+var ErrBodyTooLarge = errors.New("Request body is too large")
+
+// defaultMaxBodySize is the default limit of the request bodies buffered by the handlers
+const defaultMaxBodySize = 10 << 20
+
+// readBody reads the request body, up to maxBytes, and restores it so the next handlers can read it again.
+// If the body is too large or can not be read, the bytes already read are put back in front of the rest
+// of the body, so the next handlers still receive the whole body
+func readBody(r *http.Request, maxBytes int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return []byte{}, nil
+	}
+	original := r.Body
+	body, err := io.ReadAll(io.LimitReader(original, maxBytes+1))
+	if err == nil && int64(len(body)) > maxBytes {
+		err = ErrBodyTooLarge
+	}
+	if err != nil {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), original), original}
+		return nil, err
+	}
+	original.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
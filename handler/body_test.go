@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingReader returns its data and then an error
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.data == "" {
+		return 0, f.err
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func TestReadBody(t *testing.T) {
+	errRead := errors.New("connection reset")
+
+	tests := []struct {
+		name     string
+		body     io.Reader
+		maxBytes int64
+		wantBody string
+		wantErr  error
+		wantRest string
+	}{
+		{name: "body", body: strings.NewReader("hello"), maxBytes: 5, wantBody: "hello", wantRest: "hello"},
+		{name: "empty body", body: http.NoBody, maxBytes: 5, wantRest: ""},
+		{name: "body too large", body: strings.NewReader("hello world"), maxBytes: 5, wantErr: ErrBodyTooLarge, wantRest: "hello world"},
+		{name: "read error", body: &failingReader{data: "hel", err: errRead}, maxBytes: 5, wantErr: errRead, wantRest: "hel"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", tt.body)
+
+			body, err := readBody(r, tt.maxBytes)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readBody() error = %v, want %v", err, tt.wantErr)
+			}
+			if string(body) != tt.wantBody {
+				t.Fatalf("readBody() = %q, want %q", body, tt.wantBody)
+			}
+
+			// the next handlers must receive the whole body, even when it could not be buffered
+			rest, _ := io.ReadAll(r.Body)
+			if string(rest) != tt.wantRest {
+				t.Fatalf("restored body = %q, want %q", rest, tt.wantRest)
+			}
+			if err := r.Body.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+		})
+	}
+}
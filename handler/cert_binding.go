@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// ErrCertificateBinding is returned when the token is not bound to the client certificate of the request
+var ErrCertificateBinding = errors.New("JWT token is not bound to the client certificate")
+
+// CertificateBindingConfig stores the configuration of the certificate-bound access tokens (RFC 8705)
+type CertificateBindingConfig struct {
+	// RequireCertificateBinding makes the tokens without a cnf.x5t#S256 confirmation claim be rejected.
+	// The tokens with the claim are always rejected unless it matches the SHA-256 thumbprint of the
+	// client certificate of the request
+	RequireCertificateBinding bool
+	// ClientCertReader reads the client certificate of the request. If nil, it is read from the TLS connection
+	ClientCertReader *ClientCertReader
+}
+
+// checkBinding verifies that the token is bound to the client certificate of the request, if it has
+// a cnf.x5t#S256 claim or the binding is required
+func (c CertificateBindingConfig) checkBinding(r *http.Request, token jwt.Token) error {
+	var thumbprint any
+	if cnf, ok := token.Get("cnf"); ok {
+		if confirmation, ok := cnf.(map[string]any); ok {
+			thumbprint = confirmation["x5t#S256"]
+		}
+	}
+	if thumbprint == nil {
+		if c.RequireCertificateBinding {
+			return &ClaimError{Claim: "cnf", Err: ErrCertificateBinding}
+		}
+		return nil
+	}
+
+	reader := c.ClientCertReader
+	if reader == nil {
+		reader = &ClientCertReader{}
+	}
+	certs, err := reader.Certificates(r)
+	if err != nil || len(certs) == 0 || CertificateThumbprint(certs[0]) != thumbprint {
+		return &ClaimError{Claim: "cnf", Err: ErrCertificateBinding}
+	}
+	return nil
+}
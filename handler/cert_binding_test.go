@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// newTestCertificate returns a self-signed client certificate
+func newTestCertificate(t *testing.T, cn string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCheckBinding(t *testing.T) {
+	cert := newTestCertificate(t, "client")
+	other := newTestCertificate(t, "other")
+
+	tests := []struct {
+		name       string
+		required   bool
+		thumbprint any
+		cert       *x509.Certificate
+		wantErr    bool
+	}{
+		{name: "unbound token, binding not required", cert: cert},
+		{name: "unbound token, binding required", required: true, cert: cert, wantErr: true},
+		{name: "bound token, matching certificate", thumbprint: CertificateThumbprint(cert), cert: cert},
+		{name: "bound token, matching certificate, binding required", required: true, thumbprint: CertificateThumbprint(cert), cert: cert},
+		{name: "bound token, other certificate", thumbprint: CertificateThumbprint(cert), cert: other, wantErr: true},
+		{name: "bound token, no certificate", thumbprint: CertificateThumbprint(cert), wantErr: true},
+		{name: "bound token, invalid thumbprint", thumbprint: 42, cert: cert, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := jwt.New()
+			if tt.thumbprint != nil {
+				token.Set("cnf", map[string]any{"x5t#S256": tt.thumbprint})
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.cert != nil {
+				r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+			}
+
+			err := CertificateBindingConfig{RequireCertificateBinding: tt.required}.checkBinding(r, token)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("checkBinding() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrCertificateBinding) {
+				t.Fatalf("checkBinding() error = %v, want %v", err, ErrCertificateBinding)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Challenge is a WWW-Authenticate challenge (RFC 7235), with the
+// attributes defined for the Bearer scheme (RFC 6750)
+type Challenge struct {
+	// Scheme is the authentication scheme, e.g. Bearer
+	Scheme string
+	// Realm is the protection space of the challenge
+	Realm string
+	// Scope is the space-delimited list of scopes required to access the resource
+	Scope string
+	// Error is the error code, e.g. invalid_token or insufficient_scope
+	Error string
+	// ErrorDescription is the human-readable description of the error
+	ErrorDescription string
+}
+
+// String returns the challenge formatted as a WWW-Authenticate header value
+func (c Challenge) String() string {
+	params := []string{}
+	for _, p := range []struct{ name, value string }{
+		{"realm", c.Realm},
+		{"scope", c.Scope},
+		{"error", c.Error},
+		{"error_description", c.ErrorDescription},
+	} {
+		if p.value != "" {
+			params = append(params, p.name+"="+quote(p.value))
+		}
+	}
+	if len(params) == 0 {
+		return c.Scheme
+	}
+	return c.Scheme + " " + strings.Join(params, ", ")
+}
+
+// quote returns the value as a quoted-string (RFC 7230)
+func quote(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r", "", "\n", "").Replace(value)
+	return `"` + value + `"`
+}
+
+// challengeError is an error that carries the challenges to be sent on the response
+type challengeError struct {
+	err        error
+	challenges []Challenge
+}
+
+// Error implements the error interface
+func (e *challengeError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e *challengeError) Unwrap() error {
+	return e.err
+}
+
+// WithChallenge returns an error that wraps err and carries the challenges,
+// so they can be sent on the WWW-Authenticate header of the response
+func WithChallenge(err error, challenges ...Challenge) error {
+	if err == nil || len(challenges) == 0 {
+		return err
+	}
+	return &challengeError{err: err, challenges: challenges}
+}
+
+// ChallengesOf returns the challenges carried by err, if any
+func ChallengesOf(err error) []Challenge {
+	var ce *challengeError
+	if errors.As(err, &ce) {
+		return ce.challenges
+	}
+	return nil
+}
+
+// headerError is an error that carries headers to be sent on the response
+type headerError struct {
+	err    error
+	header http.Header
+}
+
+// Error implements the error interface
+func (e *headerError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e *headerError) Unwrap() error {
+	return e.err
+}
+
+// WithHeader returns an error that wraps err and carries the header, so it can be
+// sent on the response (e.g. the DPoP-Nonce header)
+func WithHeader(err error, name, value string) error {
+	if err == nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set(name, value)
+	return &headerError{err: err, header: header}
+}
+
+// HeadersOf returns the headers carried by err, if any
+func HeadersOf(err error) http.Header {
+	var he *headerError
+	if errors.As(err, &he) {
+		return he.header
+	}
+	return nil
+}
+
+// bearerChallenge returns the challenge of the Bearer token handlers for the error.
+// Following RFC 6750, no error code is set when the request has no token
+func bearerChallenge(scheme, realm string, err error) Challenge {
+	if scheme == "" {
+		scheme = "Bearer"
+	}
+	c := Challenge{Scheme: scheme, Realm: realm}
+	if err != nil && !errors.Is(err, ErrNoCredentials) {
+		c.Error = "invalid_token"
+		c.ErrorDescription = err.Error()
+	}
+	return c
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// ClaimsConfig stores the configuration for the registered claims validation
+type ClaimsConfig struct {
+	// Issuers is the list of accepted iss claims. If empty, the iss claim is not checked
+	Issuers []string
+	// Audiences is the list of accepted aud claims, the token must contain at least one of them.
+	// If empty, the aud claim is not checked
+	Audiences []string
+	// ClockSkew is the allowed clock skew when validating the exp, nbf and iat claims
+	ClockSkew time.Duration
+	// MaxTokenAge is the maximum time since the iat claim. If zero, the token age is not checked
+	MaxTokenAge time.Duration
+}
+
+// ClaimError is returned when a registered claim of the token is not satisfied
+type ClaimError struct {
+	// Claim is the name of the claim that failed the validation
+	Claim string
+	// Err is the reason of the failure
+	Err error
+}
+
+// Error implements the error interface
+func (e *ClaimError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the reason of the failure
+func (e *ClaimError) Unwrap() error {
+	return e.Err
+}
+
+// parseOptions returns the jwt.Parse options that validate the registered claims
+func (c ClaimsConfig) parseOptions() []jwt.ParseOption {
+	return []jwt.ParseOption{
+		jwt.WithValidate(true),
+		jwt.WithAcceptableSkew(c.ClockSkew),
+		jwt.WithValidator(jwt.ValidatorFunc(c.validate)),
+	}
+}
+
+// validate checks the iss, aud and token age of the token
+func (c ClaimsConfig) validate(_ context.Context, t jwt.Token) jwt.ValidationError {
+	if len(c.Issuers) > 0 && !contains(c.Issuers, t.Issuer()) {
+		return jwt.NewValidationError(ErrInvalidIssuer)
+	}
+
+	if len(c.Audiences) > 0 {
+		found := false
+		for _, aud := range t.Audience() {
+			if contains(c.Audiences, aud) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return jwt.NewValidationError(ErrInvalidAudience)
+		}
+	}
+
+	if c.MaxTokenAge > 0 {
+		iat := t.IssuedAt()
+		if iat.IsZero() {
+			return jwt.NewValidationError(ErrInvalidIssuedAt)
+		}
+		if time.Since(iat) > c.MaxTokenAge+c.ClockSkew {
+			return jwt.NewValidationError(ErrTokenTooOld)
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
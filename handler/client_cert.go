@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ClientCertReader reads the client certificate chain of a request from its TLS connection or,
+// when the request comes from a trusted proxy that terminates TLS, from a proxy header
+type ClientCertReader struct {
+	proxyHeader    string
+	trustedProxies []*net.IPNet
+}
+
+// NewClientCertReader returns a new ClientCertReader. The proxyHeader (e.g. X-Forwarded-Client-Cert) is only
+// read on requests from the trustedProxies, a list of IP addresses or CIDR ranges. It holds either an Envoy
+// XFCC element with the Cert and Chain fields, or a URL encoded PEM certificate (e.g. $ssl_client_escaped_cert
+// of nginx), or a base64 encoded DER certificate
+func NewClientCertReader(proxyHeader string, trustedProxies []string) (*ClientCertReader, error) {
+	reader := &ClientCertReader{proxyHeader: proxyHeader}
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s: %w", proxy, err)
+		}
+		reader.trustedProxies = append(reader.trustedProxies, network)
+	}
+	if proxyHeader != "" && len(reader.trustedProxies) == 0 {
+		return nil, errors.New("the client certificate proxy header requires trusted proxies")
+	}
+	return reader, nil
+}
+
+// Certificates returns the client certificate chain of the request, starting with the leaf certificate,
+// or nil if the request has no client certificate
+func (c *ClientCertReader) Certificates(r *http.Request) ([]*x509.Certificate, error) {
+	if c.proxyHeader != "" && c.trusted(r) {
+		if value := r.Header.Get(c.proxyHeader); value != "" {
+			return parseForwardedCert(value)
+		}
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, nil
+	}
+	return r.TLS.PeerCertificates, nil
+}
+
+// trusted reports whether the request comes from a trusted proxy
+func (c *ClientCertReader) trusted(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range c.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwardedCert parses the certificate chain of a proxy header
+func parseForwardedCert(value string) ([]*x509.Certificate, error) {
+	fields := xfccFields(value)
+	if fields == nil {
+		return parseCertificates(value)
+	}
+
+	certs, err := parseCertificates(fields["cert"])
+	if err != nil {
+		return nil, err
+	}
+	if chain := fields["chain"]; chain != "" {
+		chainCerts, err := parseCertificates(chain)
+		if err != nil {
+			return nil, err
+		}
+		// the Chain field starts with the leaf certificate, already read from the Cert field
+		if len(chainCerts) > 0 && chainCerts[0].Equal(certs[0]) {
+			chainCerts = chainCerts[1:]
+		}
+		certs = append(certs, chainCerts...)
+	}
+	return certs, nil
+}
+
+// xfccFields returns the fields of the last element of an Envoy X-Forwarded-Client-Cert header,
+// which was added by the closest proxy, or nil if the value is not an XFCC element with a Cert field.
+// The elements are separated by commas and the fields by semicolons, outside of quotes
+func xfccFields(value string) map[string]string {
+	var elements [][]string
+	var fields []string
+	var field strings.Builder
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		switch {
+		case ch == '\\' && quoted && i+1 < len(value):
+			i++
+			field.WriteByte(value[i])
+		case ch == '"':
+			quoted = !quoted
+		case (ch == ';' || ch == ',') && !quoted:
+			fields = append(fields, field.String())
+			field.Reset()
+			if ch == ',' {
+				elements = append(elements, fields)
+				fields = nil
+			}
+		default:
+			field.WriteByte(ch)
+		}
+	}
+	elements = append(elements, append(fields, field.String()))
+
+	result := map[string]string{}
+	for _, f := range elements[len(elements)-1] {
+		key, val, ok := strings.Cut(strings.TrimSpace(f), "=")
+		if ok {
+			result[strings.ToLower(key)] = val
+		}
+	}
+	if result["cert"] == "" {
+		return nil
+	}
+	return result
+}
+
+// parseCertificates parses URL encoded PEM certificates or a base64 encoded DER certificate
+func parseCertificates(value string) ([]*x509.Certificate, error) {
+	value = strings.TrimSpace(value)
+	unescaped, err := url.PathUnescape(value)
+	if strings.HasPrefix(unescaped, "-----BEGIN+") {
+		// form encoded, with the spaces as + and the + of the base64 escaped
+		unescaped, err = url.QueryUnescape(value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidClientCert, err)
+	}
+
+	if !strings.HasPrefix(unescaped, "-----BEGIN") {
+		der, err := base64.StdEncoding.DecodeString(unescaped)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidClientCert, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidClientCert, err)
+		}
+		return []*x509.Certificate{cert}, nil
+	}
+
+	var certs []*x509.Certificate
+	rest := []byte(unescaped)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidClientCert, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%w: no certificates found", ErrInvalidClientCert)
+	}
+	return certs, nil
+}
+
+// CertificateThumbprint returns the base64url encoded SHA-256 thumbprint of the certificate,
+// as the x5t#S256 JWK and confirmation parameters
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
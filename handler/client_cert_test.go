@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// pemCert returns the PEM encoding of the certificate
+func pemCert(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+func TestParseForwardedCert(t *testing.T) {
+	root := newTestCA(t, "root")
+	intermediate := issueTestCA(t, "intermediate", root)
+	leaf := intermediate.issueLeaf(t, &x509.Certificate{Subject: pkix.Name{CommonName: "payments"}})
+	previous := root.issueLeaf(t, &x509.Certificate{Subject: pkix.Name{CommonName: "edge"}})
+
+	escapedLeaf := url.QueryEscape(pemCert(leaf))
+	escapedChain := url.QueryEscape(pemCert(leaf) + pemCert(intermediate.cert))
+
+	tests := []struct {
+		name    string
+		value   string
+		want    []*x509.Certificate
+		wantErr bool
+	}{
+		{name: "XFCC Cert", value: `Hash=abc;Cert="` + escapedLeaf + `";Subject="CN=payments"`, want: []*x509.Certificate{leaf}},
+		{
+			name:  "XFCC Cert and Chain",
+			value: `Hash=abc;Cert="` + escapedLeaf + `";Chain="` + escapedChain + `"`,
+			want:  []*x509.Certificate{leaf, intermediate.cert},
+		},
+		{
+			name:  "XFCC last element",
+			value: `Cert="` + url.QueryEscape(pemCert(previous)) + `";Subject="CN=edge,O=\"Example, Inc\"",Hash=abc;Cert="` + escapedLeaf + `"`,
+			want:  []*x509.Certificate{leaf},
+		},
+		{name: "nginx escaped PEM", value: url.PathEscape(pemCert(leaf)), want: []*x509.Certificate{leaf}},
+		{name: "form encoded PEM", value: escapedLeaf, want: []*x509.Certificate{leaf}},
+		{name: "base64 DER", value: base64.StdEncoding.EncodeToString(leaf.Raw), want: []*x509.Certificate{leaf}},
+		{name: "invalid base64", value: "not a certificate", wantErr: true},
+		{name: "invalid DER", value: base64.StdEncoding.EncodeToString([]byte("not a certificate")), wantErr: true},
+		{name: "PEM without certificates", value: url.PathEscape("-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n"), wantErr: true},
+		{name: "XFCC invalid Cert", value: `Hash=abc;Cert="bm90IGEgY2VydA=="`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certs, err := parseForwardedCert(tt.value)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidClientCert) {
+					t.Fatalf("parseForwardedCert() error = %v, want %v", err, ErrInvalidClientCert)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseForwardedCert() error = %v", err)
+			}
+			if len(certs) != len(tt.want) {
+				t.Fatalf("parseForwardedCert() returned %d certificates, want %d", len(certs), len(tt.want))
+			}
+			for i := range certs {
+				if !certs[i].Equal(tt.want[i]) {
+					t.Fatalf("certificate #%d = %s, want %s", i, certs[i].Subject, tt.want[i].Subject)
+				}
+			}
+		})
+	}
+}
+
+func TestXFCCFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  map[string]string
+	}{
+		{name: "fields", value: `By=spiffe://a;Hash=abc;Cert="x"`, want: map[string]string{"by": "spiffe://a", "hash": "abc", "cert": "x"}},
+		{name: "quoted separators", value: `Cert="a;b,c";Subject="CN=x,O=\"y;z\""`, want: map[string]string{"cert": "a;b,c", "subject": `CN=x,O="y;z"`}},
+		{name: "last element", value: `Cert="first",Cert="second";Hash=h`, want: map[string]string{"cert": "second", "hash": "h"}},
+		{name: "without Cert", value: `Hash=abc;Subject="CN=x"`, want: nil},
+		{name: "PEM", value: "-----BEGIN CERTIFICATE-----", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := xfccFields(tt.value)
+			if len(got) != len(tt.want) || (got == nil) != (tt.want == nil) {
+				t.Fatalf("xfccFields() = %v, want %v", got, tt.want)
+			}
+			for key, value := range tt.want {
+				if got[key] != value {
+					t.Fatalf("xfccFields()[%s] = %q, want %q", key, got[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestClientCertReaderTrustedProxies(t *testing.T) {
+	root := newTestCA(t, "root")
+	forwarded := root.issueLeaf(t, &x509.Certificate{Subject: pkix.Name{CommonName: "forwarded"}})
+	direct := root.issueLeaf(t, &x509.Certificate{Subject: pkix.Name{CommonName: "direct"}})
+
+	reader, err := NewClientCertReader("X-Forwarded-Client-Cert", []string{"10.0.0.0/8", " 192.168.1.10 ", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     bool
+		tls        bool
+		want       string
+	}{
+		{name: "trusted CIDR", remoteAddr: "10.1.2.3:4000", header: true, want: "forwarded"},
+		{name: "trusted IPv4", remoteAddr: "192.168.1.10:4000", header: true, want: "forwarded"},
+		{name: "trusted IPv6", remoteAddr: "[::1]:4000", header: true, want: "forwarded"},
+		{name: "untrusted proxy", remoteAddr: "192.168.1.11:4000", header: true, tls: true, want: "direct"},
+		{name: "untrusted proxy without TLS", remoteAddr: "203.0.113.1:4000", header: true, want: ""},
+		{name: "trusted proxy without header", remoteAddr: "10.1.2.3:4000", tls: true, want: "direct"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.tls {
+				r = tlsRequest(direct)
+			}
+			r.RemoteAddr = tt.remoteAddr
+			if tt.header {
+				r.Header.Set("X-Forwarded-Client-Cert", `Hash=abc;Cert="`+url.QueryEscape(pemCert(forwarded))+`"`)
+			}
+
+			certs, err := reader.Certificates(r)
+			if err != nil {
+				t.Fatalf("Certificates() error = %v", err)
+			}
+			got := ""
+			if len(certs) > 0 {
+				got = certs[0].Subject.CommonName
+			}
+			if got != tt.want {
+				t.Fatalf("Certificates() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewClientCertReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		proxies []string
+		wantErr string
+	}{
+		{name: "without proxy header"},
+		{name: "proxy header without trusted proxies", header: "X-Forwarded-Client-Cert", wantErr: "requires trusted proxies"},
+		{name: "invalid trusted proxy", header: "X-Forwarded-Client-Cert", proxies: []string{"proxy.example.com"}, wantErr: "invalid trusted proxy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClientCertReader(tt.header, tt.proxies)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("NewClientCertReader() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("NewClientCertReader() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
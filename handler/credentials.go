@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Kinds of credential sources
+const (
+	// SourceHeader reads the credentials from a request header
+	SourceHeader = "header"
+	// SourceQuery reads the credentials from a query parameter
+	SourceQuery = "query"
+	// SourceCookie reads the credentials from a cookie
+	SourceCookie = "cookie"
+	// SourceAuthorization reads the credentials from the Authorization header, with the scheme on Name.
+	// It is the same as a header source of Authorization with the scheme
+	SourceAuthorization = "authorization"
+)
+
+// CredentialSource is a place of the request the credentials are read from
+type CredentialSource struct {
+	// Kind is one of header, query, cookie or authorization
+	Kind string
+	// Name is the name of the header, query parameter or cookie, or the scheme of the Authorization header
+	Name string
+	// Scheme is the authentication scheme expected before the credentials on a header source, as in
+	// <Scheme> <credentials>. If empty, the whole header value is the credential
+	Scheme string
+}
+
+// String returns the source in the kind:name format
+func (s CredentialSource) String() string {
+	if s.Kind == SourceHeader && s.Scheme != "" {
+		return fmt.Sprintf("%s:%s:%s", s.Kind, s.Name, s.Scheme)
+	}
+	return s.Kind + ":" + s.Name
+}
+
+// ParseCredentialSource parses a source in the kind:name format, as in header:X-API-Key, query:api_key,
+// cookie:api_key or authorization:ApiKey. A header source may also have a scheme, as in header:X-Token:Bearer
+func ParseCredentialSource(spec string) (CredentialSource, error) {
+	kind, name, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	kind = strings.ToLower(kind)
+	if !ok || name == "" {
+		return CredentialSource{}, fmt.Errorf("invalid credential source %q, expected kind:name", spec)
+	}
+	source := CredentialSource{Kind: kind, Name: name}
+	switch kind {
+	case SourceHeader:
+		source.Name, source.Scheme, _ = strings.Cut(name, ":")
+	case SourceQuery, SourceCookie, SourceAuthorization:
+	default:
+		return CredentialSource{}, fmt.Errorf("invalid credential source %q, unknown kind %s", spec, kind)
+	}
+	return source, nil
+}
+
+// ParseCredentialSources parses a list of sources in the kind:name format (see ParseCredentialSource)
+func ParseCredentialSources(specs []string) ([]CredentialSource, error) {
+	sources := make([]CredentialSource, 0, len(specs))
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		source, err := ParseCredentialSource(spec)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// Extractor reads the credentials of a request from a list of sources, tried in order
+type Extractor struct {
+	sources []CredentialSource
+}
+
+// NewExtractor returns a new Extractor for the sources
+func NewExtractor(sources ...CredentialSource) *Extractor {
+	normalized := make([]CredentialSource, len(sources))
+	for i, source := range sources {
+		if source.Kind == SourceAuthorization {
+			source = CredentialSource{Kind: SourceHeader, Name: "Authorization", Scheme: source.Name}
+		}
+		normalized[i] = source
+	}
+	return &Extractor{sources: normalized}
+}
+
+// newExtractor returns the Extractor of the sources, or of the header and token type of the
+// handler configuration when there are no sources
+func newExtractor(sources []CredentialSource, header, tokenType string) *Extractor {
+	if len(sources) == 0 {
+		sources = []CredentialSource{{Kind: SourceHeader, Name: header, Scheme: tokenType}}
+	}
+	return NewExtractor(sources...)
+}
+
+// Sources returns the sources of the Extractor
+func (e *Extractor) Sources() []CredentialSource {
+	return e.sources
+}
+
+// Extract returns the credentials of the first source found on the request, and the request with the
+// query parameter sources stripped from its URL, so the credentials do not reach the logs or the
+// downstream handlers. The error matches ErrNoCredentials when no source is found
+func (e *Extractor) Extract(r *http.Request) (credentials string, request *http.Request, err error) {
+	var invalid *CredentialSource
+	for i, source := range e.sources {
+		value, found, valid := e.read(r, source)
+		if !found {
+			continue
+		}
+		if !valid {
+			if invalid == nil {
+				invalid = &e.sources[i]
+			}
+			continue
+		}
+		return value, e.strip(r), nil
+	}
+
+	if len(e.sources) == 1 && e.sources[0].Kind == SourceHeader {
+		if invalid != nil {
+			return "", r, noCredentials("Invalid %s Header", invalid.Name)
+		}
+		return "", r, noCredentials("Missing %s Header", e.sources[0].Name)
+	}
+	if invalid != nil {
+		return "", r, noCredentials("Invalid credentials on %s", invalid)
+	}
+	return "", r, noCredentials("Missing credentials")
+}
+
+// read returns the credentials of the source, whether the source is on the request,
+// and whether its value has the expected scheme
+func (e *Extractor) read(r *http.Request, source CredentialSource) (value string, found bool, valid bool) {
+	switch source.Kind {
+	case SourceHeader:
+		value = r.Header.Get(source.Name)
+	case SourceQuery:
+		value = r.URL.Query().Get(source.Name)
+	case SourceCookie:
+		if cookie, err := r.Cookie(source.Name); err == nil {
+			value = cookie.Value
+		}
+	}
+	if value == "" {
+		return "", false, false
+	}
+	if source.Kind != SourceHeader || source.Scheme == "" {
+		return value, true, true
+	}
+
+	scheme, credentials, ok := strings.Cut(value, " ")
+	if !ok || !strings.EqualFold(scheme, source.Scheme) {
+		return "", true, false
+	}
+	credentials = strings.TrimSpace(credentials)
+	return credentials, true, credentials != ""
+}
+
+// strip returns a shallow copy of the request without the query parameter sources on its URL
+func (e *Extractor) strip(r *http.Request) *http.Request {
+	if r.URL == nil || r.URL.RawQuery == "" {
+		return r
+	}
+	query := r.URL.Query()
+	stripped := false
+	for _, source := range e.sources {
+		if source.Kind == SourceQuery && query.Has(source.Name) {
+			query.Del(source.Name)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return r
+	}
+
+	request := r.Clone(r.Context())
+	request.URL.RawQuery = query.Encode()
+	if request.RequestURI != "" {
+		request.RequestURI = request.URL.RequestURI()
+	}
+	return request
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseCredentialSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []string
+		want    []CredentialSource
+		wantErr string
+	}{
+		{
+			name:  "all kinds",
+			specs: []string{"header:X-API-Key", " query:api_key ", "cookie:session", "authorization:ApiKey"},
+			want: []CredentialSource{
+				{Kind: SourceHeader, Name: "X-API-Key"},
+				{Kind: SourceQuery, Name: "api_key"},
+				{Kind: SourceCookie, Name: "session"},
+				{Kind: SourceAuthorization, Name: "ApiKey"},
+			},
+		},
+		{
+			name:  "header with scheme",
+			specs: []string{"Header:X-Token:Bearer"},
+			want:  []CredentialSource{{Kind: SourceHeader, Name: "X-Token", Scheme: "Bearer"}},
+		},
+		{name: "empty specs", specs: []string{"", "  "}, want: []CredentialSource{}},
+		{name: "without name", specs: []string{"header:X-API-Key", "query:"}, wantErr: `"query:"`},
+		{name: "without kind", specs: []string{"X-API-Key"}, wantErr: "expected kind:name"},
+		{name: "unknown kind", specs: []string{"body:token"}, wantErr: "unknown kind body"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources, err := ParseCredentialSources(tt.specs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseCredentialSources() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCredentialSources() error = %v", err)
+			}
+			if len(sources) != len(tt.want) {
+				t.Fatalf("ParseCredentialSources() = %v, want %v", sources, tt.want)
+			}
+			for i := range sources {
+				if sources[i] != tt.want[i] {
+					t.Fatalf("ParseCredentialSources()[%d] = %+v, want %+v", i, sources[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCredentialSourceString(t *testing.T) {
+	for _, spec := range []string{"header:X-API-Key", "header:X-Token:Bearer", "query:api_key", "cookie:session", "authorization:ApiKey"} {
+		source, err := ParseCredentialSource(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if source.String() != spec {
+			t.Errorf("String() = %s, want %s", source, spec)
+		}
+	}
+}
+
+func TestExtractor(t *testing.T) {
+	all := NewExtractor(
+		CredentialSource{Kind: SourceAuthorization, Name: "ApiKey"},
+		CredentialSource{Kind: SourceHeader, Name: "X-API-Key"},
+		CredentialSource{Kind: SourceQuery, Name: "api_key"},
+		CredentialSource{Kind: SourceCookie, Name: "api_key"},
+	)
+	bearer := NewExtractor(CredentialSource{Kind: SourceHeader, Name: "Authorization", Scheme: "Bearer"})
+
+	tests := []struct {
+		name      string
+		extractor *Extractor
+		target    string
+		headers   map[string]string
+		cookie    string
+		want      string
+		wantQuery string
+		wantErr   string
+	}{
+		{name: "authorization", extractor: all, target: "/", headers: map[string]string{"Authorization": "apikey  key-1 "}, want: "key-1"},
+		{name: "header", extractor: all, target: "/", headers: map[string]string{"X-API-Key": "key-2"}, want: "key-2"},
+		{name: "query", extractor: all, target: "/items?page=2&api_key=key-3", want: "key-3", wantQuery: "page=2"},
+		{name: "cookie", extractor: all, target: "/", cookie: "key-4", want: "key-4"},
+		{
+			name:      "first source wins",
+			extractor: all,
+			target:    "/items?api_key=key-3",
+			headers:   map[string]string{"Authorization": "ApiKey key-1", "X-API-Key": "key-2"},
+			cookie:    "key-4",
+			want:      "key-1",
+			wantQuery: "",
+		},
+		{
+			name:      "query stripped when another source wins",
+			extractor: all,
+			target:    "/items?api_key=key-3&page=2",
+			headers:   map[string]string{"X-API-Key": "key-2"},
+			want:      "key-2",
+			wantQuery: "page=2",
+		},
+		{
+			name:      "wrong scheme skipped",
+			extractor: all,
+			target:    "/",
+			headers:   map[string]string{"Authorization": "Bearer key-1", "X-API-Key": "key-2"},
+			want:      "key-2",
+		},
+		{name: "wrong scheme", extractor: all, target: "/", headers: map[string]string{"Authorization": "Bearer key-1"}, wantErr: "Invalid credentials on header:Authorization:ApiKey"},
+		{name: "missing credentials", extractor: all, target: "/?page=2", wantErr: "Missing credentials"},
+		{name: "bearer", extractor: bearer, target: "/", headers: map[string]string{"Authorization": "Bearer token"}, want: "token"},
+		{name: "bearer with wrong scheme", extractor: bearer, target: "/", headers: map[string]string{"Authorization": "Basic dXNlcg=="}, wantErr: "Invalid Authorization Header"},
+		{name: "bearer without credentials", extractor: bearer, target: "/", headers: map[string]string{"Authorization": "Bearer "}, wantErr: "Invalid Authorization Header"},
+		{name: "bearer missing", extractor: bearer, target: "/", wantErr: "Missing Authorization Header"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "api_key", Value: tt.cookie})
+			}
+			rawQuery := r.URL.RawQuery
+
+			credentials, request, err := tt.extractor.Extract(r)
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrNoCredentials) || err.Error() != tt.wantErr {
+					t.Fatalf("Extract() error = %v, want %q matching %v", err, tt.wantErr, ErrNoCredentials)
+				}
+				if request != r {
+					t.Fatal("Extract() changed the request without credentials")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+			if credentials != tt.want {
+				t.Fatalf("Extract() = %q, want %q", credentials, tt.want)
+			}
+			if !strings.Contains(rawQuery, "api_key") {
+				if request != r {
+					t.Fatal("Extract() cloned a request without query sources")
+				}
+				return
+			}
+			if request.URL.RawQuery != tt.wantQuery || request.RequestURI != request.URL.RequestURI() {
+				t.Fatalf("Extract() URL = %s (%s), want the query %q", request.URL, request.RequestURI, tt.wantQuery)
+			}
+			if r.URL.RawQuery != rawQuery {
+				t.Fatalf("Extract() changed the URL of the original request to %s", r.URL)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/bancodobrasil/goauth/log"
+)
+
+// ReplayCache records the IDs of the DPoP proofs, so each proof is accepted only once.
+// Implementations shared by several instances (e.g. backed by Redis) must be safe for concurrent use
+type ReplayCache interface {
+	// Seen records the ID until expiresAt and reports whether it had already been recorded
+	Seen(id string, expiresAt time.Time) bool
+}
+
+// MemoryReplayCache is a ReplayCache that keeps the IDs in memory
+type MemoryReplayCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	cleanup time.Time
+}
+
+// NewMemoryReplayCache returns a new MemoryReplayCache instance
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{entries: map[string]time.Time{}}
+}
+
+// Seen implements the ReplayCache interface
+func (c *MemoryReplayCache) Seen(id string, expiresAt time.Time) bool {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.After(c.cleanup) {
+		for key, expiry := range c.entries {
+			if now.After(expiry) {
+				delete(c.entries, key)
+			}
+		}
+		c.cleanup = now.Add(time.Minute)
+	}
+
+	if expiry, ok := c.entries[id]; ok && !now.After(expiry) {
+		return true
+	}
+	c.entries[id] = expiresAt
+	return false
+}
+
+// NonceSource issues the DPoP nonces sent on the DPoP-Nonce header and validates the nonces of the proofs
+type NonceSource interface {
+	// Nonce returns a new nonce
+	Nonce() string
+	// Valid reports whether the nonce was issued by the source and is not expired
+	Valid(nonce string) bool
+}
+
+// hmacNonceSource issues stateless nonces, made of their issue time and its HMAC
+type hmacNonceSource struct {
+	key []byte
+	ttl time.Duration
+}
+
+// NewNonceSource returns a NonceSource whose nonces are valid for ttl. The nonces are signed with a
+// random key, so they are only valid on the instance that issued them
+func NewNonceSource(ttl time.Duration) NonceSource {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		log.Logf(log.Panic, "Failed to generate DPoP nonce key: %s", err)
+		return nil
+	}
+	return &hmacNonceSource{key: key, ttl: ttl}
+}
+
+// Nonce implements the NonceSource interface
+func (s *hmacNonceSource) Nonce() string {
+	data := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(data, uint64(time.Now().Unix()))
+	return base64.RawURLEncoding.EncodeToString(append(data, s.sign(data)...))
+}
+
+// Valid implements the NonceSource interface
+func (s *hmacNonceSource) Valid(nonce string) bool {
+	data, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(data) != 8+sha256.Size {
+		return false
+	}
+	if !hmac.Equal(data[8:], s.sign(data[:8])) {
+		return false
+	}
+	issuedAt := time.Unix(int64(binary.BigEndian.Uint64(data[:8])), 0)
+	return time.Since(issuedAt) <= s.ttl
+}
+
+// sign returns the HMAC of the data
+func (s *hmacNonceSource) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
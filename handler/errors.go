@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bancodobrasil/goauth/pkg/jwks"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+var (
+	// ErrNoCredentials is matched (using errors.Is) by the errors returned when the request
+	// has no credentials of the kind verified by the handler
+	ErrNoCredentials = errors.New("No credentials")
+	// ErrInvalidToken is returned when the token could not be parsed
+	ErrInvalidToken = errors.New("Invalid JWT token")
+	// ErrInvalidSignature is returned when the token signature does not match any of the keys
+	ErrInvalidSignature = errors.New("Invalid JWT signature")
+	// ErrUnsupportedAlgorithm is returned when the token alg is not one of the accepted algorithms
+	ErrUnsupportedAlgorithm = errors.New("Unsupported JWT algorithm")
+	// ErrKeyMismatch is returned when the key can not be used with the token alg
+	ErrKeyMismatch = errors.New("JWT key does not match the algorithm")
+	// ErrUnknownKeyID is returned when the token kid is not found on the key set
+	ErrUnknownKeyID = errors.New("Unknown JWT key ID")
+	// ErrKeySetUnavailable is returned when the key set could not be fetched
+	ErrKeySetUnavailable = errors.New("Failed to fetch JWKS")
+	// ErrTokenExpired is returned when the token exp claim is in the past
+	ErrTokenExpired = errors.New("JWT token is expired")
+	// ErrTokenNotYetValid is returned when the token nbf claim is in the future
+	ErrTokenNotYetValid = errors.New("JWT token is not valid yet")
+	// ErrInvalidIssuedAt is returned when the token iat claim is in the future
+	ErrInvalidIssuedAt = errors.New("Invalid JWT issued at")
+	// ErrTokenTooOld is returned when the token iat claim is older than the maximum token age
+	ErrTokenTooOld = errors.New("JWT token is too old")
+	// ErrInvalidIssuer is returned when the token iss claim is not one of the accepted issuers
+	ErrInvalidIssuer = errors.New("Invalid JWT issuer")
+	// ErrInvalidAudience is returned when the token aud claim has none of the accepted audiences
+	ErrInvalidAudience = errors.New("Invalid JWT audience")
+)
+
+// noCredentialsError is the error returned when the request has no credentials of the kind verified by the handler
+type noCredentialsError struct {
+	message string
+}
+
+// Error implements the error interface
+func (e *noCredentialsError) Error() string {
+	return e.message
+}
+
+// Is makes the error match ErrNoCredentials
+func (e *noCredentialsError) Is(target error) bool {
+	return target == ErrNoCredentials
+}
+
+// noCredentials returns an error that matches ErrNoCredentials, formatted in the manner of fmt.Sprintf
+func noCredentials(format string, args ...any) error {
+	return &noCredentialsError{message: fmt.Sprintf(format, args...)}
+}
+
+// tokenError maps the errors returned by jwt.Parse to the errors of this package
+func tokenError(err error) error {
+	switch {
+	case errors.Is(err, jwks.ErrAlgorithmNotAllowed):
+		return ErrUnsupportedAlgorithm
+	case errors.Is(err, jwks.ErrKeyMismatch):
+		return ErrKeyMismatch
+	case errors.Is(err, ErrUnknownKeyID):
+		return ErrUnknownKeyID
+	case errors.Is(err, ErrKeySetUnavailable):
+		return ErrKeySetUnavailable
+	case jws.IsVerificationError(err):
+		return ErrInvalidSignature
+	case errors.Is(err, jwt.ErrTokenExpired()):
+		return &ClaimError{Claim: jwt.ExpirationKey, Err: ErrTokenExpired}
+	case errors.Is(err, jwt.ErrTokenNotYetValid()):
+		return &ClaimError{Claim: jwt.NotBeforeKey, Err: ErrTokenNotYetValid}
+	case errors.Is(err, jwt.ErrInvalidIssuedAt()), errors.Is(err, ErrInvalidIssuedAt):
+		return &ClaimError{Claim: jwt.IssuedAtKey, Err: ErrInvalidIssuedAt}
+	case errors.Is(err, ErrTokenTooOld):
+		return &ClaimError{Claim: jwt.IssuedAtKey, Err: ErrTokenTooOld}
+	case errors.Is(err, ErrInvalidIssuer):
+		return &ClaimError{Claim: jwt.IssuerKey, Err: ErrInvalidIssuer}
+	case errors.Is(err, ErrInvalidAudience):
+		return &ClaimError{Claim: jwt.AudienceKey, Err: ErrInvalidAudience}
+	default:
+		return ErrInvalidToken
+	}
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bancodobrasil/goauth/log"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// keySource is a JWKS endpoint with its own cache, optionally discovered from an OpenID Connect issuer
+type keySource struct {
+	// issuer is the iss claim of the tokens signed by the keys of the source. Empty means any issuer
+	issuer             string
+	mu                 sync.RWMutex
+	url                string
+	discovery          *discovery
+	minRefreshInterval time.Duration
+	// missRefresh is the last time the key set was refreshed to look up an unknown key ID
+	missRefresh time.Time
+	ctx         context.Context
+	cache       *jwk.Cache
+}
+
+// newKeySource registers the JWKS endpoint on a new cache and fetches it. If discover is true,
+// the endpoint is discovered from the OpenID Connect discovery document of the issuer
+func newKeySource(issuer, url string, discover bool, cfg CacheConfig) (*keySource, error) {
+	ctx := cfg.Context
+	if ctx == nil {
+		ctx = context.Background()
+		cfg.Context = ctx
+	}
+	s := &keySource{
+		issuer:             issuer,
+		url:                url,
+		minRefreshInterval: cfg.MinRefreshInterval,
+		ctx:                ctx,
+		cache:              jwk.NewCache(ctx, jwk.WithRefreshWindow(cfg.RefreshWindow)),
+	}
+
+	if discover {
+		d, metadata, err := newDiscovery(issuer, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch OpenID Connect discovery document of %s: %w", issuer, err)
+		}
+		s.discovery = d
+		s.url = metadata.JWKSURI
+	}
+
+	if err := s.cache.Register(s.url, jwk.WithMinRefreshInterval(cfg.MinRefreshInterval)); err != nil {
+		return nil, err
+	}
+	if _, err := s.cache.Refresh(ctx, s.url); err != nil {
+		return nil, fmt.Errorf("failed to refresh JWKS %s: %w", s.url, err)
+	}
+	return s, nil
+}
+
+// refreshDiscovery updates the JWKS endpoint from the cached discovery document, if any,
+// and returns the signing algorithms supported by the issuer
+func (s *keySource) refreshDiscovery() []string {
+	if s.discovery == nil {
+		return nil
+	}
+
+	metadata, err := s.discovery.metadata(s.ctx)
+	if err != nil {
+		log.Logf(log.Error, "Failed to fetch OpenID Connect discovery document, keeping the previous one: %s\n", err)
+		return nil
+	}
+
+	s.mu.RLock()
+	changed := metadata.JWKSURI != s.url
+	s.mu.RUnlock()
+	if !changed {
+		return metadata.IDTokenSigningAlgValuesSupported
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// another request may have updated the endpoint while the lock was released
+	if metadata.JWKSURI != s.url {
+		log.Logf(log.Info, "JWKS endpoint of %s changed to %s\n", s.issuer, metadata.JWKSURI)
+		if err := s.cache.Register(metadata.JWKSURI, jwk.WithMinRefreshInterval(s.minRefreshInterval)); err != nil {
+			log.Logf(log.Error, "Failed to register JWKS endpoint: %s\n", err)
+			return metadata.IDTokenSigningAlgValuesSupported
+		}
+		s.cache.Unregister(s.url)
+		s.url = metadata.JWKSURI
+	}
+	return metadata.IDTokenSigningAlgValuesSupported
+}
+
+// getSignatureKey returns the key of the cached key set with the key ID. If the key ID is unknown,
+// the key set is refreshed, since the issuer may have rotated its keys
+func (s *keySource) getSignatureKey(ctx context.Context, keyID string) (jwk.Key, error) {
+	s.mu.RLock()
+	url := s.url
+	s.mu.RUnlock()
+
+	keyset, err := s.cache.Get(s.ctx, url)
+	if err != nil {
+		log.Logf(log.Error, "%s: %s\n", ErrKeySetUnavailable, err)
+		return nil, ErrKeySetUnavailable
+	}
+
+	key, ok := keyset.LookupKeyID(keyID)
+	if !ok && s.allowMissRefresh() {
+		keyset, err = s.cache.Refresh(s.ctx, url)
+		if err != nil {
+			log.Logf(log.Error, "%s: %s\n", ErrKeySetUnavailable, err)
+			return nil, ErrKeySetUnavailable
+		}
+		key, ok = keyset.LookupKeyID(keyID)
+	}
+	if !ok {
+		log.Logf(log.Error, "%s: %s\n", ErrUnknownKeyID, keyID)
+		return nil, ErrUnknownKeyID
+	}
+
+	return key, nil
+}
+
+// allowMissRefresh reports whether the key set can be refreshed to look up an unknown key ID. It is allowed
+// once per minRefreshInterval, so the tokens with random key IDs do not flood the JWKS endpoint
+func (s *keySource) allowMissRefresh() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.missRefresh.IsZero() && time.Since(s.missRefresh) < s.minRefreshInterval {
+		return false
+	}
+	s.missRefresh = time.Now()
+	return true
+}
@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bancodobrasil/goauth/log"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// base64KeyPrefix is the prefix of the base64 encoded HMAC secrets
+const base64KeyPrefix = "base64:"
+
+// rawKeyPrefix is the prefix of the raw HMAC secrets
+const rawKeyPrefix = "raw:"
+
+// keyOptions controls how the signature keys are parsed
+type keyOptions struct {
+	// verifyCertificates makes the certificates be verified against the roots
+	verifyCertificates bool
+	// roots are the trusted root certificates. If nil, the system roots are used
+	roots *x509.CertPool
+}
+
+// parseKeys parses the key material of a signature key: a JWK or a JWK Set (JSON), one or more
+// PEM encoded keys or certificates, a base64 encoded HMAC secret (with the base64: prefix) or a raw
+// HMAC secret (with the raw: prefix). The surrounding whitespace is ignored. Data without any of these
+// formats is still used as a raw HMAC secret, with a deprecation warning. Private keys are converted to public keys
+func parseKeys(data []byte, opts keyOptions) (jwk.Set, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, errors.New("empty signature key")
+	}
+
+	var set jwk.Set
+	var err error
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		set, err = jwk.Parse(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("-----BEGIN")):
+		set, err = parsePEM(trimmed, opts)
+	case bytes.HasPrefix(trimmed, []byte(base64KeyPrefix)):
+		var secret []byte
+		secret, err = base64.StdEncoding.DecodeString(string(bytes.TrimPrefix(trimmed, []byte(base64KeyPrefix))))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 HMAC secret: %w", err)
+		}
+		set, err = rawKeySet(secret)
+	case bytes.HasPrefix(trimmed, []byte(rawKeyPrefix)):
+		secret := bytes.TrimPrefix(trimmed, []byte(rawKeyPrefix))
+		if len(secret) == 0 {
+			return nil, errors.New("empty HMAC secret")
+		}
+		set, err = rawKeySet(secret)
+	default:
+		log.Logf(log.Warn, "Signature key without the %s prefix used as a raw HMAC secret. Unprefixed secrets are deprecated\n", rawKeyPrefix)
+		set, err = rawKeySet(trimmed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return publicKeys(set)
+}
+
+// rawKeySet returns a set with the key of the raw key (e.g. an HMAC secret)
+func rawKeySet(raw any) (jwk.Set, error) {
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		return nil, err
+	}
+	set := jwk.NewSet()
+	if err := set.AddKey(key); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// parsePEM parses the PEM encoded RSA, ECDSA and Ed25519 keys and x509 certificates. When the certificates
+// are verified, the CA certificates are only used as intermediates, and the keys of the other certificates
+// are only added if they chain up to one of the roots
+func parsePEM(data []byte, opts keyOptions) (jwk.Set, error) {
+	set := jwk.NewSet()
+	certificates := []*x509.Certificate{}
+
+	for rest := data; len(bytes.TrimSpace(rest)) > 0; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("invalid PEM block")
+		}
+
+		if block.Type == "CERTIFICATE" {
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse certificate: %w", err)
+			}
+			certificates = append(certificates, certificate)
+			continue
+		}
+
+		raw, _, err := jwk.DecodePEM(pem.EncodeToMemory(block))
+		if err != nil {
+			return nil, err
+		}
+		key, err := jwk.FromRaw(raw)
+		if err != nil {
+			return nil, err
+		}
+		if err := set.AddKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	if opts.verifyCertificates {
+		for _, certificate := range certificates {
+			if certificate.IsCA {
+				intermediates.AddCert(certificate)
+			}
+		}
+	}
+
+	for _, certificate := range certificates {
+		if opts.verifyCertificates {
+			if certificate.IsCA {
+				continue
+			}
+			_, err := certificate.Verify(x509.VerifyOptions{
+				Roots:         opts.roots,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to verify certificate %s: %w", certificate.Subject, err)
+			}
+		}
+		key, err := jwk.FromRaw(certificate.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := set.AddKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+// publicKeys returns a set with the public keys of the asymmetric keys of the set, and its symmetric keys
+func publicKeys(set jwk.Set) (jwk.Set, error) {
+	public := jwk.NewSet()
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		if _, ok := key.(jwk.SymmetricKey); !ok {
+			var err error
+			key, err = key.PublicKey()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := public.AddKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return public, nil
+}
+
+// loadKeys parses the key material of the inline keys and of the key files into a single set
+func loadKeys(inline []string, files []string, opts keyOptions) (jwk.Set, error) {
+	keys := jwk.NewSet()
+	add := func(source string, data []byte) error {
+		set, err := parseKeys(data, opts)
+		if err != nil {
+			return fmt.Errorf("failed to parse signature key %s: %w", source, err)
+		}
+		for i := 0; i < set.Len(); i++ {
+			key, _ := set.Key(i)
+			if err := keys.AddKey(key); err != nil {
+				return fmt.Errorf("failed to add signature key %s: %w", source, err)
+			}
+		}
+		return nil
+	}
+
+	for i, key := range inline {
+		if err := add(fmt.Sprintf("#%d", i), []byte(key)); err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(file, data); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// symmetricSecret returns the secret of the only key of the set, or fails the test
+func symmetricSecret(t *testing.T, set jwk.Set) string {
+	t.Helper()
+	if set.Len() != 1 {
+		t.Fatalf("set has %d keys, want 1", set.Len())
+	}
+	key, _ := set.Key(0)
+	var secret []byte
+	if err := key.Raw(&secret); err != nil {
+		t.Fatalf("key is not symmetric: %v", err)
+	}
+	return string(secret)
+}
+
+func TestParseKeys(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	public, err := jwk.FromRaw(private.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicJWK, err := json.Marshal(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		data       string
+		wantSecret string
+		wantKeys   int
+		wantErr    bool
+	}{
+		{name: "raw secret", data: "raw:secret", wantSecret: "secret"},
+		{name: "raw secret with trailing newline", data: "raw:secret\n", wantSecret: "secret"},
+		{name: "base64 secret", data: "base64:c2VjcmV0", wantSecret: "secret"},
+		{name: "base64 secret with trailing newline", data: "base64:c2VjcmV0\r\n", wantSecret: "secret"},
+		{name: "PEM public key", data: publicPEM, wantKeys: 1},
+		{name: "JWK", data: string(publicJWK) + "\n", wantKeys: 1},
+		{name: "deprecated unprefixed secret", data: "secret\n", wantSecret: "secret"},
+		{name: "empty key", data: " \n", wantErr: true},
+		{name: "empty raw secret", data: "raw:", wantErr: true},
+		{name: "invalid base64 secret", data: "base64:not base64", wantErr: true},
+		{name: "malformed PEM", data: "-----BEGIN PUBLIC KEY-----\nnot a key\n-----END PUBLIC KEY-----\n", wantErr: true},
+		{name: "malformed JWK", data: `{"kty":"EC"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := parseKeys([]byte(tt.data), keyOptions{})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseKeys() = %d keys, want an error", set.Len())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseKeys() error = %v", err)
+			}
+			if tt.wantSecret != "" {
+				if secret := symmetricSecret(t, set); secret != tt.wantSecret {
+					t.Fatalf("parseKeys() secret = %q, want %q", secret, tt.wantSecret)
+				}
+				return
+			}
+			if set.Len() != tt.wantKeys {
+				t.Fatalf("parseKeys() = %d keys, want %d", set.Len(), tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestLoadKeysTrimsFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("raw:secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := loadKeys(nil, []string{path}, keyOptions{})
+	if err != nil {
+		t.Fatalf("loadKeys() error = %v", err)
+	}
+	if secret := symmetricSecret(t, set); secret != "secret" {
+		t.Fatalf("loadKeys() secret = %q, want the secret without the trailing newline", secret)
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lestrrat-go/httprc"
+)
+
+// discoveryMetadata is the subset of the OpenID Connect discovery document used by the handlers
+type discoveryMetadata struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// discoveryTransformer parses the discovery document fetched by the httprc.Cache
+var discoveryTransformer = httprc.TransformFunc(func(u string, res *http.Response) (interface{}, error) {
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: unexpected status code %d", u, res.StatusCode)
+	}
+	var metadata discoveryMetadata
+	if err := json.NewDecoder(res.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", u, err)
+	}
+	if metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("missing jwks_uri on %s", u)
+	}
+	return &metadata, nil
+})
+
+// discovery keeps the OpenID Connect discovery document of an issuer up to date,
+// with the same refresh settings as the JWKS cache
+type discovery struct {
+	issuer string
+	url    string
+	cache  *httprc.Cache
+}
+
+// newDiscovery fetches the discovery document of the issuer and registers it to be refreshed
+func newDiscovery(issuer string, cfg CacheConfig) (*discovery, *discoveryMetadata, error) {
+	d := &discovery{
+		issuer: issuer,
+		url:    strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration",
+		cache:  httprc.NewCache(cfg.Context, httprc.WithRefreshWindow(cfg.RefreshWindow)),
+	}
+	err := d.cache.Register(d.url, httprc.WithMinRefreshInterval(cfg.MinRefreshInterval), httprc.WithTransformer(discoveryTransformer))
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := d.cache.Refresh(cfg.Context, d.url); err != nil {
+		return nil, nil, err
+	}
+	metadata, err := d.metadata(cfg.Context)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d, metadata, nil
+}
+
+// metadata returns the cached discovery document. The issuer of the document must match
+// the configured issuer (OpenID Connect Discovery 1.0, section 4.3)
+func (d *discovery) metadata(ctx context.Context) (*discoveryMetadata, error) {
+	v, err := d.cache.Get(ctx, d.url)
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := v.(*discoveryMetadata)
+	if !ok {
+		return nil, fmt.Errorf("invalid discovery document on %s", d.url)
+	}
+	if metadata.Issuer != d.issuer {
+		return nil, fmt.Errorf("discovery document issuer %s does not match %s", metadata.Issuer, d.issuer)
+	}
+	return metadata, nil
+}
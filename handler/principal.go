@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/bancodobrasil/goauth/principal"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// newTokenPrincipal builds the principal of a request authenticated by a JWT
+func newTokenPrincipal(ctx context.Context, handlerName string, token jwt.Token) *principal.Principal {
+	claims, err := token.AsMap(ctx)
+	if err != nil {
+		claims = map[string]any{}
+	}
+	return &principal.Principal{
+		Subject:   token.Subject(),
+		Issuer:    token.Issuer(),
+		Audiences: token.Audience(),
+		Scopes:    stringList(claims, "scope", "scp"),
+		Roles:     stringList(claims, "roles"),
+		Claims:    claims,
+		Handler:   handlerName,
+		IssuedAt:  token.IssuedAt(),
+		ExpiresAt: token.Expiration(),
+	}
+}
+
+// stringList returns the values of the first claim found, which may be either
+// a space-delimited string (as the OAuth 2.0 scope claim) or a list of strings
+func stringList(claims map[string]any, names ...string) []string {
+	for _, name := range names {
+		switch v := claims[name].(type) {
+		case string:
+			return strings.Fields(v)
+		case []string:
+			return v
+		case []any:
+			list := make([]string, 0, len(v))
+			for _, item := range v {
+				if s, ok := item.(string); ok {
+					list = append(list, s)
+				}
+			}
+			return list
+		}
+	}
+	return nil
+}
+
+// withPrincipal stores the principal in the request context. If payloadContextKey
+// is set, the raw payload is also stored under that key for backward compatibility
+func withPrincipal(r *http.Request, p *principal.Principal, payloadContextKey string, payload []byte) *http.Request {
+	ctx := principal.NewContext(r.Context(), p)
+	if payloadContextKey != "" {
+		ctx = context.WithValue(ctx, payloadContextKey, string(payload))
+	}
+	return r.WithContext(ctx)
+}
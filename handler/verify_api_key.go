@@ -1,56 +1,170 @@
 package handler
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"errors"
-	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/bancodobrasil/goauth/log"
+	"github.com/bancodobrasil/goauth/pkg/apikey"
+	"github.com/bancodobrasil/goauth/principal"
 )
 
+var (
+	// ErrInvalidAPIKey is returned when the API key is not found on the store or its secret does not match
+	ErrInvalidAPIKey = errors.New("Invalid API key")
+	// ErrAPIKeyExpired is returned when the API key is expired
+	ErrAPIKeyExpired = errors.New("API key is expired")
+	// ErrAPIKeyStoreUnavailable is returned when the API key store could not be queried
+	ErrAPIKeyStoreUnavailable = errors.New("Failed to look up API key")
+)
+
+// APIKeyStore looks up the records of the API keys (see package github.com/bancodobrasil/goauth/pkg/apikey)
+type APIKeyStore = apikey.Store
+
+// dummyAPIKeyHash is verified when the key is not found, so a missing key takes as long as a wrong secret
+var dummyAPIKeyHash, _ = apikey.Hash("")
+
 // VerifyAPIKeyConfig stores the configuration for the VerifyAPIKey handler
 type VerifyAPIKeyConfig struct {
 	Header string
-	Keys   []string
+	// Sources are the places of the request the API key is read from, tried in order. If empty, the key is read from Header
+	Sources []CredentialSource
+	// Keys is a list of plain text API keys, without metadata
+	Keys []string
+	// Store is the store of the hashed API keys, in the <id>.<secret> format
+	Store APIKeyStore
+	// KeyFile is the path of a JSON or YAML file with the hashed API keys (see apikey.FileStore),
+	// used when Store is nil. The keys are reloaded when the file changes
+	KeyFile string
+	// Context controls the life-cycle of the key file watcher
+	Context context.Context
 }
 
 // VerifyAPIKey stores the Header and the API key to be used for authentication
 type VerifyAPIKey struct {
-	header string
-	keys   []string
+	extractor *Extractor
+	// lookupKey is the random key of the HMAC of the API keys, so the lookup of a key on keys
+	// does not leak timing about its contents, and takes the same time whatever the number of keys
+	lookupKey []byte
+	keys      map[[sha256.Size]byte]struct{}
+	store     APIKeyStore
 }
 
 // NewVerifyAPIKey returns a new VerifyAPIKey instance
 func NewVerifyAPIKey(cfg VerifyAPIKeyConfig) *VerifyAPIKey {
-	log.Logf(log.Debug, "NewVerifyAPIKey: %v", cfg)
-	return &VerifyAPIKey{
-		header: cfg.Header,
-		keys:   cfg.Keys,
+	log.Logf(log.Debug, "NewVerifyAPIKey: header %s, %d sources, %d keys", cfg.Header, len(cfg.Sources), len(cfg.Keys))
+	store := cfg.Store
+	if store == nil && cfg.KeyFile != "" {
+		fileStore, err := apikey.NewFileStore(cfg.KeyFile)
+		if err != nil {
+			log.Logf(log.Panic, "Failed to load API key file: %s", err)
+			return nil
+		}
+		ctx := cfg.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		err = watchFile(ctx, cfg.KeyFile, func() {
+			if err := fileStore.Reload(); err != nil {
+				log.Logf(log.Error, "Failed to reload API key file, keeping the previous keys: %s", err)
+			}
+		})
+		if err != nil {
+			log.Logf(log.Error, "Failed to watch API key file: %s", err)
+		}
+		store = fileStore
 	}
 
+	lookupKey := make([]byte, sha256.Size)
+	if _, err := rand.Read(lookupKey); err != nil {
+		log.Logf(log.Panic, "Failed to generate API key lookup key: %s", err)
+		return nil
+	}
+	VerifyAPIKey := &VerifyAPIKey{
+		extractor: newExtractor(cfg.Sources, cfg.Header, ""),
+		lookupKey: lookupKey,
+		keys:      make(map[[sha256.Size]byte]struct{}, len(cfg.Keys)),
+		store:     store,
+	}
+	for _, key := range cfg.Keys {
+		VerifyAPIKey.keys[VerifyAPIKey.lookupHash(key)] = struct{}{}
+	}
+
+	return VerifyAPIKey
+
 }
 
 // Handle runs the VerifyAPIKey authentication handler
 func (a *VerifyAPIKey) Handle(r *http.Request) (request *http.Request, statusCode int, err error) {
 	log.Log(log.Debug, "VerifyAPIKey: Handle")
-	key, statusCode, err := a.extractKeyFromHeader(&r.Header)
+	key, r, err := a.extractor.Extract(r)
 	if err != nil {
-		return r, statusCode, err
+		return r, 401, err
 	}
 
-	for _, k := range a.keys {
-		if key == k {
-			return r, 0, nil
+	if _, ok := a.keys[a.lookupHash(key)]; ok {
+		p := &principal.Principal{
+			Handler: "api_key",
 		}
+		return r.WithContext(principal.NewContext(r.Context(), p)), 0, nil
+	}
+
+	if a.store != nil {
+		return a.handleStoredKey(r, key)
 	}
 
 	return r, 401, errors.New("Unauthorized")
 }
 
-func (a *VerifyAPIKey) extractKeyFromHeader(h *http.Header) (key string, statusCode int, err error) {
-	authorizationHeader := h.Get(a.header)
-	if authorizationHeader == "" {
-		return "", 401, fmt.Errorf("Missing %s Header", a.header)
+// handleStoredKey verifies the key against its record on the store
+func (a *VerifyAPIKey) handleStoredKey(r *http.Request, key string) (*http.Request, int, error) {
+	id, secret, ok := apikey.Split(key)
+	if !ok {
+		return r, 401, ErrInvalidAPIKey
+	}
+
+	record, err := a.store.Lookup(r.Context(), id)
+	if err != nil && !errors.Is(err, apikey.ErrNotFound) {
+		log.Logf(log.Error, "%s: %s", ErrAPIKeyStoreUnavailable, err)
+		return r, http.StatusServiceUnavailable, ErrAPIKeyStoreUnavailable
+	}
+
+	hash := dummyAPIKeyHash
+	if record != nil {
+		hash = record.Hash
+	}
+	valid, err := apikey.Verify(hash, secret)
+	if err != nil {
+		log.Logf(log.Error, "VerifyAPIKey: %s: %s", id, err)
 	}
-	return authorizationHeader, 0, nil
+	if record == nil || !valid {
+		return r, 401, ErrInvalidAPIKey
+	}
+	if record.Expired(time.Now()) {
+		return r, 401, ErrAPIKeyExpired
+	}
+
+	p := &principal.Principal{
+		Subject:   record.Owner,
+		Scopes:    record.Scopes,
+		Handler:   "api_key",
+		APIKeyID:  record.ID,
+		IssuedAt:  record.CreatedAt,
+		ExpiresAt: record.ExpiresAt,
+	}
+	return r.WithContext(principal.NewContext(r.Context(), p)), 0, nil
+}
+
+// lookupHash returns the HMAC of the key used to look it up on keys
+func (a *VerifyAPIKey) lookupHash(key string) [sha256.Size]byte {
+	mac := hmac.New(sha256.New, a.lookupKey)
+	mac.Write([]byte(key))
+	var sum [sha256.Size]byte
+	copy(sum[:], mac.Sum(nil))
+	return sum
 }
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestAPIKeys returns n plain text API keys
+func newTestAPIKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%08d-0123456789abcdef", i)
+	}
+	return keys
+}
+
+// apiKeyRequest returns a request with the API key on the X-API-Key header
+func apiKeyRequest(key string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-API-Key", key)
+	return r
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	keys := newTestAPIKeys(3)
+	h := NewVerifyAPIKey(VerifyAPIKeyConfig{Header: "X-API-Key", Keys: keys})
+
+	if _, _, err := h.Handle(apiKeyRequest(keys[1])); err != nil {
+		t.Fatalf("Handle() error = %v, want nil", err)
+	}
+	if _, status, err := h.Handle(apiKeyRequest("key-99999999-0123456789abcdef")); err == nil || status != http.StatusUnauthorized {
+		t.Fatalf("Handle() = %d, %v, want 401 and an error", status, err)
+	}
+}
+
+// BenchmarkVerifyAPIKey measures the lookup of the plain text API keys, which must take
+// the same time for hits and misses, whatever the number of keys
+func BenchmarkVerifyAPIKey(b *testing.B) {
+	for _, n := range []int{10, 100000} {
+		keys := newTestAPIKeys(n)
+		h := NewVerifyAPIKey(VerifyAPIKeyConfig{Header: "X-API-Key", Keys: keys})
+
+		for _, bc := range []struct {
+			name string
+			key  string
+		}{
+			{"hit", keys[n/2]},
+			{"miss", "key-99999999-0123456789abcdef"},
+		} {
+			b.Run(fmt.Sprintf("%s/keys=%d", bc.name, n), func(b *testing.B) {
+				r := apiKeyRequest(bc.key)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					h.Handle(r)
+				}
+			})
+		}
+	}
+}
+
+// TestVerifyAPIKeyTiming checks that the lookups of hits and misses take about the same time. The medians
+// of batches of lookups are compared with a generous bound, so the test is not flaky on busy machines
+func TestVerifyAPIKeyTiming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing test skipped in short mode")
+	}
+	keys := newTestAPIKeys(10000)
+	h := NewVerifyAPIKey(VerifyAPIKeyConfig{Header: "X-API-Key", Keys: keys})
+	hit := keys[5000]
+	miss := "key-99999999-0123456789abcdef"
+
+	const samples, batch = 51, 1000
+	measure := func(key string) time.Duration {
+		start := time.Now()
+		for i := 0; i < batch; i++ {
+			_, found := h.keys[h.lookupHash(key)]
+			if found != (key == hit) {
+				t.Fatalf("lookup of %s = %t", key, found)
+			}
+		}
+		return time.Since(start)
+	}
+	hits := make([]time.Duration, samples)
+	misses := make([]time.Duration, samples)
+	for i := 0; i < samples; i++ {
+		// interleaved, so both are measured under the same load
+		hits[i] = measure(hit)
+		misses[i] = measure(miss)
+	}
+	median := func(d []time.Duration) time.Duration {
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		return d[len(d)/2]
+	}
+
+	hitMedian, missMedian := median(hits), median(misses)
+	ratio := float64(missMedian) / float64(hitMedian)
+	t.Logf("median of %d lookups: hit %s, miss %s, ratio %.2f", batch, hitMedian, missMedian, ratio)
+	if ratio < 0.67 || ratio > 1.5 {
+		t.Fatalf("hits and misses take different times: hit %s, miss %s", hitMedian, missMedian)
+	}
+}
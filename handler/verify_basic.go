@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bancodobrasil/goauth/log"
+	"github.com/bancodobrasil/goauth/pkg/htpasswd"
+	"github.com/bancodobrasil/goauth/principal"
+)
+
+// dummyBasicHash is verified when the user is not found, so an unknown user takes as long as a wrong password
+// and the response time does not reveal which users exist. It is a bcrypt hash with the default cost
+const dummyBasicHash = "$2a$10$OozkM6eDZglFuA.fHhh/Vuj03cv26j/ypNiy1mYO5RnJGpdqb7T2a"
+
+// VerifyBasicConfig stores the configuration for the VerifyBasic handler
+type VerifyBasicConfig struct {
+	// Realm is the realm sent on the WWW-Authenticate challenge
+	Realm string
+	// Users maps the user names to their password hashes, in any of the formats supported by the htpasswd file
+	Users map[string]string
+	// HtpasswdFile is the path of an Apache htpasswd file. Its users are added to Users
+	// and reloaded when the file changes. Supported hashes are bcrypt, SHA-256 crypt, SHA-512 crypt and argon2id
+	HtpasswdFile string
+	// Context controls the life-cycle of the htpasswd file watcher
+	Context context.Context
+}
+
+// VerifyBasic stores the users allowed to authenticate with HTTP Basic authentication (RFC 7617)
+type VerifyBasic struct {
+	realm        string
+	staticUsers  map[string]string
+	htpasswdFile string
+	mu           sync.RWMutex
+	users        map[string]string
+}
+
+// NewVerifyBasic returns a new VerifyBasic instance
+func NewVerifyBasic(cfg VerifyBasicConfig) *VerifyBasic {
+	log.Log(log.Debug, "VerifyBasic: NewVerifyBasic")
+	for user, hash := range cfg.Users {
+		if !htpasswd.Supported(hash) {
+			log.Logf(log.Panic, "%s for user %s", htpasswd.ErrUnsupportedHash, user)
+			return nil
+		}
+	}
+
+	VerifyBasic := &VerifyBasic{
+		realm:        cfg.Realm,
+		staticUsers:  cfg.Users,
+		htpasswdFile: cfg.HtpasswdFile,
+	}
+
+	if err := VerifyBasic.load(); err != nil {
+		log.Logf(log.Panic, "Failed to load htpasswd file: %s", err)
+		return nil
+	}
+
+	if cfg.HtpasswdFile != "" {
+		ctx := cfg.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		err := watchFile(ctx, cfg.HtpasswdFile, func() {
+			if err := VerifyBasic.load(); err != nil {
+				log.Logf(log.Error, "Failed to reload htpasswd file, keeping the previous users: %s", err)
+			}
+		})
+		if err != nil {
+			log.Logf(log.Error, "Failed to watch htpasswd file: %s", err)
+		}
+	}
+
+	return VerifyBasic
+}
+
+// load replaces the users with the static users and the users of the htpasswd file
+func (b *VerifyBasic) load() error {
+	users := map[string]string{}
+	if b.htpasswdFile != "" {
+		fileUsers, err := htpasswd.Load(b.htpasswdFile)
+		if err != nil {
+			return err
+		}
+		users = fileUsers
+	}
+	for user, hash := range b.staticUsers {
+		users[user] = hash
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.users = users
+	return nil
+}
+
+// Handle runs the VerifyBasic authentication handler
+func (b *VerifyBasic) Handle(r *http.Request) (request *http.Request, statusCode int, err error) {
+	log.Log(log.Debug, "VerifyBasic: Handle")
+	request, statusCode, err = b.handle(r)
+	return request, statusCode, WithChallenge(err, Challenge{Scheme: "Basic", Realm: b.realm})
+}
+
+func (b *VerifyBasic) handle(r *http.Request) (*http.Request, int, error) {
+	authorizationHeader := r.Header.Get("Authorization")
+	if authorizationHeader == "" {
+		return r, 401, noCredentials("Missing Authorization Header")
+	}
+	scheme, _, _ := strings.Cut(authorizationHeader, " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		return r, 401, noCredentials("Invalid Authorization Header")
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return r, 401, errors.New("Invalid Authorization Header")
+	}
+
+	b.mu.RLock()
+	hash, found := b.users[user]
+	b.mu.RUnlock()
+
+	if !found {
+		hash = dummyBasicHash
+	}
+
+	valid, err := htpasswd.Verify(hash, password)
+	if err != nil {
+		log.Logf(log.Error, "VerifyBasic: %s", err)
+	}
+	if !found || !valid {
+		return r, 401, errors.New("Invalid credentials")
+	}
+
+	p := &principal.Principal{
+		Subject: user,
+		Handler: "basic",
+	}
+	return r.WithContext(principal.NewContext(r.Context(), p)), 0, nil
+}
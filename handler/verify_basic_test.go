@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func basicRequest(user, password string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth(user, password)
+	return r
+}
+
+func TestVerifyBasic(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewVerifyBasic(VerifyBasicConfig{Users: map[string]string{"alice": string(hash)}})
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		wantErr  bool
+	}{
+		{name: "valid credentials", user: "alice", password: "secret"},
+		{name: "wrong password", user: "alice", password: "wrong", wantErr: true},
+		{name: "unknown user", user: "bob", password: "secret", wantErr: true},
+		{name: "unknown user with the dummy password", user: "bob", password: "goauth-dummy-password", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, status, err := h.Handle(basicRequest(tt.user, tt.password))
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Handle() error = %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr && status != http.StatusUnauthorized {
+				t.Fatalf("Handle() status = %d, want %d", status, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+// TestVerifyBasicUnknownUserTiming checks that an unknown user is verified against a hash,
+// so it takes about as long as a wrong password
+func TestVerifyBasicUnknownUserTiming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing test skipped in short mode")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewVerifyBasic(VerifyBasicConfig{Users: map[string]string{"alice": string(hash)}})
+
+	measure := func(user string) time.Duration {
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			h.Handle(basicRequest(user, "wrong"))
+		}
+		return time.Since(start)
+	}
+	wrongPassword, unknownUser := measure("alice"), measure("bob")
+	t.Logf("wrong password %s, unknown user %s", wrongPassword, unknownUser)
+	if unknownUser < wrongPassword/2 {
+		t.Fatalf("an unknown user takes %s, a wrong password %s", unknownUser, wrongPassword)
+	}
+}
@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bancodobrasil/goauth/log"
+	"github.com/bancodobrasil/goauth/principal"
+)
+
+var (
+	// ErrInvalidClientCert is returned when the client certificate can not be parsed or its chain is not trusted
+	ErrInvalidClientCert = errors.New("Invalid client certificate")
+	// ErrClientCertNotAllowed is returned when the identity of the client certificate is not on the allow-lists
+	ErrClientCertNotAllowed = errors.New("Client certificate is not allowed")
+)
+
+// VerifyClientCertConfig stores the configuration for the VerifyClientCert handler
+type VerifyClientCertConfig struct {
+	// Roots are the trusted CA certificates. They are required: the system roots are never used, since they
+	// would authenticate any publicly trusted certificate
+	Roots *x509.CertPool
+	// AllowedSubjects are patterns matched against the common name and the distinguished name of the subject
+	// (e.g. CN=billing,O=Example). The * wildcard matches any sequence of characters
+	AllowedSubjects []string
+	// AllowedSANs are patterns matched against the DNS, email, IP and URI subject alternative names
+	AllowedSANs []string
+	// AllowedSPIFFEIDs are patterns matched against the SPIFFE ID, the spiffe:// URI SAN
+	// (e.g. spiffe://example.org/ns/payments/*)
+	AllowedSPIFFEIDs []string
+	// ProxyHeader is the header with the client certificate set by a proxy that terminates TLS,
+	// such as X-Forwarded-Client-Cert. It is only read on the requests from the TrustedProxies
+	ProxyHeader string
+	// TrustedProxies is the list of IP addresses or CIDR ranges of the proxies allowed to send the ProxyHeader
+	TrustedProxies []string
+}
+
+// VerifyClientCert authenticates the requests by their TLS client certificate (mutual TLS)
+type VerifyClientCert struct {
+	reader     *ClientCertReader
+	roots      *x509.CertPool
+	subjects   []*regexp.Regexp
+	sans       []*regexp.Regexp
+	spiffeIDs  []*regexp.Regexp
+	restricted bool
+}
+
+// NewVerifyClientCert returns a new VerifyClientCert instance
+func NewVerifyClientCert(cfg VerifyClientCertConfig) *VerifyClientCert {
+	log.Log(log.Debug, "VerifyClientCert: NewVerifyClientCert")
+	if cfg.Roots == nil {
+		log.Log(log.Panic, "The client certificate handler requires the trusted CA certificates")
+		return nil
+	}
+	reader, err := NewClientCertReader(cfg.ProxyHeader, cfg.TrustedProxies)
+	if err != nil {
+		log.Log(log.Panic, err)
+		return nil
+	}
+
+	return &VerifyClientCert{
+		reader:     reader,
+		roots:      cfg.Roots,
+		subjects:   compilePatterns(cfg.AllowedSubjects),
+		sans:       compilePatterns(cfg.AllowedSANs),
+		spiffeIDs:  compilePatterns(cfg.AllowedSPIFFEIDs),
+		restricted: len(cfg.AllowedSubjects)+len(cfg.AllowedSANs)+len(cfg.AllowedSPIFFEIDs) > 0,
+	}
+}
+
+// Handle runs the VerifyClientCert authentication handler
+func (m *VerifyClientCert) Handle(r *http.Request) (*http.Request, int, error) {
+	log.Log(log.Debug, "VerifyClientCert: Handle")
+	certs, err := m.reader.Certificates(r)
+	if err != nil {
+		log.Log(log.Error, err)
+		return r, 401, ErrInvalidClientCert
+	}
+	if len(certs) == 0 {
+		return r, 401, noCredentials("Missing client certificate")
+	}
+
+	cert := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range certs[1:] {
+		intermediates.AddCert(intermediate)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         m.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		log.Logf(log.Error, "%s: %s", ErrInvalidClientCert, err)
+		return r, 401, ErrInvalidClientCert
+	}
+
+	if m.restricted && !m.allowed(cert) {
+		log.Logf(log.Error, "%s: %s", ErrClientCertNotAllowed, cert.Subject)
+		return r, 403, ErrClientCertNotAllowed
+	}
+
+	return r.WithContext(principal.NewContext(r.Context(), newCertPrincipal(cert))), 0, nil
+}
+
+// allowed reports whether the identity of the certificate matches any of the allow-lists
+func (m *VerifyClientCert) allowed(cert *x509.Certificate) bool {
+	if matchAny(m.subjects, cert.Subject.CommonName, cert.Subject.String()) {
+		return true
+	}
+	if matchAny(m.sans, subjectAltNames(cert)...) {
+		return true
+	}
+	return matchAny(m.spiffeIDs, spiffeID(cert))
+}
+
+// newCertPrincipal builds the principal of a request authenticated by a client certificate.
+// The subject is the SPIFFE ID of the certificate or, if it has none, the common name
+func newCertPrincipal(cert *x509.Certificate) *principal.Principal {
+	subject := spiffeID(cert)
+	if subject == "" {
+		subject = cert.Subject.CommonName
+	}
+	if subject == "" {
+		subject = cert.Subject.String()
+	}
+
+	uris := make([]string, 0, len(cert.URIs))
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+	ips := make([]string, 0, len(cert.IPAddresses))
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+
+	return &principal.Principal{
+		Subject: subject,
+		Issuer:  cert.Issuer.String(),
+		Claims: map[string]any{
+			"subject":   cert.Subject.String(),
+			"issuer":    cert.Issuer.String(),
+			"serial":    cert.SerialNumber.String(),
+			"dns_names": cert.DNSNames,
+			"emails":    cert.EmailAddresses,
+			"ips":       ips,
+			"uris":      uris,
+			"spiffe_id": spiffeID(cert),
+			"x5t#S256":  CertificateThumbprint(cert),
+		},
+		Handler:   "client_cert",
+		IssuedAt:  cert.NotBefore,
+		ExpiresAt: cert.NotAfter,
+	}
+}
+
+// spiffeID returns the SPIFFE ID of the certificate, its spiffe:// URI SAN, if any
+func spiffeID(cert *x509.Certificate) string {
+	for _, uri := range cert.URIs {
+		if strings.EqualFold(uri.Scheme, "spiffe") {
+			return uri.String()
+		}
+	}
+	return ""
+}
+
+// subjectAltNames returns the DNS, email, IP and URI subject alternative names of the certificate
+func subjectAltNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// compilePatterns compiles the patterns with the * wildcard to anchored regular expressions
+func compilePatterns(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		quoted := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+		compiled = append(compiled, regexp.MustCompile("^"+quoted+"$"))
+	}
+	return compiled
+}
+
+// matchAny reports whether any of the values matches any of the patterns
+func matchAny(patterns []*regexp.Regexp, values ...string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if value != "" && pattern.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bancodobrasil/goauth/log"
+	"github.com/bancodobrasil/goauth/principal"
+)
+
+// testCA is a certificate authority that issues test certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// newTestCA returns a self-signed root CA
+func newTestCA(t *testing.T, cn string) *testCA {
+	t.Helper()
+	return issueTestCA(t, cn, nil)
+}
+
+// issueTestCA returns a CA issued by the parent, or a self-signed CA if parent is nil
+func issueTestCA(t *testing.T, cn string, parent *testCA) *testCA {
+	t.Helper()
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: cn},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	cert, key := issueTestCert(t, template, parent)
+	return &testCA{cert: cert, key: key}
+}
+
+// issueLeaf returns a client certificate issued by the CA, with the subject alternative names of the template
+func (ca *testCA) issueLeaf(t *testing.T, template *x509.Certificate) *x509.Certificate {
+	t.Helper()
+	if template.ExtKeyUsage == nil {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	cert, _ := issueTestCert(t, template, ca)
+	return cert
+}
+
+// issueTestCert completes the template and signs it by the parent, or by its own key if parent is nil
+func issueTestCert(t *testing.T, template *x509.Certificate, parent *testCA) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	issuer, signer := template, crypto.Signer(key)
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// tlsRequest returns a request with the certificate chain on its TLS connection
+func tlsRequest(chain ...*x509.Certificate) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: chain}
+	return r
+}
+
+func TestVerifyClientCert(t *testing.T) {
+	root := newTestCA(t, "root")
+	intermediate := issueTestCA(t, "intermediate", root)
+	other := newTestCA(t, "other")
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	spiffe, _ := url.Parse("spiffe://example.org/ns/payments/sa/api")
+	billing := root.issueLeaf(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing", Organization: []string{"Example"}}})
+	payments := intermediate.issueLeaf(t, &x509.Certificate{Subject: pkix.Name{CommonName: "payments"}, URIs: []*url.URL{spiffe}})
+	reports := root.issueLeaf(t, &x509.Certificate{Subject: pkix.Name{CommonName: "reports"}, DNSNames: []string{"reports.internal.example.com"}})
+	untrusted := other.issueLeaf(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}})
+	server := root.issueLeaf(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+
+	open := NewVerifyClientCert(VerifyClientCertConfig{Roots: roots})
+	restricted := NewVerifyClientCert(VerifyClientCertConfig{
+		Roots:            roots,
+		AllowedSubjects:  []string{"CN=billing,O=Example"},
+		AllowedSANs:      []string{"*.internal.example.com"},
+		AllowedSPIFFEIDs: []string{"spiffe://example.org/ns/payments/*"},
+	})
+
+	tests := []struct {
+		name        string
+		handler     *VerifyClientCert
+		request     *http.Request
+		wantStatus  int
+		wantErr     error
+		wantSubject string
+	}{
+		{name: "issued by the root", handler: open, request: tlsRequest(billing), wantSubject: "billing"},
+		{name: "issued by an intermediate", handler: open, request: tlsRequest(payments, intermediate.cert), wantSubject: spiffe.String()},
+		{
+			name:       "intermediate not sent",
+			handler:    open,
+			request:    tlsRequest(payments),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrInvalidClientCert,
+		},
+		{
+			name:       "issued by another CA",
+			handler:    open,
+			request:    tlsRequest(untrusted),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrInvalidClientCert,
+		},
+		{
+			name:       "server certificate",
+			handler:    open,
+			request:    tlsRequest(server),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrInvalidClientCert,
+		},
+		{
+			name:       "missing certificate",
+			handler:    open,
+			request:    httptest.NewRequest(http.MethodGet, "/", nil),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrNoCredentials,
+		},
+		{name: "allowed subject", handler: restricted, request: tlsRequest(billing), wantSubject: "billing"},
+		{name: "allowed SAN pattern", handler: restricted, request: tlsRequest(reports), wantSubject: "reports"},
+		{name: "allowed SPIFFE ID pattern", handler: restricted, request: tlsRequest(payments, intermediate.cert), wantSubject: spiffe.String()},
+		{
+			name:       "not on the allow-lists",
+			handler:    restricted,
+			request:    tlsRequest(root.issueLeaf(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}})),
+			wantStatus: http.StatusForbidden,
+			wantErr:    ErrClientCertNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, status, err := tt.handler.Handle(tt.request)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || status != tt.wantStatus {
+					t.Fatalf("Handle() = %d, %v, want %d, %v", status, err, tt.wantStatus, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Handle() error = %v, want nil", err)
+			}
+			p, ok := principal.FromContext(r.Context())
+			if !ok || p.Subject != tt.wantSubject || p.Handler != "client_cert" {
+				t.Fatalf("Handle() principal = %+v, want the subject %s", p, tt.wantSubject)
+			}
+		})
+	}
+}
+
+func TestNewVerifyClientCertRequiresRoots(t *testing.T) {
+	if h := NewVerifyClientCert(VerifyClientCertConfig{}); h != nil {
+		t.Fatal("NewVerifyClientCert() accepted a configuration without CA certificates")
+	}
+
+	log.SetLogger(log.NewDefaultLogger(log.Panic))
+	defer log.SetLogger(nil)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewVerifyClientCert() did not panic without CA certificates")
+		}
+	}()
+	NewVerifyClientCert(VerifyClientCertConfig{AllowedSubjects: []string{"billing"}})
+}
+
+func TestCompilePatterns(t *testing.T) {
+	patterns := compilePatterns([]string{" CN=billing ", "", "spiffe://example.org/ns/*/sa/api", "a.b"})
+
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "CN=billing", want: true},
+		{value: "CN=billing2", want: false},
+		{value: "xCN=billing", want: false},
+		{value: "spiffe://example.org/ns/payments/sa/api", want: true},
+		{value: "spiffe://example.org/ns/payments/sa/api/other", want: false},
+		{value: "a.b", want: true},
+		{value: "axb", want: false},
+		{value: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := matchAny(patterns, tt.value); got != tt.want {
+			t.Errorf("matchAny(%q) = %t, want %t", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bancodobrasil/goauth/log"
+	"github.com/bancodobrasil/goauth/pkg/jwks"
+	"github.com/bancodobrasil/goauth/principal"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+var (
+	// ErrInvalidDPoPProof is returned when the DPoP proof is malformed, has an invalid signature or its claims do not match the request
+	ErrInvalidDPoPProof = errors.New("Invalid DPoP proof")
+	// ErrDPoPProofRequired is returned when the access token is bound to a DPoP key, or DPoP is required, and the request has no proof
+	ErrDPoPProofRequired = errors.New("DPoP proof is required")
+	// ErrDPoPProofReplayed is returned when the DPoP proof was already used
+	ErrDPoPProofReplayed = errors.New("DPoP proof was already used")
+	// ErrDPoPNonceRequired is returned when the DPoP proof has no nonce, or an invalid one, and nonces are required
+	ErrDPoPNonceRequired = errors.New("DPoP nonce is required")
+	// ErrDPoPKeyMismatch is returned when the cnf.jkt claim of the access token is not the thumbprint of the DPoP proof key
+	ErrDPoPKeyMismatch = errors.New("DPoP proof key does not match the access token")
+)
+
+// Handler authenticates a request, as the goauth.AuthHandler interface
+type Handler interface {
+	Handle(r *http.Request) (request *http.Request, statusCode int, err error)
+}
+
+// VerifyDPoPConfig stores the configuration for the VerifyDPoP handler
+type VerifyDPoPConfig struct {
+	// Handler verifies the access token, e.g. a VerifyJWKS or VerifyIntrospection that reads the token from
+	// the DPoP authorization scheme (see CredentialSource). Its principal must have the cnf claim of the token
+	Handler Handler
+	// Sources are the places of the request the access token is read from, to check the ath claim of the proof.
+	// Defaults to the DPoP authorization scheme
+	Sources []CredentialSource
+	// Required makes the requests without a DPoP proof be rejected. Otherwise, only the access tokens
+	// bound to a DPoP key (with the cnf.jkt claim) require a proof
+	Required bool
+	// Algorithms is the allow-list of the signature algorithms of the proofs. Defaults to all the asymmetric algorithms
+	Algorithms []string
+	// MaxAge is the maximum time since the iat claim of the proof. Defaults to 5 minutes
+	MaxAge time.Duration
+	// ClockSkew is the allowed clock skew when validating the iat claim of the proof
+	ClockSkew time.Duration
+	// ReplayCache records the jti claim of the proofs. Defaults to a MemoryReplayCache
+	ReplayCache ReplayCache
+	// Nonces issues and validates the nonce claim of the proofs. If nil, nonces are not required
+	Nonces NonceSource
+	// BaseURL is the external URL of the server (e.g. https://api.example.com), used to check the htu claim
+	// of the proofs behind a proxy. If empty, the URL is taken from the request
+	BaseURL string
+	// Realm is the realm sent on the WWW-Authenticate challenge
+	Realm string
+}
+
+// VerifyDPoP wraps a token handler to verify the DPoP proof-of-possession of the access tokens (RFC 9449)
+type VerifyDPoP struct {
+	handler    Handler
+	extractor  *Extractor
+	required   bool
+	keyHandler *jwks.KeyHandler
+	maxAge     time.Duration
+	clockSkew  time.Duration
+	replay     ReplayCache
+	nonces     NonceSource
+	baseURL    string
+	realm      string
+}
+
+// NewVerifyDPoP returns a new VerifyDPoP instance
+func NewVerifyDPoP(cfg VerifyDPoPConfig) *VerifyDPoP {
+	log.Log(log.Debug, "VerifyDPoP: NewVerifyDPoP")
+	if cfg.Handler == nil {
+		log.Log(log.Panic, "The DPoP handler requires a token handler")
+		return nil
+	}
+	algorithms, err := jwks.ParseAlgorithms(cfg.Algorithms)
+	if err != nil {
+		log.Log(log.Panic, err)
+		return nil
+	}
+	for _, alg := range algorithms {
+		if jwks.IsSymmetric(alg) || alg == jwa.NoSignature {
+			log.Logf(log.Panic, "The DPoP proofs must be signed with an asymmetric algorithm: %s", alg)
+			return nil
+		}
+	}
+
+	sources := cfg.Sources
+	if len(sources) == 0 {
+		sources = []CredentialSource{{Kind: SourceAuthorization, Name: "DPoP"}}
+	}
+	maxAge := cfg.MaxAge
+	if maxAge == 0 {
+		maxAge = 5 * time.Minute
+	}
+	replay := cfg.ReplayCache
+	if replay == nil {
+		replay = NewMemoryReplayCache()
+	}
+
+	return &VerifyDPoP{
+		handler:    cfg.Handler,
+		extractor:  NewExtractor(sources...),
+		required:   cfg.Required,
+		keyHandler: &jwks.KeyHandler{Algorithms: algorithms},
+		maxAge:     maxAge,
+		clockSkew:  cfg.ClockSkew,
+		replay:     replay,
+		nonces:     cfg.Nonces,
+		baseURL:    strings.TrimSuffix(cfg.BaseURL, "/"),
+		realm:      cfg.Realm,
+	}
+}
+
+// Handle runs the VerifyDPoP authentication handler
+func (m *VerifyDPoP) Handle(r *http.Request) (*http.Request, int, error) {
+	log.Log(log.Debug, "VerifyDPoP: Handle")
+	request, statusCode, err := m.handle(r)
+	if err == nil {
+		return request, statusCode, nil
+	}
+
+	challenges := []Challenge{m.challenge(err)}
+	if !m.required {
+		challenges = append(ChallengesOf(err), challenges...)
+	}
+	err = WithChallenge(err, challenges...)
+	if m.nonces != nil && (errors.Is(err, ErrDPoPNonceRequired) || errors.Is(err, ErrInvalidDPoPProof)) {
+		err = WithHeader(err, "DPoP-Nonce", m.nonces.Nonce())
+	}
+	return request, statusCode, err
+}
+
+func (m *VerifyDPoP) handle(r *http.Request) (*http.Request, int, error) {
+	proofs := r.Header.Values("DPoP")
+
+	request, statusCode, err := m.handler.Handle(r)
+	if err != nil {
+		return r, statusCode, err
+	}
+	jkt := boundKey(request)
+
+	if len(proofs) == 0 {
+		if m.required || jkt != "" {
+			return r, 401, ErrDPoPProofRequired
+		}
+		return request, 0, nil
+	}
+	if len(proofs) > 1 {
+		return r, 401, ErrInvalidDPoPProof
+	}
+
+	token, _, err := m.extractor.Extract(r)
+	if err != nil {
+		log.Logf(log.Error, "%s: the access token is not sent with the DPoP scheme", ErrInvalidDPoPProof)
+		return r, 401, ErrInvalidDPoPProof
+	}
+
+	thumbprint, err := m.verifyProof(r, proofs[0], token)
+	if err != nil {
+		return r, 401, err
+	}
+	if jkt != thumbprint {
+		log.Logf(log.Error, "%s: cnf.jkt %s, proof key %s", ErrDPoPKeyMismatch, jkt, thumbprint)
+		return r, 401, ErrDPoPKeyMismatch
+	}
+	return request, 0, nil
+}
+
+// verifyProof verifies the DPoP proof of the request and returns the thumbprint of its key
+func (m *VerifyDPoP) verifyProof(r *http.Request, proof string, token string) (string, error) {
+	msg, err := jws.Parse([]byte(proof))
+	if err != nil || len(msg.Signatures()) != 1 {
+		log.Logf(log.Error, "%s: %v", ErrInvalidDPoPProof, err)
+		return "", ErrInvalidDPoPProof
+	}
+
+	headers := msg.Signatures()[0].ProtectedHeaders()
+	if headers.Type() != "dpop+jwt" {
+		log.Logf(log.Error, "%s: typ %s", ErrInvalidDPoPProof, headers.Type())
+		return "", ErrInvalidDPoPProof
+	}
+	key := headers.JWK()
+	if key == nil {
+		log.Logf(log.Error, "%s: missing jwk", ErrInvalidDPoPProof)
+		return "", ErrInvalidDPoPProof
+	}
+	if private, err := jwk.IsPrivateKey(key); err != nil || private {
+		log.Logf(log.Error, "%s: the jwk is not a public key", ErrInvalidDPoPProof)
+		return "", ErrInvalidDPoPProof
+	}
+	if err := m.keyHandler.Check(key, headers.Algorithm()); err != nil {
+		log.Logf(log.Error, "%s: %s", ErrInvalidDPoPProof, err)
+		return "", ErrInvalidDPoPProof
+	}
+
+	payload, err := jws.Verify([]byte(proof), jws.WithKey(headers.Algorithm(), key))
+	if err != nil {
+		log.Logf(log.Error, "%s: %s", ErrInvalidDPoPProof, err)
+		return "", ErrInvalidDPoPProof
+	}
+	claims, err := jwt.Parse(payload, jwt.WithVerify(false), jwt.WithValidate(false))
+	if err != nil {
+		log.Logf(log.Error, "%s: %s", ErrInvalidDPoPProof, err)
+		return "", ErrInvalidDPoPProof
+	}
+
+	if err := m.checkClaims(r, claims, token); err != nil {
+		return "", err
+	}
+
+	sum, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		log.Logf(log.Error, "%s: %s", ErrInvalidDPoPProof, err)
+		return "", ErrInvalidDPoPProof
+	}
+	thumbprint := base64.RawURLEncoding.EncodeToString(sum)
+
+	expiresAt := claims.IssuedAt().Add(m.maxAge + m.clockSkew)
+	if m.replay.Seen(thumbprint+":"+claims.JwtID(), expiresAt) {
+		log.Logf(log.Error, "%s: jti %s", ErrDPoPProofReplayed, claims.JwtID())
+		return "", ErrDPoPProofReplayed
+	}
+	return thumbprint, nil
+}
+
+// checkClaims verifies the jti, htm, htu, iat, ath and nonce claims of the proof
+func (m *VerifyDPoP) checkClaims(r *http.Request, claims jwt.Token, token string) error {
+	htm := stringClaim(claims, "htm")
+	htu := stringClaim(claims, "htu")
+	expectedHTU := normalizeHTU(m.requestURL(r))
+	if claims.JwtID() == "" || htm != r.Method || expectedHTU == "" || normalizeHTU(htu) != expectedHTU {
+		log.Logf(log.Error, "%s: jti %q, htm %s, htu %s", ErrInvalidDPoPProof, claims.JwtID(), htm, htu)
+		return ErrInvalidDPoPProof
+	}
+
+	now := time.Now()
+	iat := claims.IssuedAt()
+	if iat.IsZero() || iat.After(now.Add(m.clockSkew)) || now.Sub(iat) > m.maxAge+m.clockSkew {
+		log.Logf(log.Error, "%s: iat %s", ErrInvalidDPoPProof, iat)
+		return ErrInvalidDPoPProof
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	if stringClaim(claims, "ath") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		log.Logf(log.Error, "%s: ath does not match the access token", ErrInvalidDPoPProof)
+		return ErrInvalidDPoPProof
+	}
+
+	if m.nonces != nil && !m.nonces.Valid(stringClaim(claims, "nonce")) {
+		return ErrDPoPNonceRequired
+	}
+	return nil
+}
+
+// requestURL returns the URL of the request, without the query and fragment
+func (m *VerifyDPoP) requestURL(r *http.Request) string {
+	if m.baseURL != "" {
+		return m.baseURL + r.URL.EscapedPath()
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.EscapedPath()
+}
+
+// challenge returns the DPoP challenge for the error
+func (m *VerifyDPoP) challenge(err error) Challenge {
+	c := Challenge{Scheme: "DPoP", Realm: m.realm}
+	switch {
+	case errors.Is(err, ErrNoCredentials):
+	case errors.Is(err, ErrDPoPNonceRequired):
+		c.Error = "use_dpop_nonce"
+		c.ErrorDescription = err.Error()
+	case errors.Is(err, ErrInvalidDPoPProof), errors.Is(err, ErrDPoPProofReplayed):
+		c.Error = "invalid_dpop_proof"
+		c.ErrorDescription = err.Error()
+	default:
+		c.Error = "invalid_token"
+		c.ErrorDescription = err.Error()
+	}
+	return c
+}
+
+// boundKey returns the cnf.jkt claim of the access token of the request, if any
+func boundKey(r *http.Request) string {
+	principals := principal.AllFromContext(r.Context())
+	if len(principals) == 0 {
+		return ""
+	}
+	cnf, _ := principals[len(principals)-1].Claims["cnf"].(map[string]any)
+	jkt, _ := cnf["jkt"].(string)
+	return jkt
+}
+
+// stringClaim returns the claim of the token if it is a string
+func stringClaim(token jwt.Token, name string) string {
+	value, _ := token.Get(name)
+	s, _ := value.(string)
+	return s
+}
+
+// normalizeHTU returns the URL with lower case scheme and host, without the default port, the query and the fragment
+func normalizeHTU(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	if (scheme == "https" && strings.HasSuffix(host, ":443")) || (scheme == "http" && strings.HasSuffix(host, ":80")) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path
+}
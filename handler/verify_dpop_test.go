@@ -0,0 +1,449 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bancodobrasil/goauth/log"
+	"github.com/bancodobrasil/goauth/principal"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+const testDPoPToken = "access-token"
+
+// stubTokenHandler authenticates the DPoP access tokens, setting the cnf.jkt claim of its principal
+type stubTokenHandler struct {
+	jkt string
+}
+
+// Handle implements the Handler interface
+func (h *stubTokenHandler) Handle(r *http.Request) (*http.Request, int, error) {
+	if r.Header.Get("Authorization") != "DPoP "+testDPoPToken {
+		return r, 401, noCredentials("Missing access token")
+	}
+	claims := map[string]any{}
+	if h.jkt != "" {
+		claims["cnf"] = map[string]any{"jkt": h.jkt}
+	}
+	p := &principal.Principal{Subject: "user", Claims: claims, Handler: "stub"}
+	return r.WithContext(principal.NewContext(r.Context(), p)), 0, nil
+}
+
+// newTestDPoPKey returns a new EC P-256 private key and the thumbprint of its public key
+func newTestDPoPKey(t *testing.T) (jwk.Key, string) {
+	t.Helper()
+	raw, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, base64.RawURLEncoding.EncodeToString(sum)
+}
+
+// dpopProof holds the headers and claims of a DPoP proof to be signed
+type dpopProof struct {
+	key    jwk.Key
+	alg    jwa.SignatureAlgorithm
+	typ    string
+	jwk    jwk.Key
+	claims map[string]any
+}
+
+// newDPoPProof returns a valid proof of the request to https://api.example.com/resource signed by the key
+func newDPoPProof(key jwk.Key) *dpopProof {
+	public, _ := key.PublicKey()
+	sum := sha256.Sum256([]byte(testDPoPToken))
+	return &dpopProof{
+		key: key,
+		alg: jwa.ES256,
+		typ: "dpop+jwt",
+		jwk: public,
+		claims: map[string]any{
+			"jti": randomString(),
+			"htm": http.MethodGet,
+			"htu": "https://api.example.com/resource",
+			"iat": time.Now().Unix(),
+			"ath": base64.RawURLEncoding.EncodeToString(sum[:]),
+		},
+	}
+}
+
+// sign returns the compact serialization of the proof
+func (p *dpopProof) sign(t *testing.T) string {
+	t.Helper()
+	headers := jws.NewHeaders()
+	headers.Set(jws.TypeKey, p.typ)
+	headers.Set(jws.JWKKey, p.jwk)
+	payload, err := json.Marshal(p.claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := jws.Sign(payload, jws.WithKey(p.alg, p.key, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+// randomString returns a random URL-safe string
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// dpopRequest returns a request to the URL with the DPoP access token and the proofs
+func dpopRequest(target string, proofs ...string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.Header.Set("Authorization", "DPoP "+testDPoPToken)
+	for _, proof := range proofs {
+		r.Header.Add("DPoP", proof)
+	}
+	return r
+}
+
+func TestVerifyDPoP(t *testing.T) {
+	key, thumbprint := newTestDPoPKey(t)
+	otherKey, _ := newTestDPoPKey(t)
+	secret, err := jwk.FromRaw([]byte("a symmetric key used for HS256 proofs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bound := NewVerifyDPoP(VerifyDPoPConfig{
+		Handler:   &stubTokenHandler{jkt: thumbprint},
+		MaxAge:    time.Minute,
+		ClockSkew: 10 * time.Second,
+		BaseURL:   "https://api.example.com/",
+	})
+	optional := NewVerifyDPoP(VerifyDPoPConfig{Handler: &stubTokenHandler{}, BaseURL: "https://api.example.com"})
+	required := NewVerifyDPoP(VerifyDPoPConfig{Handler: &stubTokenHandler{}, Required: true, BaseURL: "https://api.example.com"})
+	fromRequest := NewVerifyDPoP(VerifyDPoPConfig{Handler: &stubTokenHandler{jkt: thumbprint}})
+
+	tests := []struct {
+		name    string
+		handler *VerifyDPoP
+		target  string
+		proof   func(p *dpopProof)
+		noProof bool
+		wantErr error
+	}{
+		{name: "valid proof", handler: bound, target: "http://internal:8080/resource"},
+		{
+			name:    "htu normalized",
+			handler: bound,
+			target:  "http://internal:8080/resource?page=2",
+			proof:   func(p *dpopProof) { p.claims["htu"] = "HTTPS://API.Example.com:443/resource?page=1#top" },
+		},
+		{
+			name:    "htu of another path",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof:   func(p *dpopProof) { p.claims["htu"] = "https://api.example.com/other" },
+			wantErr: ErrInvalidDPoPProof,
+		},
+		{
+			name:    "htu of the internal host",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof:   func(p *dpopProof) { p.claims["htu"] = "http://internal:8080/resource" },
+			wantErr: ErrInvalidDPoPProof,
+		},
+		{
+			name:    "htu taken from the request",
+			handler: fromRequest,
+			target:  "http://internal:8080/resource",
+			proof:   func(p *dpopProof) { p.claims["htu"] = "http://internal:8080/resource" },
+		},
+		{
+			name:    "htm of another method",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof:   func(p *dpopProof) { p.claims["htm"] = http.MethodPost },
+			wantErr: ErrInvalidDPoPProof,
+		},
+		{
+			name:    "missing jti",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof:   func(p *dpopProof) { delete(p.claims, "jti") },
+			wantErr: ErrInvalidDPoPProof,
+		},
+		{
+			name:    "iat within MaxAge and ClockSkew",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof:   func(p *dpopProof) { p.claims["iat"] = time.Now().Add(-65 * time.Second).Unix() },
+		},
+		{
+			name:    "iat older than MaxAge and ClockSkew",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof:   func(p *dpopProof) { p.claims["iat"] = time.Now().Add(-2 * time.Minute).Unix() },
+			wantErr: ErrInvalidDPoPProof,
+		},
+		{
+			name:    "iat in the future within ClockSkew",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof:   func(p *dpopProof) { p.claims["iat"] = time.Now().Add(5 * time.Second).Unix() },
+		},
+		{
+			name:    "iat in the future beyond ClockSkew",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof:   func(p *dpopProof) { p.claims["iat"] = time.Now().Add(time.Minute).Unix() },
+			wantErr: ErrInvalidDPoPProof,
+		},
+		{
+			name:    "missing iat",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof:   func(p *dpopProof) { delete(p.claims, "iat") },
+			wantErr: ErrInvalidDPoPProof,
+		},
+		{
+			name:    "ath of another token",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof: func(p *dpopProof) {
+				sum := sha256.Sum256([]byte("other-token"))
+				p.claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+			},
+			wantErr: ErrInvalidDPoPProof,
+		},
+		{
+			name:    "missing ath",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof:   func(p *dpopProof) { delete(p.claims, "ath") },
+			wantErr: ErrInvalidDPoPProof,
+		},
+		{
+			name:    "typ JWT",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof:   func(p *dpopProof) { p.typ = "JWT" },
+			wantErr: ErrInvalidDPoPProof,
+		},
+		{
+			name:    "private jwk",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof:   func(p *dpopProof) { p.jwk = key },
+			wantErr: ErrInvalidDPoPProof,
+		},
+		{
+			name:    "symmetric alg",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof: func(p *dpopProof) {
+				p.key, p.alg, p.jwk = secret, jwa.HS256, secret
+			},
+			wantErr: ErrInvalidDPoPProof,
+		},
+		{
+			name:    "jwk of another key",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof: func(p *dpopProof) {
+				p.jwk, _ = otherKey.PublicKey()
+			},
+			wantErr: ErrInvalidDPoPProof,
+		},
+		{
+			name:    "signed by a key other than cnf.jkt",
+			handler: bound,
+			target:  "http://internal:8080/resource",
+			proof: func(p *dpopProof) {
+				p.key = otherKey
+				p.jwk, _ = otherKey.PublicKey()
+			},
+			wantErr: ErrDPoPKeyMismatch,
+		},
+		{name: "bound token without proof", handler: bound, target: "http://internal:8080/resource", noProof: true, wantErr: ErrDPoPProofRequired},
+		{name: "unbound token without proof", handler: optional, target: "http://internal:8080/resource", noProof: true},
+		{name: "required proof", handler: required, target: "http://internal:8080/resource", noProof: true, wantErr: ErrDPoPProofRequired},
+		{name: "unbound token with proof", handler: optional, target: "http://internal:8080/resource", wantErr: ErrDPoPKeyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := dpopRequest(tt.target)
+			if !tt.noProof {
+				p := newDPoPProof(key)
+				if tt.proof != nil {
+					tt.proof(p)
+				}
+				r = dpopRequest(tt.target, p.sign(t))
+			}
+
+			r, status, err := tt.handler.Handle(r)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || status != http.StatusUnauthorized {
+					t.Fatalf("Handle() = %d, %v, want 401, %v", status, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Handle() error = %v, want nil", err)
+			}
+			if p, ok := principal.FromContext(r.Context()); !ok || p.Subject != "user" {
+				t.Fatalf("Handle() principal = %+v, want the principal of the token handler", p)
+			}
+		})
+	}
+}
+
+func TestVerifyDPoPChallenge(t *testing.T) {
+	key, thumbprint := newTestDPoPKey(t)
+	m := NewVerifyDPoP(VerifyDPoPConfig{Handler: &stubTokenHandler{jkt: thumbprint}, BaseURL: "https://api.example.com", Realm: "api"})
+
+	p := newDPoPProof(key)
+	p.typ = "JWT"
+	_, _, err := m.Handle(dpopRequest("/resource", p.sign(t)))
+	challenges := ChallengesOf(err)
+	if len(challenges) == 0 || challenges[len(challenges)-1].String() != `DPoP realm="api", error="invalid_dpop_proof", error_description="Invalid DPoP proof"` {
+		t.Fatalf("Handle() challenges = %v, want the invalid_dpop_proof challenge", challenges)
+	}
+	if HeadersOf(err).Get("DPoP-Nonce") != "" {
+		t.Fatal("Handle() sent a DPoP-Nonce without a nonce source")
+	}
+}
+
+func TestVerifyDPoPReplay(t *testing.T) {
+	key, thumbprint := newTestDPoPKey(t)
+	m := NewVerifyDPoP(VerifyDPoPConfig{Handler: &stubTokenHandler{jkt: thumbprint}, BaseURL: "https://api.example.com"})
+
+	proof := newDPoPProof(key).sign(t)
+	if _, _, err := m.Handle(dpopRequest("/resource", proof)); err != nil {
+		t.Fatalf("Handle() error = %v, want nil", err)
+	}
+	if _, _, err := m.Handle(dpopRequest("/resource", proof)); !errors.Is(err, ErrDPoPProofReplayed) {
+		t.Fatalf("Handle() error = %v, want %v", err, ErrDPoPProofReplayed)
+	}
+	if _, _, err := m.Handle(dpopRequest("/resource", proof, newDPoPProof(key).sign(t))); !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Fatalf("Handle() error = %v, want %v with two proofs", err, ErrInvalidDPoPProof)
+	}
+}
+
+func TestVerifyDPoPNonce(t *testing.T) {
+	key, thumbprint := newTestDPoPKey(t)
+	m := NewVerifyDPoP(VerifyDPoPConfig{
+		Handler: &stubTokenHandler{jkt: thumbprint},
+		Nonces:  NewNonceSource(time.Minute),
+		BaseURL: "https://api.example.com",
+	})
+
+	_, status, err := m.Handle(dpopRequest("/resource", newDPoPProof(key).sign(t)))
+	if !errors.Is(err, ErrDPoPNonceRequired) || status != http.StatusUnauthorized {
+		t.Fatalf("Handle() = %d, %v, want 401, %v", status, err, ErrDPoPNonceRequired)
+	}
+	challenges := ChallengesOf(err)
+	if len(challenges) == 0 || challenges[len(challenges)-1].Error != "use_dpop_nonce" {
+		t.Fatalf("Handle() challenges = %v, want use_dpop_nonce", challenges)
+	}
+	nonce := HeadersOf(err).Get("DPoP-Nonce")
+	if nonce == "" {
+		t.Fatal("Handle() did not send the DPoP-Nonce header")
+	}
+
+	p := newDPoPProof(key)
+	p.claims["nonce"] = "invalid"
+	if _, _, err := m.Handle(dpopRequest("/resource", p.sign(t))); !errors.Is(err, ErrDPoPNonceRequired) || HeadersOf(err).Get("DPoP-Nonce") == "" {
+		t.Fatalf("Handle() error = %v, want %v with a new nonce", err, ErrDPoPNonceRequired)
+	}
+
+	p = newDPoPProof(key)
+	p.claims["nonce"] = nonce
+	if _, _, err := m.Handle(dpopRequest("/resource", p.sign(t))); err != nil {
+		t.Fatalf("Handle() error = %v, want nil with the issued nonce", err)
+	}
+}
+
+func TestNewVerifyDPoPRejectsSymmetricAlgorithms(t *testing.T) {
+	for _, algorithms := range [][]string{{"HS256"}, {"ES256", "none"}} {
+		func() {
+			log.SetLogger(log.NewDefaultLogger(log.Panic))
+			defer log.SetLogger(nil)
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewVerifyDPoP() accepted the algorithms %v", algorithms)
+				}
+			}()
+			NewVerifyDPoP(VerifyDPoPConfig{Handler: &stubTokenHandler{}, Algorithms: algorithms})
+		}()
+	}
+}
+
+func TestNormalizeHTU(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "https://api.example.com/resource", want: "https://api.example.com/resource"},
+		{value: "HTTPS://API.EXAMPLE.COM:443/resource?x=1#y", want: "https://api.example.com/resource"},
+		{value: "http://api.example.com:80", want: "http://api.example.com/"},
+		{value: "http://api.example.com:443/", want: "http://api.example.com:443/"},
+		{value: "https://api.example.com:8443/a%2Fb", want: "https://api.example.com:8443/a%2Fb"},
+		{value: "/resource", want: ""},
+		{value: "://", want: ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeHTU(tt.value); got != tt.want {
+			t.Errorf("normalizeHTU(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryReplayCache(t *testing.T) {
+	c := NewMemoryReplayCache()
+	now := time.Now()
+
+	if c.Seen("a", now.Add(time.Minute)) {
+		t.Fatal("Seen() reported a new ID")
+	}
+	if !c.Seen("a", now.Add(time.Minute)) {
+		t.Fatal("Seen() did not report a recorded ID")
+	}
+
+	if c.Seen("b", now.Add(-time.Second)) {
+		t.Fatal("Seen() reported a new ID")
+	}
+	if c.Seen("b", now.Add(time.Minute)) {
+		t.Fatal("Seen() reported an expired ID")
+	}
+	if !c.Seen("b", now.Add(time.Minute)) {
+		t.Fatal("Seen() did not record the ID again after it expired")
+	}
+
+	// the expired entries are removed on the periodic cleanup
+	c.Seen("c", now.Add(-time.Second))
+	c.cleanup = time.Time{}
+	c.Seen("d", now.Add(time.Minute))
+	c.mu.Lock()
+	_, found := c.entries["c"]
+	c.mu.Unlock()
+	if found {
+		t.Fatal("Seen() did not remove the expired entries")
+	}
+}
@@ -0,0 +1,334 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bancodobrasil/goauth/log"
+	"github.com/bancodobrasil/goauth/principal"
+)
+
+var (
+	// ErrInvalidHMACSignature is returned when the HMAC signature is malformed or does not match any of the secrets
+	ErrInvalidHMACSignature = errors.New("Invalid HMAC signature")
+	// ErrHMACTimestampOutOfTolerance is returned when the timestamp of the signed request is too far from the current time
+	ErrHMACTimestampOutOfTolerance = errors.New("HMAC signature timestamp is out of tolerance")
+)
+
+const (
+	// HMACStyleGitHub verifies the X-Hub-Signature-256 header of the GitHub webhooks: sha256=<hex HMAC of the body>
+	HMACStyleGitHub = "github"
+	// HMACStyleStripe verifies the Stripe-Signature header of the Stripe webhooks: t=<timestamp>,v1=<hex HMAC of "<timestamp>.<body>">
+	HMACStyleStripe = "stripe"
+)
+
+// hmacHashes are the hash functions of the HMAC signatures, by name
+var hmacHashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// VerifyHMACConfig stores the configuration for the VerifyHMAC handler
+type VerifyHMACConfig struct {
+	// Style presets the other settings for the webhooks of a provider: github or stripe.
+	// The settings that are set override the preset
+	Style string
+	// Secrets are the shared secrets. A signature made with any of them is accepted, so they can be rotated.
+	// Secrets with the base64: prefix are base64 decoded, and the raw: prefix of the raw secrets is optional
+	Secrets []string
+	// Hash is the hash function of the HMAC: sha1, sha256 or sha512. Defaults to sha256
+	Hash string
+	// Header is the header with the signature
+	Header string
+	// Prefix is the prefix of the signature on the Header, e.g. sha256=
+	Prefix string
+	// Encoding is the encoding of the signature: hex or base64. Defaults to hex
+	Encoding string
+	// TimestampHeader is the header with the Unix timestamp of the request, if any. The Payload must
+	// include the {timestamp}, so the timestamp is signed
+	TimestampHeader string
+	// Payload is the template of the signed string, where {timestamp} is replaced by the timestamp
+	// and {body} by the request body, e.g. {timestamp}.{body}. Defaults to {body}
+	Payload string
+	// Tolerance is the maximum difference between the timestamp and the current time. Defaults to 5 minutes
+	Tolerance time.Duration
+	// MaxBodySize is the maximum size of the body buffered to verify the signature. Defaults to 10 MiB
+	MaxBodySize int64
+	// Subject is the subject of the principal, e.g. the name of the integration
+	Subject string
+}
+
+// payloadPart is a literal or a placeholder of the payload template
+type payloadPart struct {
+	literal     string
+	placeholder string
+}
+
+// VerifyHMAC authenticates the requests signed with an HMAC of their body, in the manner of the webhooks
+type VerifyHMAC struct {
+	style           string
+	secrets         [][]byte
+	hash            func() hash.Hash
+	header          string
+	prefix          string
+	encoding        string
+	timestampHeader string
+	payload         []payloadPart
+	timestamped     bool
+	tolerance       time.Duration
+	maxBody         int64
+	subject         string
+}
+
+// NewVerifyHMAC returns a new VerifyHMAC instance
+func NewVerifyHMAC(cfg VerifyHMACConfig) *VerifyHMAC {
+	log.Log(log.Debug, "VerifyHMAC: NewVerifyHMAC")
+	preset := VerifyHMACConfig{Hash: "sha256", Encoding: "hex", Payload: "{body}"}
+	switch cfg.Style {
+	case "":
+	case HMACStyleGitHub:
+		preset.Header = "X-Hub-Signature-256"
+		preset.Prefix = "sha256="
+	case HMACStyleStripe:
+		preset.Header = "Stripe-Signature"
+		preset.Payload = "{timestamp}.{body}"
+	default:
+		log.Logf(log.Panic, "Unsupported HMAC style: %s", cfg.Style)
+		return nil
+	}
+
+	m := &VerifyHMAC{
+		style:           cfg.Style,
+		header:          firstNonEmpty(cfg.Header, preset.Header),
+		prefix:          firstNonEmpty(cfg.Prefix, preset.Prefix),
+		encoding:        firstNonEmpty(cfg.Encoding, preset.Encoding),
+		timestampHeader: cfg.TimestampHeader,
+		tolerance:       cfg.Tolerance,
+		maxBody:         cfg.MaxBodySize,
+		subject:         cfg.Subject,
+	}
+	if m.header == "" {
+		log.Log(log.Panic, "The HMAC handler requires a signature header")
+		return nil
+	}
+	if m.encoding != "hex" && m.encoding != "base64" {
+		log.Logf(log.Panic, "Unsupported HMAC signature encoding: %s", m.encoding)
+		return nil
+	}
+	var ok bool
+	if m.hash, ok = hmacHashes[firstNonEmpty(cfg.Hash, preset.Hash)]; !ok {
+		log.Logf(log.Panic, "Unsupported HMAC hash: %s", cfg.Hash)
+		return nil
+	}
+
+	payload, err := parsePayload(firstNonEmpty(cfg.Payload, preset.Payload))
+	if err != nil {
+		log.Log(log.Panic, err)
+		return nil
+	}
+	m.payload = payload
+	for _, part := range payload {
+		if part.placeholder == "timestamp" {
+			m.timestamped = true
+		}
+	}
+	if m.timestamped && cfg.Style != HMACStyleStripe && cfg.TimestampHeader == "" {
+		log.Log(log.Panic, "The {timestamp} of the HMAC payload requires a timestamp header")
+		return nil
+	}
+	if cfg.TimestampHeader != "" && !m.timestamped {
+		// an unsigned timestamp could be replaced on a replayed request, so it would not protect from replays
+		log.Log(log.Panic, "The HMAC timestamp header requires the {timestamp} placeholder on the payload")
+		return nil
+	}
+	if m.tolerance == 0 {
+		m.tolerance = 5 * time.Minute
+	}
+	if m.maxBody == 0 {
+		m.maxBody = defaultMaxBodySize
+	}
+
+	for i, secret := range cfg.Secrets {
+		if strings.HasPrefix(secret, base64KeyPrefix) {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, base64KeyPrefix))
+			if err != nil {
+				log.Logf(log.Panic, "Invalid base64 HMAC secret #%d: %s", i, err)
+				return nil
+			}
+			m.secrets = append(m.secrets, decoded)
+		} else if secret = strings.TrimPrefix(secret, rawKeyPrefix); secret != "" {
+			m.secrets = append(m.secrets, []byte(secret))
+		}
+	}
+	if len(m.secrets) == 0 {
+		log.Log(log.Panic, "The HMAC handler requires at least one secret")
+		return nil
+	}
+
+	return m
+}
+
+// parsePayload splits the payload template into its literals and placeholders
+func parsePayload(template string) ([]payloadPart, error) {
+	parts := []payloadPart{}
+	for template != "" {
+		start := strings.Index(template, "{")
+		if start < 0 {
+			parts = append(parts, payloadPart{literal: template})
+			break
+		}
+		end := strings.Index(template[start:], "}")
+		if end < 0 {
+			return nil, errors.New("Invalid HMAC payload: unterminated placeholder")
+		}
+		name := template[start+1 : start+end]
+		if name != "body" && name != "timestamp" {
+			return nil, errors.New("Invalid HMAC payload: unknown placeholder {" + name + "}")
+		}
+		if start > 0 {
+			parts = append(parts, payloadPart{literal: template[:start]})
+		}
+		parts = append(parts, payloadPart{placeholder: name})
+		template = template[start+end+1:]
+	}
+	return parts, nil
+}
+
+// Handle runs the VerifyHMAC authentication handler
+func (m *VerifyHMAC) Handle(r *http.Request) (*http.Request, int, error) {
+	log.Log(log.Debug, "VerifyHMAC: Handle")
+	value := r.Header.Get(m.header)
+	if value == "" {
+		return r, 401, noCredentials("Missing %s Header", m.header)
+	}
+
+	timestamp, signatures, err := m.parseHeaders(r, value)
+	if err != nil {
+		log.Logf(log.Error, "%s: %s", ErrInvalidHMACSignature, err)
+		return r, 401, ErrInvalidHMACSignature
+	}
+
+	var signedAt time.Time
+	if m.timestamped {
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			log.Logf(log.Error, "%s: invalid timestamp %q", ErrInvalidHMACSignature, timestamp)
+			return r, 401, ErrInvalidHMACSignature
+		}
+		signedAt = time.Unix(seconds, 0)
+		if age := time.Since(signedAt); age > m.tolerance || age < -m.tolerance {
+			return r, 401, ErrHMACTimestampOutOfTolerance
+		}
+	}
+
+	body, err := readBody(r, m.maxBody)
+	if errors.Is(err, ErrBodyTooLarge) {
+		return r, http.StatusRequestEntityTooLarge, err
+	}
+	if err != nil {
+		log.Log(log.Error, err)
+		return r, 400, ErrInvalidHMACSignature
+	}
+
+	if !m.verify(timestamp, body, signatures) {
+		return r, 401, ErrInvalidHMACSignature
+	}
+
+	p := &principal.Principal{
+		Subject:  m.subject,
+		Handler:  "hmac",
+		IssuedAt: signedAt,
+	}
+	return r.WithContext(principal.NewContext(r.Context(), p)), 0, nil
+}
+
+// parseHeaders returns the timestamp and the decoded signatures of the request
+func (m *VerifyHMAC) parseHeaders(r *http.Request, value string) (string, [][]byte, error) {
+	var timestamp string
+	var encoded []string
+	if m.style == HMACStyleStripe {
+		for _, pair := range strings.Split(value, ",") {
+			key, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			switch key {
+			case "t":
+				timestamp = v
+			case "v1":
+				encoded = append(encoded, v)
+			}
+		}
+	} else {
+		if !strings.HasPrefix(value, m.prefix) {
+			return "", nil, errors.New("missing signature prefix " + m.prefix)
+		}
+		encoded = append(encoded, strings.TrimPrefix(value, m.prefix))
+	}
+	if m.timestampHeader != "" {
+		timestamp = r.Header.Get(m.timestampHeader)
+	}
+
+	signatures := make([][]byte, 0, len(encoded))
+	for _, s := range encoded {
+		var signature []byte
+		var err error
+		if m.encoding == "base64" {
+			signature, err = base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		} else {
+			signature, err = hex.DecodeString(strings.TrimSpace(s))
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		signatures = append(signatures, signature)
+	}
+	if len(signatures) == 0 {
+		return "", nil, errors.New("missing signature")
+	}
+	return timestamp, signatures, nil
+}
+
+// verify reports whether any of the signatures is the HMAC of the payload with any of the secrets.
+// All of them are compared, so the time taken does not depend on which one matches
+func (m *VerifyHMAC) verify(timestamp string, body []byte, signatures [][]byte) bool {
+	valid := false
+	for _, secret := range m.secrets {
+		mac := hmac.New(m.hash, secret)
+		for _, part := range m.payload {
+			switch part.placeholder {
+			case "body":
+				mac.Write(body)
+			case "timestamp":
+				mac.Write([]byte(timestamp))
+			default:
+				mac.Write([]byte(part.literal))
+			}
+		}
+		sum := mac.Sum(nil)
+		for _, signature := range signatures {
+			if hmac.Equal(sum, signature) {
+				valid = true
+			}
+		}
+	}
+	return valid
+}
+
+// firstNonEmpty returns the first of the values that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
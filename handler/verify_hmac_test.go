@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bancodobrasil/goauth/log"
+	"github.com/bancodobrasil/goauth/principal"
+)
+
+// hexHMAC returns the hex HMAC-SHA256 of the payload
+func hexHMAC(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	const body = `{"action":"opened"}`
+	now := time.Now().Unix()
+	old := now - 600
+	github := NewVerifyHMAC(VerifyHMACConfig{Style: HMACStyleGitHub, Secrets: []string{"old-secret", "raw:new-secret"}, Subject: "github"})
+	stripe := NewVerifyHMAC(VerifyHMACConfig{Style: HMACStyleStripe, Secrets: []string{"whsec"}, Subject: "stripe"})
+	custom := NewVerifyHMAC(VerifyHMACConfig{
+		Header:          "X-Signature",
+		Prefix:          "v0=",
+		TimestampHeader: "X-Timestamp",
+		Payload:         "v0:{timestamp}:{body}",
+		Secrets:         []string{"base64:c2VjcmV0"},
+	})
+
+	tests := []struct {
+		name       string
+		handler    *VerifyHMAC
+		headers    map[string]string
+		body       string
+		wantStatus int
+		wantErr    error
+	}{
+		{
+			name:    "github signature",
+			handler: github,
+			headers: map[string]string{"X-Hub-Signature-256": "sha256=" + hexHMAC("new-secret", body)},
+		},
+		{
+			name:    "github signature with the rotated secret",
+			handler: github,
+			headers: map[string]string{"X-Hub-Signature-256": "sha256=" + hexHMAC("old-secret", body)},
+		},
+		{
+			name:       "github signature with an unknown secret",
+			handler:    github,
+			headers:    map[string]string{"X-Hub-Signature-256": "sha256=" + hexHMAC("other", body)},
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrInvalidHMACSignature,
+		},
+		{
+			name:       "github signature of another body",
+			handler:    github,
+			headers:    map[string]string{"X-Hub-Signature-256": "sha256=" + hexHMAC("new-secret", body)},
+			body:       `{"action":"closed"}`,
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrInvalidHMACSignature,
+		},
+		{
+			name:       "github signature without prefix",
+			handler:    github,
+			headers:    map[string]string{"X-Hub-Signature-256": hexHMAC("new-secret", body)},
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrInvalidHMACSignature,
+		},
+		{
+			name:       "missing signature",
+			handler:    github,
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrNoCredentials,
+		},
+		{
+			name:    "stripe signature",
+			handler: stripe,
+			headers: map[string]string{"Stripe-Signature": fmt.Sprintf("t=%d,v1=%s,v0=ignored", now, hexHMAC("whsec", fmt.Sprintf("%d.%s", now, body)))},
+		},
+		{
+			name:    "stripe signature among the signatures of a rotation",
+			handler: stripe,
+			headers: map[string]string{"Stripe-Signature": fmt.Sprintf("t=%d,v1=%s,v1=%s", now, hexHMAC("other", "x"), hexHMAC("whsec", fmt.Sprintf("%d.%s", now, body)))},
+		},
+		{
+			name:       "stripe signature with a replaced timestamp",
+			handler:    stripe,
+			headers:    map[string]string{"Stripe-Signature": fmt.Sprintf("t=%d,v1=%s", now, hexHMAC("whsec", fmt.Sprintf("%d.%s", now-1, body)))},
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrInvalidHMACSignature,
+		},
+		{
+			name:       "stripe timestamp out of tolerance",
+			handler:    stripe,
+			headers:    map[string]string{"Stripe-Signature": fmt.Sprintf("t=%d,v1=%s", old, hexHMAC("whsec", fmt.Sprintf("%d.%s", old, body)))},
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrHMACTimestampOutOfTolerance,
+		},
+		{
+			name:       "stripe signature without timestamp",
+			handler:    stripe,
+			headers:    map[string]string{"Stripe-Signature": "v1=" + hexHMAC("whsec", "."+body)},
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrInvalidHMACSignature,
+		},
+		{
+			name:    "timestamp header",
+			handler: custom,
+			headers: map[string]string{
+				"X-Timestamp": fmt.Sprint(now),
+				"X-Signature": "v0=" + hexHMAC("secret", fmt.Sprintf("v0:%d:%s", now, body)),
+			},
+		},
+		{
+			name:    "timestamp header out of tolerance",
+			handler: custom,
+			headers: map[string]string{
+				"X-Timestamp": fmt.Sprint(old),
+				"X-Signature": "v0=" + hexHMAC("secret", fmt.Sprintf("v0:%d:%s", old, body)),
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrHMACTimestampOutOfTolerance,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requestBody := body
+			if tt.body != "" {
+				requestBody = tt.body
+			}
+			r := httptest.NewRequest(http.MethodPost, "/hooks", strings.NewReader(requestBody))
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+
+			r, status, err := tt.handler.Handle(r)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || status != tt.wantStatus {
+					t.Fatalf("Handle() = %d, %v, want %d, %v", status, err, tt.wantStatus, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Handle() error = %v, want nil", err)
+			}
+			if p, ok := principal.FromContext(r.Context()); !ok || p.Handler != "hmac" {
+				t.Fatalf("Handle() principal = %+v, want the hmac handler", p)
+			}
+			if restored, _ := io.ReadAll(r.Body); string(restored) != requestBody {
+				t.Fatalf("body = %q, want %q restored for the next handlers", restored, requestBody)
+			}
+		})
+	}
+}
+
+func TestVerifyHMACBodyTooLarge(t *testing.T) {
+	h := NewVerifyHMAC(VerifyHMACConfig{Style: HMACStyleGitHub, Secrets: []string{"secret"}, MaxBodySize: 4})
+	r := httptest.NewRequest(http.MethodPost, "/hooks", strings.NewReader("too large"))
+	r.Header.Set("X-Hub-Signature-256", "sha256="+hexHMAC("secret", "too large"))
+
+	_, status, err := h.Handle(r)
+	if !errors.Is(err, ErrBodyTooLarge) || status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Handle() = %d, %v, want 413, %v", status, err, ErrBodyTooLarge)
+	}
+}
+
+func TestNewVerifyHMACRejectsUnsignedTimestamp(t *testing.T) {
+	log.SetLogger(log.NewDefaultLogger(log.Panic))
+	defer log.SetLogger(nil)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewVerifyHMAC() accepted a timestamp header without {timestamp} on the payload")
+		}
+	}()
+
+	NewVerifyHMAC(VerifyHMACConfig{Header: "X-Signature", TimestampHeader: "X-Timestamp", Secrets: []string{"secret"}})
+}
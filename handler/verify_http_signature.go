@@ -0,0 +1,344 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bancodobrasil/goauth/log"
+	"github.com/bancodobrasil/goauth/pkg/httpsig"
+	"github.com/bancodobrasil/goauth/pkg/jwks"
+	"github.com/bancodobrasil/goauth/principal"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+var (
+	// ErrInvalidHTTPSignature is returned when the HTTP message signature is malformed or does not match the request
+	ErrInvalidHTTPSignature = errors.New("Invalid HTTP signature")
+	// ErrHTTPSignatureExpired is returned when the HTTP message signature is expired or was created too long ago
+	ErrHTTPSignatureExpired = errors.New("HTTP signature is expired")
+	// ErrMissingSignedComponent is returned when the HTTP message signature does not cover a required component
+	ErrMissingSignedComponent = errors.New("HTTP signature does not cover the required components")
+	// ErrContentDigestMismatch is returned when the Content-Digest header does not match the request body
+	ErrContentDigestMismatch = errors.New("Content digest does not match the body")
+)
+
+// defaultHTTPSignatureAlgorithms are the algorithms allowed when VerifyHTTPSignatureConfig.Algorithms is empty
+var defaultHTTPSignatureAlgorithms = []string{
+	"rsa-pss-sha512", "rsa-v1_5-sha256", "ecdsa-p256-sha256", "ecdsa-p384-sha384", "ed25519",
+}
+
+// VerifyHTTPSignatureConfig stores the configuration for the VerifyHTTPSignature handler
+type VerifyHTTPSignatureConfig struct {
+	// CacheConfig is the configuration of the cache of the JWKS on URL
+	CacheConfig
+	// Fetcher looks up the keys by the keyid parameter of the signatures
+	Fetcher jwks.KeyFetcher
+	// URL is the JWKS endpoint with the keys, used when Fetcher is nil
+	URL string
+	// Keys is the key material of the keys, used when Fetcher and URL are not set: JWKs or JWK Sets with their kid,
+	// in the same formats as VerifyJWTConfig.SignatureKey
+	Keys []string
+	// KeyFiles are the paths of files with keys, in the same formats as Keys
+	KeyFiles []string
+	// Algorithms is the allow-list of the signature algorithms, by their HTTP Message Signature names
+	// (e.g. ecdsa-p256-sha256). Defaults to all the asymmetric algorithms. hmac-sha256 is only allowed
+	// with the static Keys and KeyFiles
+	Algorithms []string
+	// RequiredComponents are the components the signature must cover. Defaults to @method, @authority and @path
+	RequiredComponents []string
+	// Label is the label of the signature to verify. If empty, the first signature is verified
+	Label string
+	// Tag is the required tag parameter of the signature, if any
+	Tag string
+	// MaxAge is the maximum time since the created parameter of the signature. Defaults to 5 minutes
+	MaxAge time.Duration
+	// ClockSkew is the allowed clock skew when validating the created and expires parameters
+	ClockSkew time.Duration
+	// MaxBodySize is the maximum size of the body buffered to verify the content-digest. Defaults to 10 MiB
+	MaxBodySize int64
+	// AllowUnsignedBody accepts the requests with a body whose signature does not cover content-digest.
+	// Otherwise, the body would not be authenticated, so they are rejected with ErrMissingSignedComponent
+	AllowUnsignedBody bool
+}
+
+// VerifyHTTPSignature authenticates the requests signed with HTTP Message Signatures (RFC 9421)
+type VerifyHTTPSignature struct {
+	fetcher      jwks.KeyFetcher
+	keyHandler   *jwks.KeyHandler
+	required     []string
+	label        string
+	tag          string
+	maxAge       time.Duration
+	clockSkew    time.Duration
+	maxBody      int64
+	unsignedBody bool
+}
+
+// NewVerifyHTTPSignature returns a new VerifyHTTPSignature instance
+func NewVerifyHTTPSignature(cfg VerifyHTTPSignatureConfig) *VerifyHTTPSignature {
+	log.Log(log.Debug, "VerifyHTTPSignature: NewVerifyHTTPSignature")
+	fetcher := cfg.Fetcher
+	static := false
+	switch {
+	case fetcher != nil:
+	case cfg.URL != "":
+		source, err := newKeySource("", cfg.URL, false, cfg.CacheConfig)
+		if err != nil {
+			log.Log(log.Panic, err)
+			return nil
+		}
+		fetcher = source.getSignatureKey
+	default:
+		keys, err := loadKeys(cfg.Keys, cfg.KeyFiles, keyOptions{})
+		if err != nil {
+			log.Log(log.Panic, err)
+			return nil
+		}
+		if keys.Len() == 0 {
+			log.Log(log.Panic, "The HTTP signature handler requires a key fetcher, a JWKS URL or keys")
+			return nil
+		}
+		fetcher = staticKeyFetcher(keys)
+		static = true
+	}
+
+	names := cfg.Algorithms
+	if len(names) == 0 {
+		names = defaultHTTPSignatureAlgorithms
+	}
+	algorithms := make([]jwa.SignatureAlgorithm, 0, len(names))
+	symmetric := false
+	for _, name := range names {
+		alg, ok := httpsig.Algorithms[name]
+		if !ok {
+			log.Logf(log.Panic, "Unsupported HTTP signature algorithm: %s", name)
+			return nil
+		}
+		if jwks.IsSymmetric(alg) {
+			if !static {
+				log.Logf(log.Panic, "The %s algorithm requires static keys", name)
+				return nil
+			}
+			symmetric = true
+		}
+		algorithms = append(algorithms, alg)
+	}
+
+	required := cfg.RequiredComponents
+	if len(required) == 0 {
+		required = []string{"@method", "@authority", "@path"}
+	}
+	maxAge := cfg.MaxAge
+	if maxAge == 0 {
+		maxAge = 5 * time.Minute
+	}
+	maxBody := cfg.MaxBodySize
+	if maxBody == 0 {
+		maxBody = defaultMaxBodySize
+	}
+
+	return &VerifyHTTPSignature{
+		fetcher: fetcher,
+		keyHandler: &jwks.KeyHandler{
+			Algorithms:     algorithms,
+			AllowSymmetric: symmetric,
+		},
+		required:     required,
+		label:        cfg.Label,
+		tag:          cfg.Tag,
+		maxAge:       maxAge,
+		clockSkew:    cfg.ClockSkew,
+		maxBody:      maxBody,
+		unsignedBody: cfg.AllowUnsignedBody,
+	}
+}
+
+// Handle runs the VerifyHTTPSignature authentication handler
+func (m *VerifyHTTPSignature) Handle(r *http.Request) (*http.Request, int, error) {
+	log.Log(log.Debug, "VerifyHTTPSignature: Handle")
+	if r.Header.Get("Signature-Input") == "" {
+		return r, 401, noCredentials("Missing Signature-Input Header")
+	}
+
+	signature, err := m.selectSignature(r)
+	if err != nil {
+		log.Logf(log.Error, "%s: %s", ErrInvalidHTTPSignature, err)
+		return r, 401, ErrInvalidHTTPSignature
+	}
+	if err := m.checkParams(signature); err != nil {
+		return r, 401, err
+	}
+	if !m.unsignedBody && r.ContentLength != 0 && !signature.Covers("content-digest") {
+		log.Logf(log.Error, "%s: the request has a body and content-digest is not covered", ErrMissingSignedComponent)
+		return r, 401, ErrMissingSignedComponent
+	}
+
+	key, alg, err := m.key(r.Context(), signature)
+	if err != nil {
+		log.Logf(log.Error, "%s: %s", ErrInvalidHTTPSignature, err)
+		return r, 401, signatureKeyError(err)
+	}
+
+	base, err := signature.Base(r)
+	if err != nil {
+		log.Logf(log.Error, "%s: %s", ErrInvalidHTTPSignature, err)
+		return r, 401, ErrInvalidHTTPSignature
+	}
+	verifier, err := jws.NewVerifier(alg)
+	if err != nil {
+		log.Log(log.Error, err)
+		return r, 401, ErrUnsupportedAlgorithm
+	}
+	var raw any
+	if err := key.Raw(&raw); err != nil {
+		log.Log(log.Error, err)
+		return r, 401, ErrKeyMismatch
+	}
+	if err := verifier.Verify(base, signature.Value, raw); err != nil {
+		log.Logf(log.Error, "%s: %s", ErrInvalidHTTPSignature, err)
+		return r, 401, ErrInvalidHTTPSignature
+	}
+
+	if signature.Covers("content-digest") {
+		body, err := readBody(r, m.maxBody)
+		if errors.Is(err, ErrBodyTooLarge) {
+			return r, http.StatusRequestEntityTooLarge, err
+		}
+		if err != nil {
+			log.Log(log.Error, err)
+			return r, 400, ErrContentDigestMismatch
+		}
+		if err := httpsig.VerifyContentDigest(r.Header.Get("Content-Digest"), body); err != nil {
+			log.Logf(log.Error, "%s: %s", ErrContentDigestMismatch, err)
+			return r, 401, ErrContentDigestMismatch
+		}
+	}
+
+	return r.WithContext(principal.NewContext(r.Context(), newSignaturePrincipal(signature, alg))), 0, nil
+}
+
+// selectSignature returns the signature with the label or, if no label is configured, the first one
+func (m *VerifyHTTPSignature) selectSignature(r *http.Request) (*httpsig.Signature, error) {
+	signatures, err := httpsig.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, signature := range signatures {
+		if m.label == "" || signature.Label == m.label {
+			return signature, nil
+		}
+	}
+	return nil, fmt.Errorf("missing signature %s", m.label)
+}
+
+// checkParams verifies the covered components, the tag and the created and expires parameters of the signature
+func (m *VerifyHTTPSignature) checkParams(signature *httpsig.Signature) error {
+	for _, component := range m.required {
+		if !signature.Covers(component) {
+			log.Logf(log.Error, "%s: %s", ErrMissingSignedComponent, component)
+			return ErrMissingSignedComponent
+		}
+	}
+	if m.tag != "" && signature.Tag != m.tag {
+		log.Logf(log.Error, "%s: tag %s", ErrInvalidHTTPSignature, signature.Tag)
+		return ErrInvalidHTTPSignature
+	}
+
+	now := time.Now()
+	if signature.Created.IsZero() || signature.Created.After(now.Add(m.clockSkew)) {
+		log.Logf(log.Error, "%s: created %s", ErrInvalidHTTPSignature, signature.Created)
+		return ErrInvalidHTTPSignature
+	}
+	if now.Sub(signature.Created) > m.maxAge+m.clockSkew {
+		return ErrHTTPSignatureExpired
+	}
+	if !signature.Expires.IsZero() && now.After(signature.Expires.Add(m.clockSkew)) {
+		return ErrHTTPSignatureExpired
+	}
+	return nil
+}
+
+// key fetches the key of the signature and returns it with its algorithm, which is taken from the alg
+// parameter of the signature or else from the key
+func (m *VerifyHTTPSignature) key(ctx context.Context, signature *httpsig.Signature) (jwk.Key, jwa.SignatureAlgorithm, error) {
+	if signature.KeyID == "" {
+		return nil, "", fmt.Errorf("%w: missing keyid", ErrUnknownKeyID)
+	}
+	key, err := m.fetcher(ctx, signature.KeyID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var alg jwa.SignatureAlgorithm
+	if signature.Alg != "" {
+		var ok bool
+		if alg, ok = httpsig.Algorithms[signature.Alg]; !ok {
+			return nil, "", fmt.Errorf("%w: %s", jwks.ErrAlgorithmNotAllowed, signature.Alg)
+		}
+	} else if keyAlg := key.Algorithm(); keyAlg != nil && keyAlg.String() != "" {
+		alg = jwa.SignatureAlgorithm(keyAlg.String())
+	} else {
+		return nil, "", fmt.Errorf("%w: the signature and the key have no alg", jwks.ErrAlgorithmNotAllowed)
+	}
+
+	if err := m.keyHandler.Check(key, alg); err != nil {
+		return nil, "", err
+	}
+	return key, alg, nil
+}
+
+// signatureKeyError maps the errors of the key lookup to the errors of this package
+func signatureKeyError(err error) error {
+	switch {
+	case errors.Is(err, ErrUnknownKeyID), errors.Is(err, ErrKeySetUnavailable),
+		errors.Is(err, jwks.ErrAlgorithmNotAllowed), errors.Is(err, jwks.ErrKeyMismatch):
+		return tokenError(err)
+	default:
+		return ErrInvalidHTTPSignature
+	}
+}
+
+// staticKeyFetcher returns a KeyFetcher that looks up the keys on the set
+func staticKeyFetcher(keys jwk.Set) jwks.KeyFetcher {
+	return func(_ context.Context, keyID string) (jwk.Key, error) {
+		key, ok := keys.LookupKeyID(keyID)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, keyID)
+		}
+		return key, nil
+	}
+}
+
+// newSignaturePrincipal builds the principal of a request authenticated by an HTTP message signature
+func newSignaturePrincipal(signature *httpsig.Signature, alg jwa.SignatureAlgorithm) *principal.Principal {
+	components := make([]string, 0, len(signature.Components))
+	for _, c := range signature.Components {
+		components = append(components, c.String())
+	}
+	algName := signature.Alg
+	if algName == "" {
+		algName = alg.String()
+	}
+	claims := map[string]any{
+		"keyid":      signature.KeyID,
+		"label":      signature.Label,
+		"alg":        algName,
+		"components": components,
+		"created":    signature.Created.Unix(),
+	}
+	if signature.Tag != "" {
+		claims["tag"] = signature.Tag
+	}
+	return &principal.Principal{
+		Subject:   signature.KeyID,
+		Claims:    claims,
+		Handler:   "http_signature",
+		IssuedAt:  signature.Created,
+		ExpiresAt: signature.Expires,
+	}
+}
@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bancodobrasil/goauth/pkg/httpsig"
+	"github.com/bancodobrasil/goauth/principal"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// signedRequest returns a POST request with the body and its Content-Digest, signed by the key with the signature input.
+// tamper is called after the request is signed
+func signedRequest(t *testing.T, key *ecdsa.PrivateKey, body, input string, tamper func(r *http.Request)) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "https://api.example.com/hooks?id=1", strings.NewReader(body))
+	sum := sha256.Sum256([]byte(body))
+	r.Header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+	r.Header.Set("Signature-Input", input)
+	r.Header.Set("Signature", "sig1=:AA==:")
+
+	signatures, err := httpsig.Parse(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := signatures[0].Base(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jws.NewSigner(jwa.ES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := signer.Sign(base, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(signature)+":")
+
+	if tamper != nil {
+		tamper(r)
+	}
+	return r
+}
+
+func TestVerifyHTTPSignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := jwk.FromRaw(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	public.Set(jwk.KeyIDKey, "partner-1")
+	set := jwk.NewSet()
+	set.AddKey(public)
+	keys, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewVerifyHTTPSignature(VerifyHTTPSignatureConfig{Keys: []string{string(keys)}})
+
+	now := time.Now().Unix()
+	input := func(components string, params string) string {
+		return fmt.Sprintf(`sig1=(%s);keyid="partner-1";alg="ecdsa-p256-sha256"%s`, components, params)
+	}
+	const covered = `"@method" "@authority" "@path" "content-digest"`
+	const body = `{"event":"paid"}`
+
+	tests := []struct {
+		name       string
+		input      string
+		tamper     func(r *http.Request)
+		wantStatus int
+		wantErr    error
+	}{
+		{name: "valid signature", input: input(covered, fmt.Sprintf(";created=%d", now))},
+		{name: "valid signature with expires", input: input(covered, fmt.Sprintf(";created=%d;expires=%d", now, now+60))},
+		{
+			name:       "tampered method",
+			input:      input(covered, fmt.Sprintf(";created=%d", now)),
+			tamper:     func(r *http.Request) { r.Method = http.MethodPut },
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrInvalidHTTPSignature,
+		},
+		{
+			name:       "tampered body",
+			input:      input(covered, fmt.Sprintf(";created=%d", now)),
+			tamper:     func(r *http.Request) { r.Body = io.NopCloser(strings.NewReader(`{"event":"refunded"}`)) },
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrContentDigestMismatch,
+		},
+		{
+			name:       "created too long ago",
+			input:      input(covered, fmt.Sprintf(";created=%d", now-600)),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrHTTPSignatureExpired,
+		},
+		{
+			name:       "created in the future",
+			input:      input(covered, fmt.Sprintf(";created=%d", now+600)),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrInvalidHTTPSignature,
+		},
+		{
+			name:       "missing created",
+			input:      input(covered, ""),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrInvalidHTTPSignature,
+		},
+		{
+			name:       "expired",
+			input:      input(covered, fmt.Sprintf(";created=%d;expires=%d", now-10, now-5)),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrHTTPSignatureExpired,
+		},
+		{
+			name:       "missing required component",
+			input:      input(`"@method" "@path"`, fmt.Sprintf(";created=%d", now)),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrMissingSignedComponent,
+		},
+		{
+			name:       "body not covered",
+			input:      input(`"@method" "@authority" "@path"`, fmt.Sprintf(";created=%d", now)),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrMissingSignedComponent,
+		},
+		{
+			name:       "unknown keyid",
+			input:      fmt.Sprintf(`sig1=(%s);created=%d;keyid="partner-2"`, covered, now),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrUnknownKeyID,
+		},
+		{
+			name:       "symmetric alg",
+			input:      fmt.Sprintf(`sig1=(%s);created=%d;keyid="partner-1";alg="hmac-sha256"`, covered, now),
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrUnsupportedAlgorithm,
+		},
+		{
+			name:       "missing Signature-Input",
+			input:      input(covered, fmt.Sprintf(";created=%d", now)),
+			tamper:     func(r *http.Request) { r.Header.Del("Signature-Input") },
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    ErrNoCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, status, err := h.Handle(signedRequest(t, key, body, tt.input, tt.tamper))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || status != tt.wantStatus {
+					t.Fatalf("Handle() = %d, %v, want %d, %v", status, err, tt.wantStatus, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Handle() error = %v, want nil", err)
+			}
+			p, ok := principal.FromContext(r.Context())
+			if !ok || p.Subject != "partner-1" || p.Handler != "http_signature" {
+				t.Fatalf("Handle() principal = %+v, want the subject partner-1", p)
+			}
+			if restored, _ := io.ReadAll(r.Body); string(restored) != body {
+				t.Fatalf("body = %q, want %q restored for the next handlers", restored, body)
+			}
+		})
+	}
+
+	t.Run("unsigned body allowed", func(t *testing.T) {
+		unsigned := NewVerifyHTTPSignature(VerifyHTTPSignatureConfig{Keys: []string{string(keys)}, AllowUnsignedBody: true})
+		r := signedRequest(t, key, body, input(`"@method" "@authority" "@path"`, fmt.Sprintf(";created=%d", now)), nil)
+
+		if _, _, err := unsigned.Handle(r); err != nil {
+			t.Fatalf("Handle() error = %v, want nil", err)
+		}
+	})
+
+	t.Run("without body", func(t *testing.T) {
+		r := signedRequest(t, key, "", input(`"@method" "@authority" "@path"`, fmt.Sprintf(";created=%d", now)), nil)
+
+		if _, _, err := h.Handle(r); err != nil {
+			t.Fatalf("Handle() error = %v, want nil", err)
+		}
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		small := NewVerifyHTTPSignature(VerifyHTTPSignatureConfig{Keys: []string{string(keys)}, MaxBodySize: 4})
+		r := signedRequest(t, key, body, input(covered, fmt.Sprintf(";created=%d", now)), nil)
+
+		_, status, err := small.Handle(r)
+		if !errors.Is(err, ErrBodyTooLarge) || status != http.StatusRequestEntityTooLarge {
+			t.Fatalf("Handle() = %d, %v, want 413, %v", status, err, ErrBodyTooLarge)
+		}
+		if restored, _ := io.ReadAll(r.Body); string(restored) != body {
+			t.Fatalf("body = %q, want %q restored for the next handlers", restored, body)
+		}
+	})
+}
+
+func TestVerifyHTTPSignatureRFC9421(t *testing.T) {
+	// test-key-ed25519 of RFC 9421, appendix B.1.4
+	public, err := jwk.ParseKey([]byte(`-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VwAyEAJrQLj5P/89iXES9+vFgrIy29clF9CC/oPPsw3c5D0bs=
+-----END PUBLIC KEY-----`), jwk.WithPEM(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	public.Set(jwk.KeyIDKey, "test-key-ed25519")
+	public.Set(jwk.AlgorithmKey, jwa.EdDSA)
+	set := jwk.NewSet()
+	set.AddKey(public)
+	keys, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the signature of appendix B.2.6, which covers content-length but not content-digest
+	request := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+		r.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Content-Digest", "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:")
+		r.Header.Set("Content-Length", "18")
+		r.Header.Set("Signature-Input", `sig-b26=("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`)
+		r.Header.Set("Signature", "sig-b26=:wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw==:")
+		return r
+	}
+	maxAge := time.Since(time.Unix(1618884473, 0)) + time.Hour
+
+	h := NewVerifyHTTPSignature(VerifyHTTPSignatureConfig{Keys: []string{string(keys)}, MaxAge: maxAge, AllowUnsignedBody: true})
+	r, _, err := h.Handle(request())
+	if err != nil {
+		t.Fatalf("Handle() error = %v, want nil", err)
+	}
+	p, ok := principal.FromContext(r.Context())
+	if !ok || p.Subject != "test-key-ed25519" || p.Claims["alg"] != "EdDSA" || p.Claims["label"] != "sig-b26" {
+		t.Fatalf("Handle() principal = %+v, want the subject test-key-ed25519", p)
+	}
+
+	tampered := request()
+	tampered.Header.Set("Content-Type", "text/plain")
+	if _, _, err := h.Handle(tampered); !errors.Is(err, ErrInvalidHTTPSignature) {
+		t.Fatalf("Handle() error = %v, want %v", err, ErrInvalidHTTPSignature)
+	}
+
+	strict := NewVerifyHTTPSignature(VerifyHTTPSignatureConfig{Keys: []string{string(keys)}, MaxAge: maxAge})
+	if _, _, err := strict.Handle(request()); !errors.Is(err, ErrMissingSignedComponent) {
+		t.Fatalf("Handle() error = %v, want %v without content-digest", err, ErrMissingSignedComponent)
+	}
+}
@@ -0,0 +1,321 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bancodobrasil/goauth/log"
+	"github.com/bancodobrasil/goauth/principal"
+)
+
+var (
+	// ErrInactiveToken is returned when the introspection endpoint reports the token as inactive
+	ErrInactiveToken = errors.New("Inactive token")
+	// ErrIntrospectionUnavailable is returned when the introspection endpoint could not be called
+	ErrIntrospectionUnavailable = errors.New("Failed to introspect token")
+	// ErrInsufficientScope is returned when the token does not have the required scopes
+	ErrInsufficientScope = errors.New("Insufficient scope")
+)
+
+const (
+	// maxIntrospectionCacheEntries is the maximum number of cached results
+	maxIntrospectionCacheEntries = 10000
+	// introspectionCacheEvictionSample is the number of cached results looked at to make room for a new one
+	introspectionCacheEvictionSample = 64
+)
+
+// VerifyIntrospectionConfig stores the configuration for the VerifyIntrospection handler
+type VerifyIntrospectionConfig struct {
+	Header    string
+	TokenType string
+	// Sources are the places of the request the token is read from, tried in order. If empty, the token
+	// is read from Header, after TokenType
+	Sources []CredentialSource
+	// URL is the introspection endpoint (RFC 7662)
+	URL string
+	// ClientID and ClientSecret are the credentials used to call the introspection endpoint (HTTP Basic)
+	ClientID     string
+	ClientSecret string
+	// Audiences is the list of accepted aud values, the token must contain at least one of them.
+	// If empty, the aud value is not checked
+	Audiences []string
+	// Scopes is the list of scopes the token must have
+	Scopes []string
+	// CacheTTL is how long an active token result is cached. It is bounded by the token expiry.
+	// If zero, active results are not cached
+	CacheTTL time.Duration
+	// NegativeCacheTTL is how long an inactive token result is cached. If zero, inactive results are not cached
+	NegativeCacheTTL time.Duration
+	// Client is the HTTP client used to call the introspection endpoint. Defaults to a client with a 10 seconds timeout
+	Client *http.Client
+	// Realm is the realm sent on the WWW-Authenticate challenge
+	Realm string
+}
+
+// introspectionResponse is the response of the introspection endpoint
+type introspectionResponse struct {
+	Active    bool          `json:"active"`
+	Scope     string        `json:"scope"`
+	ClientID  string        `json:"client_id"`
+	Username  string        `json:"username"`
+	Subject   string        `json:"sub"`
+	Issuer    string        `json:"iss"`
+	Audience  audienceClaim `json:"aud"`
+	ExpiresAt int64         `json:"exp"`
+	IssuedAt  int64         `json:"iat"`
+	NotBefore int64         `json:"nbf"`
+	claims    map[string]any
+}
+
+// audienceClaim is an aud value, which may be either a string or a list of strings
+type audienceClaim []string
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (a *audienceClaim) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audienceClaim{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// introspectionCacheEntry is a cached introspection result
+type introspectionCacheEntry struct {
+	response *introspectionResponse
+	expires  time.Time
+}
+
+// VerifyIntrospection verifies opaque access tokens using an OAuth 2.0 token introspection endpoint (RFC 7662)
+type VerifyIntrospection struct {
+	extractor        *Extractor
+	tokenType        string
+	url              string
+	clientID         string
+	clientSecret     string
+	audiences        []string
+	scopes           []string
+	cacheTTL         time.Duration
+	negativeCacheTTL time.Duration
+	client           *http.Client
+	realm            string
+	mu               sync.Mutex
+	cache            map[[sha256.Size]byte]introspectionCacheEntry
+}
+
+// NewVerifyIntrospection returns a new VerifyIntrospection instance
+func NewVerifyIntrospection(cfg VerifyIntrospectionConfig) *VerifyIntrospection {
+	log.Log(log.Debug, "VerifyIntrospection: NewVerifyIntrospection")
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &VerifyIntrospection{
+		extractor:        newExtractor(cfg.Sources, cfg.Header, cfg.TokenType),
+		tokenType:        cfg.TokenType,
+		url:              cfg.URL,
+		clientID:         cfg.ClientID,
+		clientSecret:     cfg.ClientSecret,
+		audiences:        cfg.Audiences,
+		scopes:           cfg.Scopes,
+		cacheTTL:         cfg.CacheTTL,
+		negativeCacheTTL: cfg.NegativeCacheTTL,
+		client:           client,
+		realm:            cfg.Realm,
+		cache:            map[[sha256.Size]byte]introspectionCacheEntry{},
+	}
+}
+
+// Handle runs the VerifyIntrospection authentication handler
+func (m *VerifyIntrospection) Handle(r *http.Request) (request *http.Request, statusCode int, err error) {
+	log.Log(log.Debug, "VerifyIntrospection: Handle")
+	request, statusCode, err = m.handle(r)
+	challenge := bearerChallenge(m.tokenType, m.realm, err)
+	if errors.Is(err, ErrInsufficientScope) {
+		challenge.Error = "insufficient_scope"
+		challenge.Scope = strings.Join(m.scopes, " ")
+	}
+	return request, statusCode, WithChallenge(err, challenge)
+}
+
+func (m *VerifyIntrospection) handle(r *http.Request) (*http.Request, int, error) {
+	token, r, err := m.extractor.Extract(r)
+	if err != nil {
+		return r, 401, err
+	}
+
+	resp, err := m.introspect(r, token)
+	if err != nil {
+		log.Logf(log.Error, "%s: %s", ErrIntrospectionUnavailable, err)
+		return r, http.StatusServiceUnavailable, ErrIntrospectionUnavailable
+	}
+
+	defaultStatusCode := 401
+	now := time.Now()
+
+	if !resp.Active {
+		return r, defaultStatusCode, ErrInactiveToken
+	}
+	if resp.ExpiresAt != 0 && !now.Before(time.Unix(resp.ExpiresAt, 0)) {
+		return r, defaultStatusCode, &ClaimError{Claim: "exp", Err: ErrTokenExpired}
+	}
+	if resp.NotBefore != 0 && now.Before(time.Unix(resp.NotBefore, 0)) {
+		return r, defaultStatusCode, &ClaimError{Claim: "nbf", Err: ErrTokenNotYetValid}
+	}
+	if len(m.audiences) > 0 {
+		found := false
+		for _, aud := range resp.Audience {
+			if contains(m.audiences, aud) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return r, defaultStatusCode, &ClaimError{Claim: "aud", Err: ErrInvalidAudience}
+		}
+	}
+	scopes := strings.Fields(resp.Scope)
+	for _, scope := range m.scopes {
+		if !contains(scopes, scope) {
+			return r, http.StatusForbidden, ErrInsufficientScope
+		}
+	}
+
+	subject := resp.Subject
+	if subject == "" {
+		subject = resp.Username
+	}
+	p := &principal.Principal{
+		Subject:   subject,
+		Issuer:    resp.Issuer,
+		Audiences: resp.Audience,
+		Scopes:    scopes,
+		Claims:    resp.claims,
+		Handler:   "introspection",
+	}
+	if resp.IssuedAt != 0 {
+		p.IssuedAt = time.Unix(resp.IssuedAt, 0)
+	}
+	if resp.ExpiresAt != 0 {
+		p.ExpiresAt = time.Unix(resp.ExpiresAt, 0)
+	}
+	return r.WithContext(principal.NewContext(r.Context(), p)), 0, nil
+}
+
+// introspect returns the introspection result of the token, from the cache if available
+func (m *VerifyIntrospection) introspect(r *http.Request, token string) (*introspectionResponse, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	m.mu.Lock()
+	entry, ok := m.cache[key]
+	m.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.response, nil
+	}
+
+	resp, err := m.request(r, token)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := m.negativeCacheTTL
+	if resp.Active {
+		ttl = m.cacheTTL
+		if resp.ExpiresAt != 0 {
+			if untilExpiry := time.Unix(resp.ExpiresAt, 0).Sub(now); untilExpiry < ttl {
+				ttl = untilExpiry
+			}
+		}
+	}
+	if ttl > 0 {
+		m.mu.Lock()
+		if len(m.cache) >= maxIntrospectionCacheEntries {
+			m.evict(now)
+		}
+		m.cache[key] = introspectionCacheEntry{response: resp, expires: now.Add(ttl)}
+		m.mu.Unlock()
+	}
+
+	return resp, nil
+}
+
+// evict removes the expired results from a random sample of the cache, or the one of the sample
+// closest to expiring if none has expired. It must be called with m.mu held
+func (m *VerifyIntrospection) evict(now time.Time) {
+	var (
+		soonest [sha256.Size]byte
+		expires time.Time
+		expired bool
+		sampled int
+	)
+	for k, e := range m.cache {
+		if !now.Before(e.expires) {
+			delete(m.cache, k)
+			expired = true
+		} else if expires.IsZero() || e.expires.Before(expires) {
+			soonest, expires = k, e.expires
+		}
+		if sampled++; sampled >= introspectionCacheEvictionSample {
+			break
+		}
+	}
+	if !expired {
+		delete(m.cache, soonest)
+	}
+}
+
+// request calls the introspection endpoint
+func (m *VerifyIntrospection) request(r *http.Request, token string) (*introspectionResponse, error) {
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", "access_token")
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, m.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if m.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(m.clientID), url.QueryEscape(m.clientSecret))
+	}
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	var claims map[string]any
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	resp := &introspectionResponse{claims: claims}
+	if err := json.Unmarshal(raw, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bancodobrasil/goauth/principal"
+)
+
+// introspectionServer is a test introspection endpoint, which returns the response of each token
+// and counts the calls
+type introspectionServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	responses map[string]map[string]any
+	calls     map[string]int
+}
+
+func newIntrospectionServer(t *testing.T, responses map[string]map[string]any) *introspectionServer {
+	t.Helper()
+	s := &introspectionServer{responses: responses, calls: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "client" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		token := r.PostFormValue("token")
+		s.mu.Lock()
+		s.calls[token]++
+		s.mu.Unlock()
+		if token == "failure" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		response, ok := s.responses[token]
+		if !ok {
+			response = map[string]any{"active": false}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *introspectionServer) callCount(token string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[token]
+}
+
+// introspectionRequest returns a request with the bearer token
+func introspectionRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestVerifyIntrospection(t *testing.T) {
+	now := time.Now()
+	server := newIntrospectionServer(t, map[string]map[string]any{
+		"active":      {"active": true, "sub": "user", "aud": "api", "scope": "read write", "exp": now.Add(time.Hour).Unix()},
+		"expired":     {"active": true, "sub": "user", "aud": "api", "scope": "read", "exp": now.Add(-time.Minute).Unix()},
+		"not-yet":     {"active": true, "sub": "user", "aud": "api", "scope": "read", "nbf": now.Add(time.Hour).Unix()},
+		"other-aud":   {"active": true, "sub": "user", "aud": []string{"other"}, "scope": "read"},
+		"no-scope":    {"active": true, "sub": "user", "aud": "api", "scope": "write"},
+		"by-username": {"active": true, "username": "alice", "aud": "api", "scope": "read"},
+	})
+	h := NewVerifyIntrospection(VerifyIntrospectionConfig{
+		Header:       "Authorization",
+		TokenType:    "Bearer",
+		URL:          server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Audiences:    []string{"api"},
+		Scopes:       []string{"read"},
+	})
+
+	tests := []struct {
+		name        string
+		token       string
+		wantStatus  int
+		wantErr     error
+		wantSubject string
+	}{
+		{name: "active token", token: "active", wantSubject: "user"},
+		{name: "username as subject", token: "by-username", wantSubject: "alice"},
+		{name: "inactive token", token: "inactive", wantStatus: http.StatusUnauthorized, wantErr: ErrInactiveToken},
+		{name: "expired exp", token: "expired", wantStatus: http.StatusUnauthorized, wantErr: ErrTokenExpired},
+		{name: "future nbf", token: "not-yet", wantStatus: http.StatusUnauthorized, wantErr: ErrTokenNotYetValid},
+		{name: "invalid aud", token: "other-aud", wantStatus: http.StatusUnauthorized, wantErr: ErrInvalidAudience},
+		{name: "missing scope", token: "no-scope", wantStatus: http.StatusForbidden, wantErr: ErrInsufficientScope},
+		{name: "endpoint failure", token: "failure", wantStatus: http.StatusServiceUnavailable, wantErr: ErrIntrospectionUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, status, err := h.Handle(introspectionRequest(tt.token))
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Handle() error = %v, want nil", err)
+				}
+				p, ok := principal.FromContext(r.Context())
+				if !ok || p.Subject != tt.wantSubject || p.Handler != "introspection" {
+					t.Fatalf("Handle() principal = %+v, want the subject %s", p, tt.wantSubject)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) || status != tt.wantStatus {
+				t.Fatalf("Handle() = %d, %v, want %d, %v", status, err, tt.wantStatus, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyIntrospectionUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	h := NewVerifyIntrospection(VerifyIntrospectionConfig{Header: "Authorization", TokenType: "Bearer", URL: server.URL})
+
+	_, status, err := h.Handle(introspectionRequest("active"))
+	if !errors.Is(err, ErrIntrospectionUnavailable) || status != http.StatusServiceUnavailable {
+		t.Fatalf("Handle() = %d, %v, want 503, %v", status, err, ErrIntrospectionUnavailable)
+	}
+}
+
+func TestVerifyIntrospectionCache(t *testing.T) {
+	now := time.Now()
+	server := newIntrospectionServer(t, map[string]map[string]any{
+		"long-lived":  {"active": true, "sub": "user", "exp": now.Add(time.Hour).Unix()},
+		"short-lived": {"active": true, "sub": "user", "exp": now.Add(30 * time.Second).Unix()},
+	})
+	h := NewVerifyIntrospection(VerifyIntrospectionConfig{
+		Header:           "Authorization",
+		TokenType:        "Bearer",
+		URL:              server.URL,
+		ClientID:         "client",
+		ClientSecret:     "secret",
+		CacheTTL:         5 * time.Minute,
+		NegativeCacheTTL: 10 * time.Second,
+	})
+
+	cacheExpiry := func(token string) time.Duration {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		entry, ok := h.cache[sha256.Sum256([]byte(token))]
+		if !ok {
+			t.Fatalf("%s is not cached", token)
+		}
+		return time.Until(entry.expires)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantTTL time.Duration
+	}{
+		{name: "positive cache TTL", token: "long-lived", wantTTL: 5 * time.Minute},
+		{name: "positive cache TTL capped by exp", token: "short-lived", wantTTL: 30 * time.Second},
+		{name: "negative cache TTL", token: "inactive", wantTTL: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				h.Handle(introspectionRequest(tt.token))
+			}
+			if calls := server.callCount(tt.token); calls != 1 {
+				t.Fatalf("introspection endpoint called %d times, want 1", calls)
+			}
+			if ttl := cacheExpiry(tt.token); ttl > tt.wantTTL || ttl < tt.wantTTL-5*time.Second {
+				t.Fatalf("cache TTL = %s, want %s", ttl, tt.wantTTL)
+			}
+		})
+	}
+
+	t.Run("failures are not cached", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			h.Handle(introspectionRequest("failure"))
+		}
+		if calls := server.callCount("failure"); calls != 2 {
+			t.Fatalf("introspection endpoint called %d times, want 2", calls)
+		}
+	})
+
+	fill := func(expires time.Time) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.cache = map[[sha256.Size]byte]introspectionCacheEntry{}
+		for i := 0; i < maxIntrospectionCacheEntries; i++ {
+			h.cache[sha256.Sum256([]byte(fmt.Sprint("filler-", i)))] = introspectionCacheEntry{expires: expires}
+		}
+	}
+
+	t.Run("full cache evicts the oldest sampled result", func(t *testing.T) {
+		fill(now.Add(time.Minute))
+		h.Handle(introspectionRequest("long-lived"))
+		cacheExpiry("long-lived")
+		if len(h.cache) != maxIntrospectionCacheEntries {
+			t.Fatalf("cache size = %d, want %d", len(h.cache), maxIntrospectionCacheEntries)
+		}
+	})
+
+	t.Run("full cache evicts the expired sampled results", func(t *testing.T) {
+		fill(now.Add(-time.Minute))
+		h.Handle(introspectionRequest("long-lived"))
+		cacheExpiry("long-lived")
+		if want := maxIntrospectionCacheEntries - introspectionCacheEvictionSample + 1; len(h.cache) != want {
+			t.Fatalf("cache size = %d, want %d", len(h.cache), want)
+		}
+	})
+}
@@ -2,15 +2,12 @@ package handler
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/bancodobrasil/goauth/log"
 	"github.com/bancodobrasil/goauth/pkg/jwks"
-	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 )
@@ -25,39 +22,92 @@ type CacheConfig struct {
 	Context context.Context
 }
 
+// IssuerConfig maps an issuer to the JWKS endpoint used to verify its tokens
+type IssuerConfig struct {
+	// CacheConfig is the configuration of the JWKS cache of the issuer
+	CacheConfig
+	// Issuer is the iss claim of the tokens of the issuer
+	Issuer string
+	// URL is the JWKS endpoint of the issuer. If empty, it is discovered from the
+	// OpenID Connect discovery document of the issuer
+	URL string
+}
+
 // VerifyJWKSConfig stores the configuration for the VerifyJWKS handler
 type VerifyJWKSConfig struct {
 	CacheConfig
+	ClaimsConfig
+	CertificateBindingConfig
 	Header    string
 	TokenType string
+	// Sources are the places of the request the token is read from, tried in order. If empty, the token
+	// is read from Header, after TokenType
+	Sources []CredentialSource
 	// URL is the endpoint of the JWKS
 	URL string
-	// PayloadContextKey is the context key to store the JWT payload
+	// Issuer is the URL of an OpenID Connect issuer. When set, the JWKS endpoint is discovered from
+	// its /.well-known/openid-configuration document and the iss claim must match the issuer. When
+	// Algorithms is empty, the token must also be signed with one of the id_token_signing_alg_values_supported
+	// of the document. They are the algorithms of the ID tokens, so set Algorithms if the access tokens are
+	// signed with others. The discovery document is refreshed with the same settings as the JWKS cache
+	Issuer string
+	// IssuerConfigs is the list of accepted issuers, each one with its own JWKS endpoint.
+	// The key set is selected by the unverified iss claim of the token (or the IssuerHeader),
+	// and tokens of issuers not in the list are rejected, unless AllowOtherIssuers is set
+	IssuerConfigs []IssuerConfig
+	// AllowOtherIssuers makes the tokens of the issuers not in IssuerConfigs (or Issuer) be verified
+	// with the key set of URL, instead of refused. It requires URL
+	AllowOtherIssuers bool
+	// IssuerHeader is the request header used to select the issuer instead of the iss claim.
+	// The iss claim must still match the selected issuer
+	IssuerHeader string
+	// Algorithms is the allow-list of signature algorithms. If empty, all the asymmetric algorithms
+	// are allowed (see jwks.DefaultAlgorithms), restricted by the discovery document of the issuers, if any.
+	// none and the HMAC algorithms are never allowed
+	Algorithms []string
+	// PayloadContextKey is the context key to store the raw JWT payload. If empty,
+	// only the principal is stored in the context
 	PayloadContextKey string
+	// Realm is the realm sent on the WWW-Authenticate challenge
+	Realm string
 }
 
-// VerifyJWKS stores the JWKS endpoint to be used for
+// VerifyJWKS stores the JWKS endpoints to be used for
 // getting the signature key for JWT token verification
-// and the cache for the signature key
+// and the caches for the signature keys
 type VerifyJWKS struct {
-	header            string
+	extractor         *Extractor
 	tokenType         string
-	url               string
 	ctx               context.Context
-	signatureKeyCache *jwk.Cache
+	defaultSource     *keySource
+	issuerSources     map[string]*keySource
+	allowOthers       bool
+	issuerHeader      string
+	algorithms        []jwa.SignatureAlgorithm
+	discoveredAlgs    bool
 	payloadContextKey string
+	claims            ClaimsConfig
+	binding           CertificateBindingConfig
+	realm             string
 }
 
 // NewVerifyJWKS returns a new VerifyJWKS instance
 func NewVerifyJWKS(cfg VerifyJWKSConfig) *VerifyJWKS {
 	log.Log(log.Debug, "VerifyJWKS: NewVerifyJWKS")
+	if cfg.Context == nil {
+		cfg.Context = context.Background()
+	}
 	VerifyJWKS := &VerifyJWKS{
-		header:            cfg.Header,
+		extractor:         newExtractor(cfg.Sources, cfg.Header, cfg.TokenType),
 		tokenType:         cfg.TokenType,
-		url:               cfg.URL,
 		ctx:               cfg.Context,
-		signatureKeyCache: jwk.NewCache(cfg.Context, jwk.WithRefreshWindow(cfg.RefreshWindow)),
+		issuerSources:     map[string]*keySource{},
+		allowOthers:       cfg.AllowOtherIssuers,
+		issuerHeader:      cfg.IssuerHeader,
 		payloadContextKey: cfg.PayloadContextKey,
+		claims:            cfg.ClaimsConfig,
+		binding:           cfg.CertificateBindingConfig,
+		realm:             cfg.Realm,
 	}
 
 	VerifyJWKS.setup(cfg)
@@ -65,70 +115,147 @@ func NewVerifyJWKS(cfg VerifyJWKSConfig) *VerifyJWKS {
 	return VerifyJWKS
 }
 
-// setup sets up the signature key cache
+// setup sets up the allowed algorithms and the key sources
 func (m *VerifyJWKS) setup(cfg VerifyJWKSConfig) {
-	m.signatureKeyCache.Register(m.url, jwk.WithMinRefreshInterval(cfg.MinRefreshInterval))
-	_, err := m.signatureKeyCache.Refresh(m.ctx, m.url)
+	algorithms, err := jwks.ParseAlgorithms(cfg.Algorithms)
 	if err != nil {
-		log.Logf(log.Panic, "Failed to refresh JWKS: %s\n", err)
+		log.Logf(log.Panic, "Invalid JWKS algorithm: %s\n", err)
+		return
+	}
+	for _, alg := range algorithms {
+		if alg == jwa.NoSignature || jwks.IsSymmetric(alg) {
+			log.Logf(log.Panic, "JWKS algorithm %s is not allowed with a public key set\n", alg)
+			return
+		}
+	}
+	m.algorithms = algorithms
+	m.discoveredAlgs = len(cfg.Algorithms) == 0
+
+	if cfg.URL == "" && cfg.Issuer == "" && len(cfg.IssuerConfigs) == 0 {
+		log.Log(log.Panic, "The JWKS handler requires a URL, an Issuer or IssuerConfigs\n")
+		return
+	}
+	if cfg.AllowOtherIssuers && cfg.URL == "" {
+		log.Log(log.Panic, "The JWKS handler requires a URL to allow other issuers\n")
+		return
+	}
+
+	if cfg.Issuer != "" {
+		cfg.IssuerConfigs = append([]IssuerConfig{{CacheConfig: cfg.CacheConfig, Issuer: cfg.Issuer}}, cfg.IssuerConfigs...)
+	}
+
+	for _, issuerCfg := range cfg.IssuerConfigs {
+		if issuerCfg.Context == nil {
+			issuerCfg.Context = cfg.Context
+		}
+		source, err := newKeySource(issuerCfg.Issuer, issuerCfg.URL, issuerCfg.URL == "", issuerCfg.CacheConfig)
+		if err != nil {
+			log.Logf(log.Panic, "Failed to set up JWKS of %s: %s\n", issuerCfg.Issuer, err)
+			return
+		}
+		m.issuerSources[issuerCfg.Issuer] = source
+	}
+
+	if cfg.URL != "" && len(m.issuerSources) > 0 && !cfg.AllowOtherIssuers {
+		log.Log(log.Warn, "The JWKS URL is ignored, since the tokens of issuers not in IssuerConfigs are refused unless AllowOtherIssuers is set\n")
+		return
+	}
+	if cfg.URL != "" {
+		source, err := newKeySource("", cfg.URL, false, cfg.CacheConfig)
+		if err != nil {
+			log.Logf(log.Panic, "Failed to refresh JWKS: %s\n", err)
+			return
+		}
+		m.defaultSource = source
 	}
 }
 
 // Handle runs the VerifyJWKS authentication handler
 func (m *VerifyJWKS) Handle(r *http.Request) (request *http.Request, statusCode int, err error) {
 	log.Log(log.Debug, "VerifyJWKS: Handle")
-	token, statusCode, err := m.extractTokenFromHeader(&r.Header)
+	request, statusCode, err = m.handle(r)
+	return request, statusCode, WithChallenge(err, bearerChallenge(m.tokenType, m.realm, err))
+}
+
+func (m *VerifyJWKS) handle(r *http.Request) (*http.Request, int, error) {
+	token, r, err := m.extractor.Extract(r)
 	if err != nil {
-		return r, statusCode, err
+		return r, 401, err
 	}
 
-	invalidJWTError := errors.New("Invalid JWT token")
 	defaultStatusCode := 401
 
 	msg, internalErr := jws.Parse([]byte(token))
 	if internalErr != nil {
 		log.Log(log.Error, internalErr)
-		return r, defaultStatusCode, invalidJWTError
+		return r, defaultStatusCode, ErrInvalidToken
 	}
 
-	keyHandler := &jwks.KeyHandler{
-		Fetcher: m.getSignatureKey,
+	source, err := m.selectSource(r, msg.Payload())
+	if err != nil {
+		return r, defaultStatusCode, err
+	}
+	if source == nil {
+		log.Logf(log.Error, "%s: the JWKS handler has no key set\n", ErrKeySetUnavailable)
+		return r, defaultStatusCode, ErrKeySetUnavailable
 	}
 
-	_, internalErr = jwt.Parse(msg.Payload(), jwt.WithKeyProvider(keyHandler), jwt.WithContext(m.ctx))
+	algorithms := source.refreshDiscovery()
+	if m.discoveredAlgs && len(algorithms) > 0 && !contains(algorithms, msg.Signatures()[0].ProtectedHeaders().Algorithm().String()) {
+		return r, defaultStatusCode, ErrUnsupportedAlgorithm
+	}
 
-	c := context.WithValue(r.Context(), m.payloadContextKey, string(msg.Payload()))
-	return r.WithContext(c), 0, nil
-}
+	keyHandler := &jwks.KeyHandler{
+		Fetcher:    source.getSignatureKey,
+		Algorithms: m.algorithms,
+	}
 
-func (m *VerifyJWKS) extractTokenFromHeader(h *http.Header) (string, int, error) {
-	authorizationHeader := h.Get(m.header)
-	if authorizationHeader == "" {
-		return "", 401, errors.New(fmt.Sprintf("Missing %s Header", m.header))
+	claims := m.claims
+	if source.issuer != "" {
+		claims.Issuers = []string{source.issuer}
 	}
-	if m.tokenType == "" {
-		return authorizationHeader, 0, nil
+
+	options := append(claims.parseOptions(), jwt.WithKeyProvider(keyHandler), jwt.WithContext(m.ctx))
+	parsed, internalErr := jwt.Parse([]byte(token), options...)
+	if internalErr != nil {
+		log.Log(log.Error, internalErr)
+		return r, defaultStatusCode, tokenError(internalErr)
 	}
-	splitHeader := strings.Split(authorizationHeader, "Bearer")
-	if len(splitHeader) != 2 {
-		return "", 401, errors.New(fmt.Sprintf("Invalid %s Header", m.header))
+
+	if err := m.binding.checkBinding(r, parsed); err != nil {
+		log.Log(log.Error, err)
+		return r, defaultStatusCode, err
 	}
-	return strings.TrimSpace(splitHeader[1]), 0, nil
+
+	p := newTokenPrincipal(r.Context(), "jwks", parsed)
+	return withPrincipal(r, p, m.payloadContextKey, msg.Payload()), 0, nil
 }
 
-func (m *VerifyJWKS) getSignatureKey(ctx context.Context, keyID string) (jwk.Key, error) {
-	keyset, err := m.signatureKeyCache.Get(m.ctx, m.url)
-	errorMsg := "Failed to fetch JWKS"
-	if err != nil {
-		log.Logf(log.Error, "%s: %s\n", errorMsg, err)
-		return nil, errors.New(errorMsg)
+// selectSource returns the key source of the issuer of the token, selected by the
+// IssuerHeader or by the unverified iss claim of the payload
+func (m *VerifyJWKS) selectSource(r *http.Request, payload []byte) (*keySource, error) {
+	if len(m.issuerSources) == 0 {
+		return m.defaultSource, nil
 	}
 
-	key, ok := keyset.LookupKeyID(keyID)
-	if !ok {
-		log.Logf(log.Error, "%s: %s\n", errorMsg, err)
-		return nil, errors.New(errorMsg)
+	var issuer string
+	if m.issuerHeader != "" {
+		issuer = r.Header.Get(m.issuerHeader)
+	} else {
+		unverified, err := jwt.Parse(payload, jwt.WithVerify(false), jwt.WithValidate(false))
+		if err != nil {
+			log.Log(log.Error, err)
+			return nil, ErrInvalidToken
+		}
+		issuer = unverified.Issuer()
 	}
 
-	return key, nil
+	if source, ok := m.issuerSources[issuer]; ok {
+		return source, nil
+	}
+	if m.allowOthers && m.defaultSource != nil {
+		return m.defaultSource, nil
+	}
+	log.Logf(log.Error, "%s: %s\n", ErrInvalidIssuer, issuer)
+	return nil, &ClaimError{Claim: jwt.IssuerKey, Err: ErrInvalidIssuer}
 }
@@ -0,0 +1,387 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bancodobrasil/goauth/principal"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// newTestSigningKey returns a new RSA private key with the kid and the RS256 alg
+func newTestSigningKey(t *testing.T, kid string) jwk.Key {
+	t.Helper()
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Set(jwk.KeyIDKey, kid)
+	key.Set(jwk.AlgorithmKey, jwa.RS256)
+	return key
+}
+
+// newTestJWKSServer returns a JWKS endpoint serving the public keys of the private keys
+func newTestJWKSServer(t *testing.T, keys ...jwk.Key) *httptest.Server {
+	t.Helper()
+	set := jwk.NewSet()
+	for _, key := range keys {
+		public, err := key.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		set.AddKey(public)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newFlakyJWKSServer returns a JWKS endpoint that serves the public keys only on the first request,
+// and fails with 500 afterwards
+func newFlakyJWKSServer(t *testing.T, keys ...jwk.Key) *httptest.Server {
+	t.Helper()
+	healthy := newTestJWKSServer(t, keys...)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		healthy.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// signTestToken returns a token with the claims signed by the key
+func signTestToken(t *testing.T, key jwk.Key, claims map[string]any) string {
+	t.Helper()
+	token := jwt.New()
+	for name, value := range claims {
+		if err := token.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func TestVerifyJWKS(t *testing.T) {
+	key := newTestSigningKey(t, "key-1")
+	server := newTestJWKSServer(t, key)
+
+	now := time.Now()
+	otherKey := newTestSigningKey(t, "key-1")
+	unknownKey := newTestSigningKey(t, "key-2")
+	claims := ClaimsConfig{Issuers: []string{"https://issuer.example.com"}, Audiences: []string{"api", "admin"}}
+
+	tests := []struct {
+		name    string
+		url     string
+		claims  ClaimsConfig
+		token   string
+		wantErr error
+	}{
+		{
+			name:  "valid token",
+			url:   server.URL,
+			token: signTestToken(t, key, map[string]any{"sub": "user", "exp": now.Add(time.Hour)}),
+		},
+		{
+			name:    "bad signature",
+			url:     server.URL,
+			token:   signTestToken(t, otherKey, map[string]any{"sub": "user", "exp": now.Add(time.Hour)}),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "unknown kid",
+			url:     server.URL,
+			token:   signTestToken(t, unknownKey, map[string]any{"sub": "user", "exp": now.Add(time.Hour)}),
+			wantErr: ErrUnknownKeyID,
+		},
+		{
+			name:    "expired exp",
+			url:     server.URL,
+			token:   signTestToken(t, key, map[string]any{"sub": "user", "exp": now.Add(-time.Hour)}),
+			wantErr: ErrTokenExpired,
+		},
+		{
+			name:    "future nbf",
+			url:     server.URL,
+			token:   signTestToken(t, key, map[string]any{"sub": "user", "nbf": now.Add(time.Hour)}),
+			wantErr: ErrTokenNotYetValid,
+		},
+		{
+			name:    "future iat",
+			url:     server.URL,
+			token:   signTestToken(t, key, map[string]any{"sub": "user", "iat": now.Add(time.Hour)}),
+			wantErr: ErrInvalidIssuedAt,
+		},
+		{
+			name:   "accepted issuer and audience",
+			url:    server.URL,
+			claims: claims,
+			token:  signTestToken(t, key, map[string]any{"sub": "user", "iss": "https://issuer.example.com", "aud": []string{"web", "api"}}),
+		},
+		{
+			name:    "other issuer",
+			url:     server.URL,
+			claims:  claims,
+			token:   signTestToken(t, key, map[string]any{"sub": "user", "iss": "https://other.example.com", "aud": "api"}),
+			wantErr: ErrInvalidIssuer,
+		},
+		{
+			name:    "missing issuer",
+			url:     server.URL,
+			claims:  claims,
+			token:   signTestToken(t, key, map[string]any{"sub": "user", "aud": "api"}),
+			wantErr: ErrInvalidIssuer,
+		},
+		{
+			name:    "other audience",
+			url:     server.URL,
+			claims:  claims,
+			token:   signTestToken(t, key, map[string]any{"sub": "user", "iss": "https://issuer.example.com", "aud": []string{"web"}}),
+			wantErr: ErrInvalidAudience,
+		},
+		{
+			name:    "missing audience",
+			url:     server.URL,
+			claims:  claims,
+			token:   signTestToken(t, key, map[string]any{"sub": "user", "iss": "https://issuer.example.com"}),
+			wantErr: ErrInvalidAudience,
+		},
+		{
+			name:  "known kid while the JWKS endpoint fails",
+			url:   newFlakyJWKSServer(t, key).URL,
+			token: signTestToken(t, key, map[string]any{"sub": "user", "exp": now.Add(time.Hour)}),
+		},
+		{
+			name:    "unknown kid while the JWKS endpoint fails",
+			url:     newFlakyJWKSServer(t, key).URL,
+			token:   signTestToken(t, unknownKey, map[string]any{"sub": "user", "exp": now.Add(time.Hour)}),
+			wantErr: ErrKeySetUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewVerifyJWKS(VerifyJWKSConfig{
+				ClaimsConfig:      tt.claims,
+				Header:            "Authorization",
+				TokenType:         "Bearer",
+				URL:               tt.url,
+				PayloadContextKey: "payload",
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			r, status, err := h.Handle(r)
+
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Handle() error = %v, want nil", err)
+				}
+				p, ok := principal.FromContext(r.Context())
+				if !ok || p.Subject != "user" || p.Handler != "jwks" {
+					t.Fatalf("Handle() principal = %+v, want the subject user", p)
+				}
+				var payload map[string]any
+				if err := json.Unmarshal([]byte(r.Context().Value("payload").(string)), &payload); err != nil || payload["sub"] != "user" {
+					t.Fatalf("Handle() payload = %v, want the subject user", payload)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Handle() error = %v, want %v", err, tt.wantErr)
+			}
+			if status != http.StatusUnauthorized {
+				t.Fatalf("Handle() status = %d, want %d", status, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestVerifyJWKSRotatedKey(t *testing.T) {
+	key := newTestSigningKey(t, "key-1")
+	rotated := newTestSigningKey(t, "key-2")
+	var keys atomic.Value
+	keys.Store([]jwk.Key{key})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		set := jwk.NewSet()
+		for _, k := range keys.Load().([]jwk.Key) {
+			public, _ := k.PublicKey()
+			set.AddKey(public)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(server.Close)
+
+	handle := func(m *VerifyJWKS, key jwk.Key) error {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+signTestToken(t, key, map[string]any{"sub": "user"}))
+		_, _, err := m.Handle(r)
+		return err
+	}
+
+	m := NewVerifyJWKS(VerifyJWKSConfig{
+		CacheConfig: CacheConfig{RefreshWindow: time.Hour, MinRefreshInterval: time.Hour},
+		Header:      "Authorization",
+		TokenType:   "Bearer",
+		URL:         server.URL,
+	})
+	if err := handle(m, rotated); !errors.Is(err, ErrUnknownKeyID) {
+		t.Fatalf("Handle() error = %v, want %v", err, ErrUnknownKeyID)
+	}
+
+	// the key set was refreshed for the first unknown kid, so it is not refreshed again within the interval
+	keys.Store([]jwk.Key{key, rotated})
+	if err := handle(m, rotated); !errors.Is(err, ErrUnknownKeyID) {
+		t.Fatalf("Handle() error = %v, want %v within the min refresh interval", err, ErrUnknownKeyID)
+	}
+
+	m = NewVerifyJWKS(VerifyJWKSConfig{Header: "Authorization", TokenType: "Bearer", URL: server.URL})
+	keys.Store([]jwk.Key{key})
+	if err := handle(m, key); err != nil {
+		t.Fatalf("Handle() error = %v, want nil", err)
+	}
+	keys.Store([]jwk.Key{key, rotated})
+	if err := handle(m, rotated); err != nil {
+		t.Fatalf("Handle() error = %v, want the rotated key to be fetched", err)
+	}
+}
+
+func TestVerifyJWKSIssuers(t *testing.T) {
+	accountsKey := newTestSigningKey(t, "accounts-1")
+	defaultKey := newTestSigningKey(t, "default-1")
+	accounts := newTestJWKSServer(t, accountsKey)
+	fallback := newTestJWKSServer(t, defaultKey)
+	exp := time.Now().Add(time.Hour)
+
+	newHandler := func(allowOthers bool) *VerifyJWKS {
+		return NewVerifyJWKS(VerifyJWKSConfig{
+			Header:            "Authorization",
+			TokenType:         "Bearer",
+			URL:               fallback.URL,
+			IssuerConfigs:     []IssuerConfig{{Issuer: "https://accounts.example.com", URL: accounts.URL}},
+			AllowOtherIssuers: allowOthers,
+		})
+	}
+	strict := newHandler(false)
+	lenient := newHandler(true)
+
+	tests := []struct {
+		name    string
+		handler *VerifyJWKS
+		token   string
+		wantErr error
+	}{
+		{
+			name:    "registered issuer",
+			handler: strict,
+			token:   signTestToken(t, accountsKey, map[string]any{"sub": "user", "iss": "https://accounts.example.com", "exp": exp}),
+		},
+		{
+			name:    "registered issuer signed by the key of the URL",
+			handler: strict,
+			token:   signTestToken(t, defaultKey, map[string]any{"sub": "user", "iss": "https://accounts.example.com", "exp": exp}),
+			wantErr: ErrUnknownKeyID,
+		},
+		{
+			name:    "unknown issuer refused",
+			handler: strict,
+			token:   signTestToken(t, defaultKey, map[string]any{"sub": "user", "iss": "https://other.example.com", "exp": exp}),
+			wantErr: ErrInvalidIssuer,
+		},
+		{
+			name:    "token without issuer refused",
+			handler: strict,
+			token:   signTestToken(t, defaultKey, map[string]any{"sub": "user", "exp": exp}),
+			wantErr: ErrInvalidIssuer,
+		},
+		{
+			name:    "unknown issuer allowed with the key set of the URL",
+			handler: lenient,
+			token:   signTestToken(t, defaultKey, map[string]any{"sub": "user", "iss": "https://other.example.com", "exp": exp}),
+		},
+		{
+			name:    "unknown issuer allowed, signed by the key of a registered issuer",
+			handler: lenient,
+			token:   signTestToken(t, accountsKey, map[string]any{"sub": "user", "iss": "https://other.example.com", "exp": exp}),
+			wantErr: ErrUnknownKeyID,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			_, status, err := tt.handler.Handle(r)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Handle() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) || status != http.StatusUnauthorized {
+				t.Fatalf("Handle() = %d, %v, want 401, %v", status, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyJWKSDiscovery(t *testing.T) {
+	key := newTestSigningKey(t, "key-1")
+	jwksServer := newTestJWKSServer(t, key)
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                "http://" + r.Host,
+			"jwks_uri":                              jwksServer.URL,
+			"id_token_signing_alg_values_supported": []string{"ES256"},
+		})
+	}))
+	t.Cleanup(issuer.Close)
+
+	token := signTestToken(t, key, map[string]any{"sub": "user", "iss": issuer.URL, "exp": time.Now().Add(time.Hour)})
+	handle := func(m *VerifyJWKS, token string) error {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		_, _, err := m.Handle(r)
+		return err
+	}
+
+	discovered := NewVerifyJWKS(VerifyJWKSConfig{Header: "Authorization", TokenType: "Bearer", Issuer: issuer.URL})
+	if err := handle(discovered, token); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("Handle() error = %v, want %v for an algorithm not supported by the issuer", err, ErrUnsupportedAlgorithm)
+	}
+
+	configured := NewVerifyJWKS(VerifyJWKSConfig{Header: "Authorization", TokenType: "Bearer", Issuer: issuer.URL, Algorithms: []string{"RS256"}})
+	if err := handle(configured, token); err != nil {
+		t.Fatalf("Handle() error = %v, want the configured algorithms to replace the ones of the issuer", err)
+	}
+	other := signTestToken(t, key, map[string]any{"sub": "user", "iss": "https://other.example.com", "exp": time.Now().Add(time.Hour)})
+	if err := handle(configured, other); !errors.Is(err, ErrInvalidIssuer) {
+		t.Fatalf("Handle() error = %v, want %v", err, ErrInvalidIssuer)
+	}
+}
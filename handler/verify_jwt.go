@@ -3,12 +3,14 @@ package handler
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net/http"
-	"strings"
+	"sync"
 
 	"github.com/bancodobrasil/goauth/log"
+	"github.com/bancodobrasil/goauth/pkg/jwks"
 	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jws"
@@ -17,90 +19,226 @@ import (
 
 // VerifyJWTConfig stores the configuration for the VerifyJWT handler
 type VerifyJWTConfig struct {
-	Header             string
-	TokenType          string
-	SignatureKey       string
+	ClaimsConfig
+	CertificateBindingConfig
+	Header    string
+	TokenType string
+	// Sources are the places of the request the token is read from, tried in order. If empty, the token
+	// is read from Header, after TokenType
+	Sources []CredentialSource
+	// SignatureKey is the key material of the signature keys: a JWK or a JWK Set (JSON),
+	// PEM encoded RSA, ECDSA or Ed25519 keys or x509 certificates, a base64 encoded HMAC secret
+	// (with the base64: prefix) or a raw HMAC secret (with the raw: prefix). Unprefixed raw HMAC secrets
+	// are still accepted, with a deprecation warning
+	SignatureKey string
+	// SignatureKeyFile is the path of a file with signature keys, in the same formats as SignatureKey.
+	// The keys are reloaded when the file changes
+	SignatureKeyFile string
+	// SignatureKeys are additional signature keys, in the same formats as SignatureKey
+	SignatureKeys []string
+	// SignatureKeyFiles are the paths of files with signature keys, in the same formats as SignatureKey.
+	// The keys are reloaded when the files change, so keys can be rotated without a restart
+	SignatureKeyFiles []string
+	// VerifyCertificates makes the x509 certificates be verified, when the keys are loaded, against
+	// the CertificateRoots. The CA certificates of the key material are used as intermediates
+	VerifyCertificates bool
+	// CertificateRoots are the trusted root certificates. If nil, the system roots are used
+	CertificateRoots *x509.CertPool
+	// SignatureAlgorithm is the algorithm used to sign the tokens
 	SignatureAlgorithm string
-	PayloadContextKey  string
+	// SignatureAlgorithms is the allow-list of signature algorithms, used instead of SignatureAlgorithm
+	// when the keys are of different types. none is never allowed
+	SignatureAlgorithms []string
+	// PayloadContextKey is the context key to store the raw JWT payload. If empty,
+	// only the principal is stored in the context
+	PayloadContextKey string
+	// Realm is the realm sent on the WWW-Authenticate challenge
+	Realm string
+	// Context controls the life-cycle of the signature key files watcher
+	Context context.Context
 }
 
-// VerifyJWT stores the JWKS signature key
+// VerifyJWT stores the static signature keys
 type VerifyJWT struct {
-	header            string
+	extractor         *Extractor
 	tokenType         string
-	signatureKey      jwk.Key
-	signatureAlg      jwa.SignatureAlgorithm
+	inlineKeys        []string
+	keyFiles          []string
+	keyOptions        keyOptions
+	mu                sync.RWMutex
+	signatureKeys     jwk.Set
+	keyHandler        *jwks.KeyHandler
 	payloadContextKey string
+	claims            ClaimsConfig
+	binding           CertificateBindingConfig
+	realm             string
 }
 
 // NewVerifyJWT returns a new VerifyJWT instance
 func NewVerifyJWT(cfg VerifyJWTConfig) *VerifyJWT {
 	log.Log(log.Debug, "VerifyJWT: NewVerifyJWT")
-	key, err := jwk.FromRaw([]byte(cfg.SignatureKey))
+	algorithmNames := cfg.SignatureAlgorithms
+	if len(algorithmNames) == 0 && cfg.SignatureAlgorithm != "" {
+		algorithmNames = []string{cfg.SignatureAlgorithm}
+	}
+	algorithms, err := jwks.ParseAlgorithms(algorithmNames)
 	if err != nil {
 		log.Log(log.Panic, err)
 		return nil
 	}
+
+	inlineKeys := cfg.SignatureKeys
+	if cfg.SignatureKey != "" {
+		inlineKeys = append([]string{cfg.SignatureKey}, inlineKeys...)
+	}
+
+	keyFiles := cfg.SignatureKeyFiles
+	if cfg.SignatureKeyFile != "" {
+		keyFiles = append([]string{cfg.SignatureKeyFile}, keyFiles...)
+	}
+
 	VerifyJWT := &VerifyJWT{
-		header:            cfg.Header,
-		tokenType:         cfg.TokenType,
-		signatureAlg:      jwa.SignatureAlgorithm(cfg.SignatureAlgorithm),
-		signatureKey:      key,
+		extractor:  newExtractor(cfg.Sources, cfg.Header, cfg.TokenType),
+		tokenType:  cfg.TokenType,
+		inlineKeys: inlineKeys,
+		keyFiles:   keyFiles,
+		keyOptions: keyOptions{
+			verifyCertificates: cfg.VerifyCertificates,
+			roots:              cfg.CertificateRoots,
+		},
+		keyHandler: &jwks.KeyHandler{
+			Algorithms:     algorithms,
+			AllowSymmetric: true,
+		},
 		payloadContextKey: cfg.PayloadContextKey,
+		claims:            cfg.ClaimsConfig,
+		binding:           cfg.CertificateBindingConfig,
+		realm:             cfg.Realm,
+	}
+
+	if err := VerifyJWT.load(); err != nil {
+		log.Log(log.Panic, err)
+		return nil
+	}
+
+	ctx := cfg.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for _, file := range keyFiles {
+		err := watchFile(ctx, file, func() {
+			if err := VerifyJWT.load(); err != nil {
+				log.Logf(log.Error, "Failed to reload signature keys, keeping the previous keys: %s", err)
+			}
+		})
+		if err != nil {
+			log.Logf(log.Error, "Failed to watch signature key file: %s", err)
+		}
 	}
 
 	return VerifyJWT
 }
 
+// load replaces the signature keys with the inline keys and the keys of the key files
+func (m *VerifyJWT) load() error {
+	keys, err := loadKeys(m.inlineKeys, m.keyFiles, m.keyOptions)
+	if err != nil {
+		return err
+	}
+	if keys.Len() == 0 {
+		return errors.New("No JWT signature keys")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signatureKeys = keys
+	return nil
+}
+
 // Handle runs the VerifyJWT authentication handler
 func (m *VerifyJWT) Handle(r *http.Request) (request *http.Request, statusCode int, err error) {
 	log.Log(log.Debug, "VerifyJWT: Handle")
-	token, statusCode, err := m.extractTokenFromHeader(&r.Header)
+	request, statusCode, err = m.handle(r)
+	return request, statusCode, WithChallenge(err, bearerChallenge(m.tokenType, m.realm, err))
+}
+
+func (m *VerifyJWT) handle(r *http.Request) (*http.Request, int, error) {
+	token, r, err := m.extractor.Extract(r)
 	if err != nil {
-		return r, statusCode, err
+		return r, 401, err
 	}
 
-	invalidJWTError := errors.New("Invalid JWT token")
 	defaultStatusCode := 401
 
 	msg, internalErr := jws.Parse([]byte(token))
 	if internalErr != nil {
 		log.Log(log.Error, internalErr)
-		return r, defaultStatusCode, invalidJWTError
+		return r, defaultStatusCode, ErrInvalidToken
 	}
 
-	verified, internalErr := jws.Verify([]byte(token), jws.WithKey(m.signatureAlg, m.signatureKey))
+	headers := msg.Signatures()[0].ProtectedHeaders()
+	options, err := m.verifyOptions(headers.Algorithm(), headers.KeyID())
+	if err != nil {
+		log.Log(log.Error, err)
+		return r, defaultStatusCode, tokenError(err)
+	}
+
+	verified, internalErr := jws.Verify([]byte(token), options...)
 	if internalErr != nil {
 		log.Log(log.Error, internalErr)
-		return r, defaultStatusCode, invalidJWTError
+		return r, defaultStatusCode, tokenError(internalErr)
 	}
 
 	if !bytes.Equal(verified, msg.Payload()) {
-		return r, defaultStatusCode, invalidJWTError
+		return r, defaultStatusCode, ErrInvalidSignature
 	}
 
-	_, parseErr := jwt.Parse(msg.Payload(), jwt.WithVerify(false))
+	parseOptions := append(m.claims.parseOptions(), jwt.WithVerify(false))
+	parsed, parseErr := jwt.Parse(msg.Payload(), parseOptions...)
 	if parseErr != nil {
 		log.Log(log.Error, parseErr)
-		return r, defaultStatusCode, invalidJWTError
+		return r, defaultStatusCode, tokenError(parseErr)
 	}
 
-	ctx := context.WithValue(r.Context(), m.payloadContextKey, string(msg.Payload()))
+	if err := m.binding.checkBinding(r, parsed); err != nil {
+		log.Log(log.Error, err)
+		return r, defaultStatusCode, err
+	}
 
-	return r.WithContext(ctx), 0, nil
+	p := newTokenPrincipal(r.Context(), "jwt", parsed)
+	return withPrincipal(r, p, m.payloadContextKey, msg.Payload()), 0, nil
 }
 
-func (m *VerifyJWT) extractTokenFromHeader(h *http.Header) (string, int, error) {
-	authorizationHeader := h.Get(m.header)
-	if authorizationHeader == "" {
-		return "", 401, errors.New(fmt.Sprintf("Missing %s Header", m.header))
+// verifyOptions returns the keys that may have signed the token: the key with the kid, if the token has one,
+// or else all the keys that can be used with the alg
+func (m *VerifyJWT) verifyOptions(alg jwa.SignatureAlgorithm, kid string) ([]jws.VerifyOption, error) {
+	m.mu.RLock()
+	keys := m.signatureKeys
+	m.mu.RUnlock()
+
+	if kid != "" {
+		key, ok := keys.LookupKeyID(kid)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, kid)
+		}
+		if err := m.keyHandler.Check(key, alg); err != nil {
+			return nil, err
+		}
+		return []jws.VerifyOption{jws.WithKey(alg, key)}, nil
 	}
-	if m.tokenType == "" {
-		return authorizationHeader, 0, nil
+
+	options := []jws.VerifyOption{}
+	var checkErr error
+	for i := 0; i < keys.Len(); i++ {
+		key, _ := keys.Key(i)
+		if err := m.keyHandler.Check(key, alg); err != nil {
+			checkErr = err
+			continue
+		}
+		options = append(options, jws.WithKey(alg, key))
 	}
-	splitHeader := strings.Split(authorizationHeader, m.tokenType)
-	if len(splitHeader) != 2 {
-		return "", 401, errors.New(fmt.Sprintf("Invalid %s Header", m.header))
+	if len(options) == 0 {
+		return nil, checkErr
 	}
-	return strings.TrimSpace(splitHeader[1]), 0, nil
+	return options, nil
 }
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/bancodobrasil/goauth/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFile calls reload every time the file on path is written or replaced,
+// until ctx is done. The parent directory is watched, so the file keeps being
+// watched when it is replaced by a rename. The target of the symbolic links of path
+// is also compared after every event on the directory, so the rotations of the
+// Kubernetes secrets and config maps, which swap the ..data symbolic link, are detected
+func watchFile(ctx context.Context, path string, reload func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	target, _ := filepath.EvalSymlinks(path)
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				changed := filepath.Clean(event.Name) == path && event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename)
+				if current, err := filepath.EvalSymlinks(path); err == nil && current != target {
+					target = current
+					changed = true
+				}
+				if changed {
+					log.Logf(log.Debug, "Reloading %s", path)
+					reload()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Logf(log.Error, "Failed to watch %s: %s", path, err)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitReload waits for a reload, or fails the test
+func waitReload(t *testing.T, reloads <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the file was not reloaded")
+	}
+}
+
+// startWatch watches the file and returns the channel of its reloads
+func startWatch(t *testing.T, path string) <-chan struct{} {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	reloads := make(chan struct{}, 16)
+	if err := watchFile(ctx, path, func() { reloads <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+	return reloads
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchFileWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeFile(t, path, "v1")
+	reloads := startWatch(t, path)
+
+	writeFile(t, path, "v2")
+	waitReload(t, reloads)
+}
+
+func TestWatchFileRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys.json")
+	writeFile(t, path, "v1")
+	reloads := startWatch(t, path)
+
+	writeFile(t, filepath.Join(dir, "keys.json.tmp"), "v2")
+	if err := os.Rename(filepath.Join(dir, "keys.json.tmp"), path); err != nil {
+		t.Fatal(err)
+	}
+	waitReload(t, reloads)
+}
+
+// TestWatchFileKubernetesRotation reproduces the layout of a mounted Kubernetes secret, where the file is a
+// symbolic link to ..data/<file>, and ..data is a symbolic link to a timestamped directory that is swapped on rotation
+func TestWatchFileKubernetesRotation(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "..2024_01_01", "keys.json"), "v1")
+	if err := os.Symlink("..2024_01_01", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "keys.json")
+	if err := os.Symlink(filepath.Join("..data", "keys.json"), path); err != nil {
+		t.Fatal(err)
+	}
+	reloads := startWatch(t, path)
+
+	writeFile(t, filepath.Join(dir, "..2024_01_02", "keys.json"), "v2")
+	if err := os.Symlink("..2024_01_02", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	waitReload(t, reloads)
+
+	if content, err := os.ReadFile(path); err != nil || string(content) != "v2" {
+		t.Fatalf("content = %q, %v, want v2", content, err)
+	}
+}
@@ -0,0 +1,139 @@
+package goauth
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Middleware runs a chain of authentication handlers. Each instance has its own
+// chain, so different route groups can be protected by different handlers
+type Middleware struct {
+	mu         sync.RWMutex
+	handlers   []AuthHandler
+	failClosed bool
+	anonymous  bool
+	responder  ErrorResponder
+}
+
+// Option configures a Middleware
+type Option func(m *Middleware)
+
+// WithHandlers sets the authentication handlers of the middleware, in the order they are executed
+func WithHandlers(handlers ...AuthHandler) Option {
+	return func(m *Middleware) {
+		m.handlers = handlers
+	}
+}
+
+// WithFailClosed makes the middleware abort the request as soon as a handler finds
+// credentials of its kind that are invalid, instead of trying the next handlers.
+// By default the next handlers still run, so invalid credentials of one kind do not
+// abort a request that the credentials of another kind authenticate
+func WithFailClosed(failClosed bool) Option {
+	return func(m *Middleware) {
+		m.failClosed = failClosed
+	}
+}
+
+// WithAnonymous makes the middleware let the request proceed without a principal when all the
+// handlers skip it (i.e. it has no credentials). Requests with invalid credentials are still aborted
+func WithAnonymous(anonymous bool) Option {
+	return func(m *Middleware) {
+		m.anonymous = anonymous
+	}
+}
+
+// WithErrorResponder sets the ErrorResponder used to write the response of aborted requests.
+// Defaults to JSONErrorResponder
+func WithErrorResponder(responder ErrorResponder) Option {
+	return func(m *Middleware) {
+		m.responder = responder
+	}
+}
+
+// New returns a new Middleware instance
+func New(opts ...Option) *Middleware {
+	m := &Middleware{
+		handlers:  []AuthHandler{},
+		responder: JSONErrorResponder{},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Configure applies the options to the middleware.
+// It is safe to call it while the middleware is serving requests
+func (m *Middleware) Configure(opts ...Option) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, opt := range opts {
+		opt(m)
+	}
+}
+
+// ErrorResponder returns the ErrorResponder of the middleware
+func (m *Middleware) ErrorResponder() ErrorResponder {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.responder
+}
+
+// GetHandlers returns the authentication handlers of the middleware
+func (m *Middleware) GetHandlers() []AuthHandler {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.handlers
+}
+
+// SetHandlers replaces the authentication handlers of the middleware.
+// It is safe to call it while the middleware is serving requests
+func (m *Middleware) SetHandlers(handlers []AuthHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = handlers
+}
+
+// Handler executes all the authentication handlers in the order they were added.
+// If any of the handlers does not return an error, the request proceeds to the next handler.
+// If none of the handlers succeeds, the request is aborted with the error of the first handler
+// that denied it or, if all of them were skipped, with the error of the last handler.
+// Use All and AtLeast to require more than one handler to succeed.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := r
+
+		m.mu.RLock()
+		handlers := &chain{handlers: m.handlers, required: 1, failClosed: m.failClosed}
+		responder := m.responder
+		anonymous := m.anonymous
+		m.mu.RUnlock()
+
+		if len(handlers.handlers) > 0 {
+			var err error
+			var statusCode int
+			request, statusCode, err = handlers.Handle(r)
+			if err != nil && anonymous && OutcomeOf(err) == Skipped {
+				request, err = r, nil
+			}
+			if err != nil {
+				responder.Respond(w, r, newAuthMiddlewareError(statusCode, err))
+				return
+			}
+		}
+
+		if next != nil {
+			next.ServeHTTP(w, request)
+		}
+	})
+}
+
+// HandlerFunc is the same as Handler, for frameworks that work with http.HandlerFunc
+func (m *Middleware) HandlerFunc(next http.HandlerFunc) http.HandlerFunc {
+	var h http.Handler
+	if next != nil {
+		h = next
+	}
+	return m.Handler(h).ServeHTTP
+}
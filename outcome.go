@@ -0,0 +1,47 @@
+package goauth
+
+import (
+	"errors"
+
+	"github.com/bancodobrasil/goauth/handler"
+)
+
+// Outcome is the result of an authentication handler
+type Outcome uint8
+
+const (
+	// Authenticated means the request has valid credentials
+	Authenticated Outcome = iota
+	// Skipped means the request has no credentials of the kind verified by the handler
+	Skipped
+	// Denied means the request has credentials of the kind verified by the handler, but they are invalid
+	Denied
+)
+
+// String returns the name of the outcome
+func (o Outcome) String() string {
+	switch o {
+	case Authenticated:
+		return "authenticated"
+	case Skipped:
+		return "skipped"
+	case Denied:
+		return "denied"
+	default:
+		return "unknown"
+	}
+}
+
+// OutcomeOf returns the outcome of the error returned by an AuthHandler.
+// Handlers signal that the request has no credentials of their kind by returning
+// an error that matches handler.ErrNoCredentials (using errors.Is)
+func OutcomeOf(err error) Outcome {
+	switch {
+	case err == nil:
+		return Authenticated
+	case errors.Is(err, handler.ErrNoCredentials):
+		return Skipped
+	default:
+		return Denied
+	}
+}
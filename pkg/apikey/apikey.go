@@ -0,0 +1,134 @@
+// Package apikey stores API keys as salted hashes along with their metadata.
+//
+// An API key has the form <id>.<secret>: the ID is used to look up the record of the key
+// on a Store, and the secret is verified against the salted hash of the record
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by the stores when there is no key with the ID
+	ErrNotFound = errors.New("API key not found")
+	// ErrInvalidHash is returned when the hash of a record is malformed
+	ErrInvalidHash = errors.New("invalid API key hash")
+)
+
+// hashPrefix identifies the format of the hashes: base64 salt and base64 SHA-256 of the salt and the secret
+const hashPrefix = "sha256$"
+
+// saltSize is the size of the salts, in bytes
+const saltSize = 16
+
+// secretSize is the size of the generated secrets, in bytes
+const secretSize = 32
+
+// Record is a stored API key
+type Record struct {
+	// ID identifies the key. It is the part of the key before the first dot
+	ID string `mapstructure:"id" json:"id"`
+	// Owner is the entity the key was issued to. It becomes the subject of the principal
+	Owner string `mapstructure:"owner" json:"owner"`
+	// Scopes are the scopes granted to the key
+	Scopes []string `mapstructure:"scopes" json:"scopes"`
+	// Hash is the salted hash of the secret of the key (see Hash)
+	Hash string `mapstructure:"hash" json:"hash"`
+	// CreatedAt is the time the key was created
+	CreatedAt time.Time `mapstructure:"created_at" json:"created_at"`
+	// ExpiresAt is the time the key expires. The zero value means it never expires
+	ExpiresAt time.Time `mapstructure:"expires_at" json:"expires_at"`
+}
+
+// Expired reports whether the key is expired at the time
+func (r *Record) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// Store looks up the records of the API keys
+type Store interface {
+	// Lookup returns the record of the key with the ID, or an error matching ErrNotFound
+	Lookup(ctx context.Context, id string) (*Record, error)
+}
+
+// Split splits an API key into its ID and secret
+func Split(key string) (id string, secret string, ok bool) {
+	id, secret, ok = strings.Cut(key, ".")
+	if !ok || id == "" || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// Hash returns the salted hash of the secret, in the sha256$<salt>$<hash> format
+func Hash(secret string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hashPrefix + base64.RawStdEncoding.EncodeToString(salt) + "$" + base64.RawStdEncoding.EncodeToString(digest(salt, secret)), nil
+}
+
+// Verify reports whether the secret matches the hash. The comparison takes constant time
+func Verify(hash string, secret string) (bool, error) {
+	if !strings.HasPrefix(hash, hashPrefix) {
+		return false, ErrInvalidHash
+	}
+	encoded := strings.TrimPrefix(hash, hashPrefix)
+	encodedSalt, encodedDigest, ok := strings.Cut(encoded, "$")
+	if !ok {
+		return false, ErrInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrInvalidHash, err)
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(encodedDigest)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrInvalidHash, err)
+	}
+	return subtle.ConstantTimeCompare(digest(salt, secret), expected) == 1, nil
+}
+
+// Generate returns a new API key with the ID and its record, which is valid for ttl (or forever, if ttl is zero)
+func Generate(id string, owner string, scopes []string, ttl time.Duration) (string, *Record, error) {
+	if id == "" || strings.Contains(id, ".") {
+		return "", nil, fmt.Errorf("invalid API key ID %q", id)
+	}
+	raw := make([]byte, secretSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(raw)
+	hash, err := Hash(secret)
+	if err != nil {
+		return "", nil, err
+	}
+	record := &Record{
+		ID:        id,
+		Owner:     owner,
+		Scopes:    scopes,
+		Hash:      hash,
+		CreatedAt: time.Now().UTC(),
+	}
+	if ttl > 0 {
+		record.ExpiresAt = record.CreatedAt.Add(ttl)
+	}
+	return id + "." + secret, record, nil
+}
+
+// digest returns the SHA-256 of the salt and the secret
+func digest(salt []byte, secret string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(secret))
+	return h.Sum(nil)
+}
@@ -0,0 +1,144 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// MemoryStore is a Store that keeps the records in memory. The zero value is an empty store
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[string]*Record
+}
+
+// NewMemoryStore returns a MemoryStore with the records
+func NewMemoryStore(records ...*Record) *MemoryStore {
+	s := &MemoryStore{}
+	s.Replace(records)
+	return s
+}
+
+// Lookup implements the Store interface
+func (s *MemoryStore) Lookup(ctx context.Context, id string) (*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, ok := s.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+// Add adds the record to the store, replacing the record with the same ID
+func (s *MemoryStore) Add(record *Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records == nil {
+		s.records = map[string]*Record{}
+	}
+	s.records[record.ID] = record
+}
+
+// Replace replaces all the records of the store
+func (s *MemoryStore) Replace(records []*Record) {
+	byID := make(map[string]*Record, len(records))
+	for _, record := range records {
+		byID[record.ID] = record
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = byID
+}
+
+// FileStore is a Store that loads the records from the keys list of a JSON or YAML file
+type FileStore struct {
+	*MemoryStore
+	path string
+}
+
+// NewFileStore returns a FileStore with the records of the file
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path returns the path of the file
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+// Reload replaces the records with the records of the file
+func (s *FileStore) Reload() error {
+	v := viper.New()
+	v.SetConfigFile(s.path)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	records := []*Record{}
+	decodeHook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeHookFunc(time.RFC3339),
+		mapstructure.StringToSliceHookFunc(","),
+	))
+	if err := v.UnmarshalKey("keys", &records, decodeHook); err != nil {
+		return err
+	}
+	for i, record := range records {
+		if record.ID == "" || record.Hash == "" {
+			return fmt.Errorf("missing id or hash on API key #%d of %s", i, s.path)
+		}
+	}
+	s.Replace(records)
+	return nil
+}
+
+// DefaultQuery is the default query of the SQLStore. The scopes are separated by spaces
+const DefaultQuery = "SELECT id, owner, scopes, hash, created_at, expires_at FROM api_keys WHERE id = ?"
+
+// SQLStore is a Store that looks up the records on a database
+type SQLStore struct {
+	db    *sql.DB
+	query string
+}
+
+// NewSQLStore returns a SQLStore. The query must select the id, owner, scopes (separated by spaces),
+// hash, created_at and expires_at (which may be null) of the key with the ID given as its only argument.
+// If query is empty, DefaultQuery is used
+func NewSQLStore(db *sql.DB, query string) *SQLStore {
+	if query == "" {
+		query = DefaultQuery
+	}
+	return &SQLStore{db: db, query: query}
+}
+
+// Lookup implements the Store interface
+func (s *SQLStore) Lookup(ctx context.Context, id string) (*Record, error) {
+	var record Record
+	var owner, scopes sql.NullString
+	var createdAt, expiresAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, s.query, id).Scan(&record.ID, &owner, &scopes, &record.Hash, &createdAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	record.Owner = owner.String
+	record.Scopes = strings.Fields(scopes.String)
+	if createdAt.Valid {
+		record.CreatedAt = createdAt.Time
+	}
+	if expiresAt.Valid {
+		record.ExpiresAt = expiresAt.Time
+	}
+	return &record, nil
+}
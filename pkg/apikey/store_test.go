@@ -0,0 +1,27 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryStoreZeroValue(t *testing.T) {
+	var s MemoryStore
+	ctx := context.Background()
+
+	if _, err := s.Lookup(ctx, "k1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Lookup() error = %v, want %v", err, ErrNotFound)
+	}
+
+	s.Add(&Record{ID: "k1", Owner: "billing"})
+	record, err := s.Lookup(ctx, "k1")
+	if err != nil || record.Owner != "billing" {
+		t.Fatalf("Lookup() = %+v, %v, want the added record", record, err)
+	}
+
+	s.Add(&Record{ID: "k1", Owner: "reports"})
+	if record, _ := s.Lookup(ctx, "k1"); record.Owner != "reports" {
+		t.Fatalf("Lookup() = %+v, want the replacing record", record)
+	}
+}
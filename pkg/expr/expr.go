@@ -0,0 +1,496 @@
+// Package expr implements a small expression language, with a syntax similar to the
+// Common Expression Language (CEL), to write access rules over request attributes, e.g.:
+//
+//	"admin" in principal.roles || (request.method == "GET" && principal.claims.tenant == request.params.tenant)
+//
+// The values are null, booleans, numbers (float64), strings, lists and maps. Selecting a missing
+// field or key yields null, so has(principal.claims.tenant) checks whether a claim is present.
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Expression is a compiled expression
+type Expression struct {
+	source string
+	root   node
+}
+
+// Compile parses the source of an expression
+func Compile(source string) (*Expression, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parse(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if err := check(root); err != nil {
+		return nil, err
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression with the variables
+func (e *Expression) Eval(vars map[string]any) (any, error) {
+	return eval(e.root, vars)
+}
+
+// EvalBool evaluates the expression with the variables. The result must be a boolean
+func (e *Expression) EvalBool(vars map[string]any) (bool, error) {
+	value, err := e.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q returned %s, not a boolean", e.source, typeName(value))
+	}
+	return result, nil
+}
+
+// functions are the functions of the language, by name, with their number of arguments.
+// Methods are called with the target as the first argument (e.g. s.startsWith("a") is startsWith(s, "a"))
+var functions = map[string]struct {
+	args int
+	call func(args []any) (any, error)
+}{
+	"has":        {1, func(args []any) (any, error) { return args[0] != nil, nil }},
+	"size":       {1, size},
+	"startsWith": {2, stringFunc(strings.HasPrefix)},
+	"endsWith":   {2, stringFunc(strings.HasSuffix)},
+	"contains":   {2, containsFunc},
+	"matches":    {2, matches},
+	"lower":      {1, func(args []any) (any, error) { return strings.ToLower(toString(args[0])), nil }},
+	"upper":      {1, func(args []any) (any, error) { return strings.ToUpper(toString(args[0])), nil }},
+	"inCIDR":     {2, inCIDR},
+}
+
+// check returns an error if the tree calls unknown functions or has the wrong number of arguments,
+// and compiles the literal patterns of matches, so they are not compiled on every evaluation
+func check(n node) error {
+	switch n := n.(type) {
+	case *memberNode:
+		return check(n.target)
+	case *indexNode:
+		if err := check(n.target); err != nil {
+			return err
+		}
+		return check(n.index)
+	case *listNode:
+		for _, item := range n.items {
+			if err := check(item); err != nil {
+				return err
+			}
+		}
+	case *unaryNode:
+		return check(n.operand)
+	case *binaryNode:
+		if err := check(n.left); err != nil {
+			return err
+		}
+		return check(n.right)
+	case *callNode:
+		f, ok := functions[n.function]
+		if !ok {
+			return fmt.Errorf("unknown function %s", n.function)
+		}
+		args := len(n.args)
+		if n.target != nil {
+			args++
+			if err := check(n.target); err != nil {
+				return err
+			}
+		}
+		if args != f.args {
+			return fmt.Errorf("function %s takes %d arguments, got %d", n.function, f.args, args)
+		}
+		for _, arg := range n.args {
+			if err := check(arg); err != nil {
+				return err
+			}
+		}
+		if n.function == "matches" {
+			if literal, ok := n.args[len(n.args)-1].(*literalNode); ok {
+				pattern, err := regexp.Compile(toString(literal.value))
+				if err != nil {
+					return err
+				}
+				n.pattern = pattern
+			}
+		}
+	}
+	return nil
+}
+
+// eval evaluates the node
+func eval(n node, vars map[string]any) (any, error) {
+	switch n := n.(type) {
+	case *literalNode:
+		return n.value, nil
+	case *identNode:
+		return normalize(vars[n.name]), nil
+	case *memberNode:
+		target, err := eval(n.target, vars)
+		if err != nil {
+			return nil, err
+		}
+		return selectKey(target, n.field), nil
+	case *indexNode:
+		target, err := eval(n.target, vars)
+		if err != nil {
+			return nil, err
+		}
+		index, err := eval(n.index, vars)
+		if err != nil {
+			return nil, err
+		}
+		if list, ok := target.([]any); ok {
+			i, ok := index.(float64)
+			if !ok || i != float64(int(i)) {
+				return nil, fmt.Errorf("invalid list index %v", index)
+			}
+			if int(i) < 0 || int(i) >= len(list) {
+				return nil, nil
+			}
+			return list[int(i)], nil
+		}
+		return selectKey(target, toString(index)), nil
+	case *listNode:
+		items := make([]any, 0, len(n.items))
+		for _, item := range n.items {
+			value, err := eval(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+	case *unaryNode:
+		operand, err := eval(n.operand, vars)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "!" {
+			b, ok := operand.(bool)
+			if !ok {
+				return nil, fmt.Errorf("operator ! requires a boolean, got %s", typeName(operand))
+			}
+			return !b, nil
+		}
+		f, ok := operand.(float64)
+		if !ok {
+			return nil, fmt.Errorf("operator - requires a number, got %s", typeName(operand))
+		}
+		return -f, nil
+	case *binaryNode:
+		return evalBinary(n, vars)
+	case *callNode:
+		args := make([]any, 0, len(n.args)+1)
+		if n.target != nil {
+			target, err := eval(n.target, vars)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, target)
+		}
+		for _, arg := range n.args {
+			value, err := eval(arg, vars)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, value)
+		}
+		if n.pattern != nil {
+			return matchString(n.pattern, args[0]), nil
+		}
+		return functions[n.function].call(args)
+	default:
+		return nil, fmt.Errorf("invalid expression node %T", n)
+	}
+}
+
+// evalBinary evaluates a binary operation. && and || short-circuit
+func evalBinary(n *binaryNode, vars map[string]any) (any, error) {
+	left, err := eval(n.left, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s requires booleans, got %s", n.op, typeName(left))
+		}
+		if (n.op == "&&" && !l) || (n.op == "||" && l) {
+			return l, nil
+		}
+		right, err := eval(n.right, vars)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s requires booleans, got %s", n.op, typeName(right))
+		}
+		return r, nil
+	}
+
+	right, err := eval(n.right, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		switch r := right.(type) {
+		case []any:
+			for _, item := range r {
+				if equal(left, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]any:
+			_, ok := r[toString(left)]
+			return ok, nil
+		case nil:
+			return false, nil
+		default:
+			return nil, fmt.Errorf("operator in requires a list or a map, got %s", typeName(right))
+		}
+	case "+":
+		if l, ok := left.(string); ok {
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		}
+		l, r, err := numbers(n.op, left, right)
+		if err != nil {
+			return nil, err
+		}
+		return l + r, nil
+	case "-":
+		l, r, err := numbers(n.op, left, right)
+		if err != nil {
+			return nil, err
+		}
+		return l - r, nil
+	default:
+		return compare(n.op, left, right)
+	}
+}
+
+// compare evaluates the <, <=, > and >= operators on numbers or strings
+func compare(op string, left, right any) (any, error) {
+	var c int
+	if l, ok := left.(string); ok {
+		r, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("operator %s can not compare string and %s", op, typeName(right))
+		}
+		c = strings.Compare(l, r)
+	} else {
+		l, r, err := numbers(op, left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case l < r:
+			c = -1
+		case l > r:
+			c = 1
+		}
+	}
+	switch op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+// numbers returns the operands of an arithmetic or comparison operator, which must be numbers
+func numbers(op string, left, right any) (float64, float64, error) {
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return 0, 0, fmt.Errorf("operator %s requires numbers, got %s and %s", op, typeName(left), typeName(right))
+	}
+	return l, r, nil
+}
+
+// equal reports whether the values are equal
+func equal(left, right any) bool {
+	return reflect.DeepEqual(left, right)
+}
+
+// selectKey returns the value of the key of a map, or null
+func selectKey(target any, key string) any {
+	m, ok := target.(map[string]any)
+	if !ok {
+		return nil
+	}
+	return normalize(m[key])
+}
+
+// normalize converts the values to the types of the language
+func normalize(value any) any {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return v.String()
+		}
+		return f
+	case []string:
+		items := make([]any, 0, len(v))
+		for _, item := range v {
+			items = append(items, item)
+		}
+		return items
+	case []any:
+		items := make([]any, 0, len(v))
+		for _, item := range v {
+			items = append(items, normalize(item))
+		}
+		return items
+	case map[string]string:
+		m := make(map[string]any, len(v))
+		for key, item := range v {
+			m[key] = item
+		}
+		return m
+	default:
+		return value
+	}
+}
+
+// toString returns the string representation of the value
+func toString(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// typeName returns the name of the type of the value in the language
+func typeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "map"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func size(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case string:
+		return float64(len(v)), nil
+	case []any:
+		return float64(len(v)), nil
+	case map[string]any:
+		return float64(len(v)), nil
+	case nil:
+		return float64(0), nil
+	default:
+		return nil, fmt.Errorf("size of %s", typeName(v))
+	}
+}
+
+// stringFunc returns a function of two strings
+func stringFunc(f func(s, arg string) bool) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return false, nil
+		}
+		return f(s, toString(args[1])), nil
+	}
+}
+
+// containsFunc checks whether a string has a substring or a list has an item
+func containsFunc(args []any) (any, error) {
+	if list, ok := args[0].([]any); ok {
+		for _, item := range list {
+			if equal(item, args[1]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return stringFunc(strings.Contains)(args)
+}
+
+// matches compiles the pattern on every call. The literal patterns are compiled once, by Compile,
+// and the patterns built from the variables are not cached, so requests can not grow a cache
+func matches(args []any) (any, error) {
+	pattern, err := regexp.Compile(toString(args[1]))
+	if err != nil {
+		return nil, err
+	}
+	return matchString(pattern, args[0]), nil
+}
+
+// matchString reports whether the value is a string matched by the pattern
+func matchString(pattern *regexp.Regexp, value any) bool {
+	s, ok := value.(string)
+	return ok && pattern.MatchString(s)
+}
+
+func inCIDR(args []any) (any, error) {
+	_, network, err := net.ParseCIDR(toString(args[1]))
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(toString(args[0]))
+	return ip != nil && network.Contains(ip), nil
+}
@@ -0,0 +1,62 @@
+package expr
+
+import "testing"
+
+func TestMatches(t *testing.T) {
+	vars := map[string]any{
+		"request": map[string]any{"path": "/tenants/42/orders", "pattern": "^/tenants/[0-9]+/"},
+		"code":    float64(42),
+	}
+
+	tests := []struct {
+		name    string
+		source  string
+		want    bool
+		wantErr bool
+	}{
+		{name: "literal pattern", source: `request.path.matches("^/tenants/[0-9]+/orders$")`, want: true},
+		{name: "literal pattern as function", source: `matches(request.path, "^/admin")`, want: false},
+		{name: "pattern from the variables", source: `request.path.matches(request.pattern)`, want: true},
+		{name: "pattern built from the variables", source: `request.path.matches("/" + request.path)`, want: false},
+		{name: "not a string", source: `code.matches("42")`, want: false},
+		{name: "missing value", source: `request.missing.matches(".*")`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Compile(tt.source)
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			got, err := e.EvalBool(vars)
+			if err != nil {
+				t.Fatalf("EvalBool() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("EvalBool() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesPrecompilesLiteralPatterns(t *testing.T) {
+	if _, err := Compile(`request.path.matches("[")`); err == nil {
+		t.Fatal("Compile() accepted an invalid literal pattern")
+	}
+
+	e, err := Compile(`request.path.matches(request.pattern)`)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	if _, err := e.Eval(map[string]any{"request": map[string]any{"path": "/", "pattern": "["}}); err == nil {
+		t.Fatal("Eval() accepted an invalid pattern from the variables")
+	}
+
+	e, err = Compile(`request.path.matches("^/a")`)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	if call := e.root.(*callNode); call.pattern == nil {
+		t.Fatal("Compile() did not compile the literal pattern")
+	}
+}
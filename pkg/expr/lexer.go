@@ -0,0 +1,95 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenKind is the kind of a token of an expression
+type tokenKind uint8
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+// token is a lexical token of an expression
+type token struct {
+	kind  tokenKind
+	text  string
+	value any
+	pos   int
+}
+
+// operators are the operators and punctuation of the language, longest first
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "(", ")", "[", "]", ",", "."}
+
+// lex splits the source of an expression into tokens
+func lex(src string) ([]token, error) {
+	tokens := []token{}
+	for pos := 0; pos < len(src); {
+		c := rune(src[pos])
+		switch {
+		case unicode.IsSpace(c):
+			pos++
+		case c == '"' || c == '\'':
+			end := pos + 1
+			for end < len(src) && src[end] != src[pos] {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", pos)
+			}
+			raw := src[pos : end+1]
+			if c == '\'' {
+				raw = `"` + strings.ReplaceAll(strings.ReplaceAll(raw[1:len(raw)-1], `"`, `\"`), `\'`, `'`) + `"`
+			}
+			value, err := strconv.Unquote(raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %w", pos, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: src[pos : end+1], value: value, pos: pos})
+			pos = end + 1
+		case unicode.IsDigit(c):
+			end := pos
+			for end < len(src) && (unicode.IsDigit(rune(src[end])) || src[end] == '.') {
+				end++
+			}
+			value, err := strconv.ParseFloat(src[pos:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number at %d: %w", pos, err)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: src[pos:end], value: value, pos: pos})
+			pos = end
+		case unicode.IsLetter(c) || c == '_':
+			end := pos
+			for end < len(src) && (unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end])) || src[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: src[pos:end], pos: pos})
+			pos = end
+		default:
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[pos:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op, pos: pos})
+					pos += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, pos)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(src)}), nil
+}
@@ -0,0 +1,264 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// node is a node of the syntax tree of an expression
+type node interface{}
+
+type (
+	// literalNode is a string, number, boolean or null literal
+	literalNode struct{ value any }
+	// identNode is a variable
+	identNode struct{ name string }
+	// memberNode is a field selection (e.g. principal.claims)
+	memberNode struct {
+		target node
+		field  string
+	}
+	// indexNode is an index or key selection (e.g. request.headers["x-tenant"])
+	indexNode struct{ target, index node }
+	// listNode is a list literal
+	listNode struct{ items []node }
+	// unaryNode is a ! or - operation
+	unaryNode struct {
+		op      string
+		operand node
+	}
+	// binaryNode is a binary operation
+	binaryNode struct {
+		op          string
+		left, right node
+	}
+	// callNode is a function call or, if target is not nil, a method call
+	callNode struct {
+		target   node
+		function string
+		args     []node
+		// pattern is the compiled literal pattern of a matches call
+		pattern *regexp.Regexp
+	}
+)
+
+// parser is a recursive descent parser of expressions
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parse parses the tokens of an expression
+func parse(tokens []token) (node, error) {
+	p := &parser{tokens: tokens}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of the operators or keywords
+func (p *parser) accept(texts ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator && t.kind != tokenIdent {
+		return "", false
+	}
+	for _, text := range texts {
+		if t.text == text {
+			p.pos++
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(text string) error {
+	if _, ok := p.accept(text); !ok {
+		t := p.peek()
+		return fmt.Errorf("expected %q at %d, found %q", text, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *parser) or() (node, error) {
+	return p.binary(p.and, "||")
+}
+
+func (p *parser) and() (node, error) {
+	return p.binary(p.comparison, "&&")
+}
+
+func (p *parser) comparison() (node, error) {
+	left, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.accept("==", "!=", "<=", ">=", "<", ">", "in"); ok {
+		right, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) additive() (node, error) {
+	return p.binary(p.unary, "+", "-")
+}
+
+// binary parses a left-associative sequence of operations with the same precedence
+func (p *parser) binary(operand func() (node, error), ops ...string) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	if op, ok := p.accept("!", "-"); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (node, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("."); ok {
+			t := p.next()
+			if t.kind != tokenIdent {
+				return nil, fmt.Errorf("expected field name at %d", t.pos)
+			}
+			if _, ok := p.accept("("); ok {
+				args, err := p.args()
+				if err != nil {
+					return nil, err
+				}
+				n = &callNode{target: n, function: t.text, args: args}
+				continue
+			}
+			n = &memberNode{target: n, field: t.text}
+			continue
+		}
+		if _, ok := p.accept("["); ok {
+			index, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{target: n, index: index}
+			continue
+		}
+		return n, nil
+	}
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString, tokenNumber:
+		return &literalNode{value: t.value}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if _, ok := p.accept("("); ok {
+			args, err := p.args()
+			if err != nil {
+				return nil, err
+			}
+			return &callNode{function: t.text, args: args}, nil
+		}
+		return &identNode{name: t.text}, nil
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			n, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			items := []node{}
+			if _, ok := p.accept("]"); ok {
+				return &listNode{items: items}, nil
+			}
+			for {
+				item, err := p.or()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+				if _, ok := p.accept(","); !ok {
+					break
+				}
+			}
+			return &listNode{items: items}, p.expect("]")
+		}
+	}
+	if t.kind == tokenEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+// args parses the arguments of a call, after the opening parenthesis
+func (p *parser) args() ([]node, error) {
+	args := []node{}
+	if _, ok := p.accept(")"); ok {
+		return args, nil
+	}
+	for {
+		arg, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if _, ok := p.accept(","); !ok {
+			break
+		}
+	}
+	return args, p.expect(")")
+}
@@ -0,0 +1,167 @@
+package htpasswd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnsupportedHash is returned when the format of a password hash is not supported
+var ErrUnsupportedHash = errors.New("unsupported password hash")
+
+// Parse reads the users from an Apache htpasswd file, with one user:hash entry per line.
+// Empty lines and lines starting with # are ignored
+func Parse(r io.Reader) (map[string]string, error) {
+	users := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(entry, ":")
+		if !ok || user == "" || hash == "" {
+			return nil, fmt.Errorf("invalid htpasswd entry on line %d", line)
+		}
+		if !Supported(hash) {
+			return nil, fmt.Errorf("%w for user %s on line %d", ErrUnsupportedHash, user, line)
+		}
+		users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Load reads the users from the htpasswd file on path
+func Load(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Supported reports whether the format of the hash is supported:
+// bcrypt ($2a$, $2b$, $2y$), SHA-256 crypt ($5$), SHA-512 crypt ($6$) and argon2id ($argon2id$)
+func Supported(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "$5$", "$6$", "$argon2id$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify reports whether the password matches the hash
+func Verify(hash, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(hash, "$5$"):
+		return verifyCrypt(hash, password, sha256Crypt)
+	case strings.HasPrefix(hash, "$6$"):
+		return verifyCrypt(hash, password, sha512Crypt)
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return verifyArgon2id(hash, password)
+	default:
+		return false, ErrUnsupportedHash
+	}
+}
+
+// verifyCrypt computes the crypt hash of the password with the same settings of the hash and compares them
+func verifyCrypt(hash, password string, c *shaCrypt) (bool, error) {
+	computed, err := c.crypt([]byte(password), hash)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1, nil
+}
+
+// verifyArgon2id verifies an argon2id hash in the PHC string format:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+func verifyArgon2id(hash, password string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var memory, time uint32
+	var threads uint8
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return false, fmt.Errorf("invalid argon2id parameter %s", param)
+		}
+		switch name {
+		case "m":
+			memory = uint32(n)
+		case "t":
+			time = uint32(n)
+		case "p":
+			if n > 255 {
+				return false, fmt.Errorf("invalid argon2id parameter %s", param)
+			}
+			threads = uint8(n)
+		}
+	}
+	if memory == 0 || time == 0 || threads == 0 {
+		return false, fmt.Errorf("invalid argon2id hash")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(expected)))
+	return subtle.ConstantTimeCompare(computed, expected) == 1, nil
+}
+
+var (
+	sha256Crypt = &shaCrypt{
+		prefix: "$5$",
+		new:    sha256.New,
+		order: [][3]int{
+			{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+			{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+		},
+		tail: []int{31, 30},
+	}
+	sha512Crypt = &shaCrypt{
+		prefix: "$6$",
+		new:    sha512.New,
+		order: [][3]int{
+			{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+			{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+			{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+			{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+			{62, 20, 41},
+		},
+		tail: []int{63},
+	}
+)
@@ -0,0 +1,248 @@
+package htpasswd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// shaCryptVectors are the test vectors of https://www.akkadia.org/drepper/SHA-crypt.txt
+var shaCryptVectors = []struct {
+	setting  string
+	password string
+	want     string
+}{
+	{
+		setting:  "$5$saltstring",
+		password: "Hello world!",
+		want:     "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+	},
+	{
+		setting:  "$5$rounds=10000$saltstringsaltstring",
+		password: "Hello world!",
+		want:     "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA",
+	},
+	{
+		setting:  "$5$rounds=5000$toolongsaltstring",
+		password: "This is just a test",
+		want:     "$5$rounds=5000$toolongsaltstrin$Un/5jzAHMgOGZ5.mWJpuVolil07guHPvOW8mGRcvxa5",
+	},
+	{
+		setting:  "$5$rounds=1400$anotherlongsaltstring",
+		password: "a very much longer text to encrypt.  This one even stretches over morethan one line.",
+		want:     "$5$rounds=1400$anotherlongsalts$Rx.j8H.h8HjEDGomFU8bDkXm3XIUnzyxf12oP84Bnq1",
+	},
+	{
+		setting:  "$5$rounds=77777$short",
+		password: "we have a short salt string but not a short password",
+		want:     "$5$rounds=77777$short$JiO1O3ZpDAxGJeaDIuqCoEFysAe1mZNJRs3pw0KQRd/",
+	},
+	{
+		setting:  "$5$rounds=10$roundstoolow",
+		password: "the minimum number is still observed",
+		want:     "$5$rounds=1000$roundstoolow$yfvwcWrQ8l/K0DAWyuPMDNHpIVlTQebY9l/gL972bIC",
+	},
+	{
+		setting:  "$6$saltstring",
+		password: "Hello world!",
+		want:     "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+	},
+	{
+		setting:  "$6$rounds=10000$saltstringsaltstring",
+		password: "Hello world!",
+		want:     "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.",
+	},
+	{
+		setting:  "$6$rounds=5000$toolongsaltstring",
+		password: "This is just a test",
+		want:     "$6$rounds=5000$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0",
+	},
+	{
+		setting:  "$6$rounds=1400$anotherlongsaltstring",
+		password: "a very much longer text to encrypt.  This one even stretches over morethan one line.",
+		want:     "$6$rounds=1400$anotherlongsalts$POfYwTEok97VWcjxIiSOjiykti.o/pQs.wPvMxQ6Fm7I6IoYN3CmLs66x9t0oSwbtEW7o7UmJEiDwGqd8p4ur1",
+	},
+	{
+		setting:  "$6$rounds=77777$short",
+		password: "we have a short salt string but not a short password",
+		want:     "$6$rounds=77777$short$WuQyW2YR.hBNpjjRhpYD/ifIw05xdfeEyQoMxIXbkvr0gge1a1x3yRULJ5CCaUeOxFmtlcGZelFl5CxtgfiAc0",
+	},
+	{
+		setting:  "$6$rounds=10$roundstoolow",
+		password: "the minimum number is still observed",
+		want:     "$6$rounds=1000$roundstoolow$kUMsbe306n21p9R.FRkW3IGn.S9NPN0x50YhH1xhLsPuWGsUSklZt58jaTfF4ZEQpyUNGc0dqbpBYYBaHHrsX.",
+	},
+}
+
+func TestShaCrypt(t *testing.T) {
+	for _, tt := range shaCryptVectors {
+		c := sha256Crypt
+		if strings.HasPrefix(tt.setting, "$6$") {
+			c = sha512Crypt
+		}
+		got, err := c.crypt([]byte(tt.password), tt.setting)
+		if err != nil {
+			t.Fatalf("crypt(%q) error = %v", tt.setting, err)
+		}
+		if got != tt.want {
+			t.Errorf("crypt(%q) = %s, want %s", tt.setting, got, tt.want)
+		}
+	}
+}
+
+func TestShaCryptInvalidSettings(t *testing.T) {
+	for _, setting := range []string{"$1$saltstring", "$5$rounds=many$saltstring", "$5$rounds=1000"} {
+		if _, err := sha256Crypt.crypt([]byte("password"), setting); err == nil {
+			t.Errorf("crypt(%q) accepted an invalid setting", setting)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		want     bool
+		wantErr  bool
+	}{
+		{name: "bcrypt", hash: string(bcryptHash), password: "secret", want: true},
+		{name: "bcrypt wrong password", hash: string(bcryptHash), password: "Secret"},
+		{name: "bcrypt invalid hash", hash: "$2y$10$short", password: "secret", wantErr: true},
+		{name: "SHA-256 crypt", hash: shaCryptVectors[1].want, password: "Hello world!", want: true},
+		{name: "SHA-256 crypt wrong password", hash: shaCryptVectors[1].want, password: "Hello world"},
+		{name: "SHA-512 crypt", hash: shaCryptVectors[6].want, password: "Hello world!", want: true},
+		{name: "SHA-512 crypt wrong password", hash: shaCryptVectors[6].want, password: "hello world!"},
+		{
+			name:     "argon2id",
+			hash:     "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+			password: "password",
+			want:     true,
+		},
+		{
+			name:     "argon2id wrong password",
+			hash:     "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+			password: "Password",
+		},
+		{
+			name:     "argon2id other version",
+			hash:     "$argon2id$v=16$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+			password: "password",
+			wantErr:  true,
+		},
+		{
+			name:     "argon2id missing parameter",
+			hash:     "$argon2id$v=19$m=65536,t=2$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+			password: "password",
+			wantErr:  true,
+		},
+		{
+			name:     "argon2id invalid salt",
+			hash:     "$argon2id$v=19$m=65536,t=2,p=1$not*base64$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+			password: "password",
+			wantErr:  true,
+		},
+		{name: "unsupported", hash: "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", password: "secret", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Verify(tt.hash, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("Verify() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Verify("$apr1$salt$hash", "secret"); !errors.Is(err, ErrUnsupportedHash) {
+		t.Fatalf("Verify() error = %v, want %v", err, ErrUnsupportedHash)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		want        map[string]string
+		wantErr     string
+		unsupported bool
+	}{
+		{
+			name: "entries",
+			input: "# users\n\n" +
+				"alice:$2y$05$abcdefghijklmnopqrstuu5Fo9TiNdTdXh2K6C4Xc1M1pDv3YdJlq\n" +
+				"  bob:$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5  \n" +
+				"   # indented comment\n" +
+				"carol:$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc\n",
+			want: map[string]string{
+				"alice": "$2y$05$abcdefghijklmnopqrstuu5Fo9TiNdTdXh2K6C4Xc1M1pDv3YdJlq",
+				"bob":   "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+				"carol": "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+			},
+		},
+		{name: "empty", input: "\n# nobody\n", want: map[string]string{}},
+		{name: "without separator", input: "# users\nalice\n", wantErr: "line 2"},
+		{name: "without user", input: ":$5$saltstring$hash\n", wantErr: "line 1"},
+		{name: "without hash", input: "alice:$5$saltstring$hash\nbob:\n", wantErr: "line 2"},
+		{name: "SHA1 scheme", input: "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n", wantErr: "alice on line 1", unsupported: true},
+		{name: "APR1 scheme", input: "alice:$apr1$salt$hash\n", wantErr: "alice on line 1", unsupported: true},
+		{name: "plain text", input: "alice:secret\n", wantErr: "alice on line 1", unsupported: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := Parse(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				if errors.Is(err, ErrUnsupportedHash) != tt.unsupported {
+					t.Fatalf("Parse() error = %v, unsupported %t", err, tt.unsupported)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if len(users) != len(tt.want) {
+				t.Fatalf("Parse() = %v, want %v", users, tt.want)
+			}
+			for user, hash := range tt.want {
+				if users[user] != hash {
+					t.Fatalf("Parse()[%s] = %q, want %q", user, users[user], hash)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	if err := os.WriteFile(path, []byte("bob:"+shaCryptVectors[0].want+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := Verify(users["bob"], "Hello world!"); !ok || err != nil {
+		t.Fatalf("Verify() = %t, %v, want true", ok, err)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("Load() accepted a missing file")
+	}
+}
@@ -0,0 +1,168 @@
+package htpasswd
+
+import (
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// Implementation of the SHA-256 and SHA-512 crypt schemes,
+// as specified on https://www.akkadia.org/drepper/SHA-crypt.txt
+
+const (
+	cryptAlphabet      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	cryptDefaultRounds = 5000
+	cryptMinRounds     = 1000
+	cryptMaxRounds     = 999999999
+	cryptMaxSaltLength = 16
+	cryptRoundsPrefix  = "rounds="
+)
+
+// shaCrypt is the specification of one of the SHA crypt schemes
+type shaCrypt struct {
+	prefix string
+	new    func() hash.Hash
+	// order is the order the bytes of the digest are encoded, in groups of three
+	order [][3]int
+	// tail is the remaining bytes of the digest, encoded after the groups
+	tail []int
+}
+
+// crypt returns the hash of the password using the prefix, rounds and salt of setting
+func (c *shaCrypt) crypt(password []byte, setting string) (string, error) {
+	if !strings.HasPrefix(setting, c.prefix) {
+		return "", fmt.Errorf("invalid crypt setting")
+	}
+	setting = strings.TrimPrefix(setting, c.prefix)
+
+	rounds := cryptDefaultRounds
+	customRounds := false
+	if strings.HasPrefix(setting, cryptRoundsPrefix) {
+		value, rest, ok := strings.Cut(strings.TrimPrefix(setting, cryptRoundsPrefix), "$")
+		n, err := strconv.Atoi(value)
+		if !ok || err != nil {
+			return "", fmt.Errorf("invalid crypt rounds")
+		}
+		rounds, customRounds, setting = clampRounds(n), true, rest
+	}
+
+	salt, _, _ := strings.Cut(setting, "$")
+	if len(salt) > cryptMaxSaltLength {
+		salt = salt[:cryptMaxSaltLength]
+	}
+
+	digest := c.digest(password, []byte(salt), rounds)
+
+	var b strings.Builder
+	b.WriteString(c.prefix)
+	if customRounds {
+		b.WriteString(cryptRoundsPrefix + strconv.Itoa(rounds) + "$")
+	}
+	b.WriteString(salt)
+	b.WriteString("$")
+	for _, group := range c.order {
+		encode24(&b, uint(digest[group[0]])<<16|uint(digest[group[1]])<<8|uint(digest[group[2]]), 4)
+	}
+	var w uint
+	for _, i := range c.tail {
+		w = w<<8 | uint(digest[i])
+	}
+	encode24(&b, w, len(c.tail)+1)
+
+	return b.String(), nil
+}
+
+// digest runs the rounds of the algorithm and returns the final digest
+func (c *shaCrypt) digest(password, salt []byte, rounds int) []byte {
+	h := c.new()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(password)
+	b := h.Sum(nil)
+
+	h.Reset()
+	h.Write(password)
+	h.Write(salt)
+	for n := len(password); n > 0; n -= len(b) {
+		if n > len(b) {
+			h.Write(b)
+		} else {
+			h.Write(b[:n])
+		}
+	}
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(password)
+		}
+	}
+	a := h.Sum(nil)
+
+	h.Reset()
+	for i := 0; i < len(password); i++ {
+		h.Write(password)
+	}
+	p := repeat(h.Sum(nil), len(password))
+
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(salt)
+	}
+	s := repeat(h.Sum(nil), len(salt))
+
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if i%2 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(a)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i%2 != 0 {
+			h.Write(a)
+		} else {
+			h.Write(p)
+		}
+		a = h.Sum(a[:0])
+	}
+
+	return a
+}
+
+// repeat returns a sequence of length n made of copies of b
+func repeat(b []byte, n int) []byte {
+	seq := make([]byte, 0, n)
+	for len(seq) < n {
+		remaining := n - len(seq)
+		if remaining > len(b) {
+			remaining = len(b)
+		}
+		seq = append(seq, b[:remaining]...)
+	}
+	return seq
+}
+
+func clampRounds(n int) int {
+	if n < cryptMinRounds {
+		return cryptMinRounds
+	}
+	if n > cryptMaxRounds {
+		return cryptMaxRounds
+	}
+	return n
+}
+
+// encode24 writes n characters of the crypt base64 encoding of w
+func encode24(b *strings.Builder, w uint, n int) {
+	for i := 0; i < n; i++ {
+		b.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
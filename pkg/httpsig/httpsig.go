@@ -0,0 +1,246 @@
+package httpsig
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+)
+
+var (
+	// ErrUnsupportedComponent is returned when a covered component or one of its parameters is not supported
+	ErrUnsupportedComponent = errors.New("unsupported HTTP signature component")
+	// ErrContentDigestMismatch is returned when the Content-Digest does not match the body
+	ErrContentDigestMismatch = errors.New("content digest does not match the body")
+)
+
+// Algorithms maps the names of the HTTP Message Signature algorithms (RFC 9421) to the JWS algorithms
+var Algorithms = map[string]jwa.SignatureAlgorithm{
+	"rsa-pss-sha512":    jwa.PS512,
+	"rsa-v1_5-sha256":   jwa.RS256,
+	"hmac-sha256":       jwa.HS256,
+	"ecdsa-p256-sha256": jwa.ES256,
+	"ecdsa-p384-sha384": jwa.ES384,
+	"ed25519":           jwa.EdDSA,
+}
+
+// Signature is a signature of an HTTP message, with the parameters of its Signature-Input
+type Signature struct {
+	// Label is the name of the signature on the Signature-Input and Signature dictionaries
+	Label string
+	// Components are the covered components
+	Components []Member
+	// Created is the created parameter, if any
+	Created time.Time
+	// Expires is the expires parameter, if any
+	Expires time.Time
+	// KeyID is the keyid parameter
+	KeyID string
+	// Alg is the alg parameter, if any
+	Alg string
+	// Nonce is the nonce parameter, if any
+	Nonce string
+	// Tag is the tag parameter, if any
+	Tag string
+	// Value is the signature
+	Value []byte
+
+	params Member
+}
+
+// Parse returns the signatures of the request, from its Signature-Input and Signature headers
+func Parse(r *http.Request) ([]*Signature, error) {
+	inputs, err := ParseDictionary(strings.Join(r.Header.Values("Signature-Input"), ", "))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Signature-Input: %w", err)
+	}
+	values, err := ParseDictionary(strings.Join(r.Header.Values("Signature"), ", "))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Signature: %w", err)
+	}
+
+	signatures := make([]*Signature, 0, len(inputs))
+	for _, input := range inputs {
+		if !input.IsList {
+			return nil, fmt.Errorf("invalid Signature-Input %s: not an inner list", input.Name)
+		}
+		s := &Signature{Label: input.Name, Components: input.InnerList, params: input}
+		for _, value := range values {
+			if value.Name == input.Name {
+				s.Value, _ = value.Item.Value.([]byte)
+			}
+		}
+		if s.Value == nil {
+			return nil, fmt.Errorf("missing Signature %s", input.Name)
+		}
+		for _, p := range input.Params {
+			switch v := p.Value.Value.(type) {
+			case int64:
+				switch p.Name {
+				case "created":
+					s.Created = time.Unix(v, 0)
+				case "expires":
+					s.Expires = time.Unix(v, 0)
+				}
+			case string:
+				switch p.Name {
+				case "keyid":
+					s.KeyID = v
+				case "alg":
+					s.Alg = v
+				case "nonce":
+					s.Nonce = v
+				case "tag":
+					s.Tag = v
+				}
+			}
+		}
+		signatures = append(signatures, s)
+	}
+	return signatures, nil
+}
+
+// Covers reports whether the component is covered by the signature
+func (s *Signature) Covers(name string) bool {
+	for _, c := range s.Components {
+		if component, _ := c.Item.Value.(string); strings.EqualFold(component, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Base returns the signature base of the request (RFC 9421, section 2.5)
+func (s *Signature) Base(r *http.Request) ([]byte, error) {
+	var b strings.Builder
+	for _, c := range s.Components {
+		name, ok := c.Item.Value.(string)
+		if !ok || c.Item.Token {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedComponent, c.Item)
+		}
+		value, err := componentValue(r, name, c)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(c.String())
+		b.WriteString(": ")
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+	b.WriteString(`"@signature-params": `)
+	b.WriteString(s.params.String())
+	return []byte(b.String()), nil
+}
+
+// componentValue returns the value of the covered component of the request
+func componentValue(r *http.Request, name string, c Member) (string, error) {
+	for _, p := range c.Params {
+		if p.Name != "name" || name != "@query-param" {
+			return "", fmt.Errorf("%w: %s;%s", ErrUnsupportedComponent, name, p.Name)
+		}
+	}
+
+	switch name {
+	case "@method":
+		return r.Method, nil
+	case "@target-uri":
+		return scheme(r) + "://" + r.Host + r.URL.RequestURI(), nil
+	case "@authority":
+		return authority(r), nil
+	case "@scheme":
+		return scheme(r), nil
+	case "@request-target":
+		return r.URL.RequestURI(), nil
+	case "@path":
+		path := r.URL.EscapedPath()
+		if path == "" {
+			path = "/"
+		}
+		return path, nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	case "@query-param":
+		param, ok := c.Param("name")
+		paramName, _ := param.Value.(string)
+		if !ok || paramName == "" {
+			return "", fmt.Errorf("%w: @query-param without name", ErrUnsupportedComponent)
+		}
+		values, ok := r.URL.Query()[paramName]
+		if !ok || len(values) != 1 {
+			return "", fmt.Errorf("missing or repeated query parameter %s", paramName)
+		}
+		return strings.ReplaceAll(url.QueryEscape(values[0]), "+", "%20"), nil
+	}
+
+	if strings.HasPrefix(name, "@") || name != strings.ToLower(name) {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedComponent, name)
+	}
+	header := r.Header.Values(name)
+	if name == "host" && len(header) == 0 && r.Host != "" {
+		header = []string{r.Host}
+	}
+	if len(header) == 0 {
+		return "", fmt.Errorf("missing covered header %s", name)
+	}
+	values := make([]string, len(header))
+	for i, v := range header {
+		values[i] = strings.TrimSpace(v)
+	}
+	return strings.Join(values, ", "), nil
+}
+
+// authority returns the lower case host of the request, without the default port
+func authority(r *http.Request) string {
+	host := strings.ToLower(r.Host)
+	if (scheme(r) == "https" && strings.HasSuffix(host, ":443")) || (scheme(r) == "http" && strings.HasSuffix(host, ":80")) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	return host
+}
+
+// scheme returns the scheme of the request
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// VerifyContentDigest verifies the Content-Digest header (RFC 9530) against the body.
+// At least one of the sha-256 and sha-512 digests must be present, and all of them must match
+func VerifyContentDigest(header string, body []byte) error {
+	digests, err := ParseDictionary(header)
+	if err != nil {
+		return fmt.Errorf("invalid Content-Digest: %w", err)
+	}
+	verified := false
+	for _, digest := range digests {
+		var sum []byte
+		switch digest.Name {
+		case "sha-256":
+			s := sha256.Sum256(body)
+			sum = s[:]
+		case "sha-512":
+			s := sha512.Sum512(body)
+			sum = s[:]
+		default:
+			continue
+		}
+		value, _ := digest.Item.Value.([]byte)
+		if subtle.ConstantTimeCompare(value, sum) != 1 {
+			return ErrContentDigestMismatch
+		}
+		verified = true
+	}
+	if !verified {
+		return fmt.Errorf("%w: no sha-256 or sha-512 digest", ErrContentDigestMismatch)
+	}
+	return nil
+}
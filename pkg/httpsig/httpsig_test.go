@@ -0,0 +1,166 @@
+package httpsig
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcContentDigest is the Content-Digest of the body of the test request of RFC 9421, appendix B.2
+const rfcContentDigest = "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:"
+
+// rfcRequest returns the test request of RFC 9421, appendix B.2, with the signature input
+func rfcRequest(input string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+	r.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Digest", rfcContentDigest)
+	r.Header.Set("Content-Length", "18")
+	r.Header.Set("Signature-Input", input)
+	r.Header.Set("Signature", "sig1=:AA==:")
+	return r
+}
+
+func TestBase(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantBase string
+		wantErr  bool
+	}{
+		{
+			name:     "minimal coverage",
+			input:    `sig1=();created=1618884473;keyid="test-key-rsa-pss";nonce="b3k2pp5k7z-50gnwp.yemd"`,
+			wantBase: `"@signature-params": ();created=1618884473;keyid="test-key-rsa-pss";nonce="b3k2pp5k7z-50gnwp.yemd"`,
+		},
+		{
+			name:  "selective coverage",
+			input: `sig1=("@authority" "content-digest" "@query-param";name="Pet");created=1618884473;keyid="test-key-rsa-pss";tag="header-example"`,
+			wantBase: `"@authority": example.com
+"content-digest": ` + rfcContentDigest + `
+"@query-param";name="Pet": dog
+"@signature-params": ("@authority" "content-digest" "@query-param";name="Pet");created=1618884473;keyid="test-key-rsa-pss";tag="header-example"`,
+		},
+		{
+			name:  "full coverage",
+			input: `sig1=("date" "@method" "@path" "@query" "@authority" "content-type" "content-digest" "content-length");created=1618884473;keyid="test-key-rsa-pss"`,
+			wantBase: `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@method": POST
+"@path": /foo
+"@query": ?param=Value&Pet=dog
+"@authority": example.com
+"content-type": application/json
+"content-digest": ` + rfcContentDigest + `
+"content-length": 18
+"@signature-params": ("date" "@method" "@path" "@query" "@authority" "content-type" "content-digest" "content-length");created=1618884473;keyid="test-key-rsa-pss"`,
+		},
+		{
+			name:     "target URI",
+			input:    `sig1=("@target-uri" "@scheme" "@request-target");created=1618884473`,
+			wantBase: "\"@target-uri\": http://example.com/foo?param=Value&Pet=dog\n\"@scheme\": http\n\"@request-target\": /foo?param=Value&Pet=dog\n\"@signature-params\": (\"@target-uri\" \"@scheme\" \"@request-target\");created=1618884473",
+		},
+		{
+			name:    "missing header",
+			input:   `sig1=("x-missing");created=1618884473`,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported component",
+			input:   `sig1=("@status");created=1618884473`,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported component parameter",
+			input:   `sig1=("content-digest";sf);created=1618884473`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signatures, err := Parse(rfcRequest(tt.input))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			base, err := signatures[0].Base(rfcRequest(tt.input))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Base() = %s, want an error", base)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Base() error = %v", err)
+			}
+			if string(base) != tt.wantBase {
+				t.Fatalf("Base() =\n%s\nwant\n%s", base, tt.wantBase)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	r := rfcRequest(`sig1=("@method");created=1618884473;expires=1618884773;keyid="key";alg="ed25519";nonce="n";tag="t", sig2=("@path");keyid="other"`)
+	r.Header.Set("Signature", "sig1=:AQID:, sig2=:BAUG:")
+
+	signatures, err := Parse(r)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(signatures) != 2 {
+		t.Fatalf("Parse() returned %d signatures, want 2", len(signatures))
+	}
+	s := signatures[0]
+	if s.Label != "sig1" || s.KeyID != "key" || s.Alg != "ed25519" || s.Nonce != "n" || s.Tag != "t" ||
+		!s.Created.Equal(time.Unix(1618884473, 0)) || !s.Expires.Equal(time.Unix(1618884773, 0)) ||
+		string(s.Value) != "\x01\x02\x03" || !s.Covers("@method") || s.Covers("@path") {
+		t.Fatalf("Parse() = %+v", s)
+	}
+	if signatures[1].Label != "sig2" || string(signatures[1].Value) != "\x04\x05\x06" || !signatures[1].Created.IsZero() {
+		t.Fatalf("Parse() = %+v", signatures[1])
+	}
+
+	r.Header.Set("Signature", "sig1=:AQID:")
+	if _, err := Parse(r); err == nil {
+		t.Fatal("Parse() accepted a Signature-Input without its Signature")
+	}
+}
+
+func TestVerifyContentDigest(t *testing.T) {
+	body := []byte(`{"hello": "world"}`)
+	sum256 := sha256.Sum256(body)
+	sum512 := sha512.Sum512(body)
+	sha256Digest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum256[:]) + ":"
+	sha512Digest := "sha-512=:" + base64.StdEncoding.EncodeToString(sum512[:]) + ":"
+
+	tests := []struct {
+		name    string
+		header  string
+		body    []byte
+		wantErr bool
+	}{
+		{name: "RFC example", header: rfcContentDigest, body: body},
+		{name: "sha-256", header: sha256Digest, body: body},
+		{name: "sha-256 and sha-512", header: sha256Digest + ", " + sha512Digest, body: body},
+		{name: "unknown algorithm ignored", header: "md5=:AAAA:, " + sha256Digest, body: body},
+		{name: "other body", header: sha256Digest, body: []byte(`{"hello": "there"}`), wantErr: true},
+		{name: "one digest does not match", header: sha256Digest + ", sha-512=:AAAA:", body: body, wantErr: true},
+		{name: "no supported digest", header: "md5=:AAAA:", body: body, wantErr: true},
+		{name: "missing header", header: "", body: body, wantErr: true},
+		{name: "malformed header", header: "sha-256=abc", body: body, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyContentDigest(tt.header, tt.body)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("VerifyContentDigest() error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package httpsig
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Item is a bare item of a structured field (RFC 8941): a string, token, integer, boolean or byte sequence
+type Item struct {
+	Value any
+	Token bool
+}
+
+// Param is a parameter of a structured field item or inner list
+type Param struct {
+	Name  string
+	Value Item
+}
+
+// Member is a member of a structured field dictionary: an item or an inner list, with its parameters
+type Member struct {
+	Name      string
+	Item      Item
+	InnerList []Member
+	IsList    bool
+	Params    []Param
+}
+
+// Param returns the value of the parameter, if any
+func (m Member) Param(name string) (Item, bool) {
+	for _, p := range m.Params {
+		if p.Name == name {
+			return p.Value, true
+		}
+	}
+	return Item{}, false
+}
+
+// String returns the canonical serialization of the member value and its parameters
+func (m Member) String() string {
+	var b strings.Builder
+	if m.IsList {
+		b.WriteByte('(')
+		for i, item := range m.InnerList {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(item.String())
+		}
+		b.WriteByte(')')
+	} else {
+		b.WriteString(m.Item.String())
+	}
+	for _, p := range m.Params {
+		b.WriteByte(';')
+		b.WriteString(p.Name)
+		if v, ok := p.Value.Value.(bool); !ok || !v {
+			b.WriteByte('=')
+			b.WriteString(p.Value.String())
+		}
+	}
+	return b.String()
+}
+
+// String returns the canonical serialization of the item
+func (i Item) String() string {
+	switch v := i.Value.(type) {
+	case string:
+		if i.Token {
+			return v
+		}
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		if v {
+			return "?1"
+		}
+		return "?0"
+	case []byte:
+		return ":" + base64.StdEncoding.EncodeToString(v) + ":"
+	default:
+		return ""
+	}
+}
+
+// ParseDictionary parses a structured field dictionary (RFC 8941), keeping the order of its members
+func ParseDictionary(value string) ([]Member, error) {
+	p := &parser{input: value}
+	members := []Member{}
+	p.skipSpaces()
+	for !p.done() {
+		name, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		member := Member{Name: name, Item: Item{Value: true}}
+		if p.peek() == '=' {
+			p.pos++
+			if member, err = p.member(); err != nil {
+				return nil, err
+			}
+			member.Name = name
+		} else if member.Params, err = p.params(); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+
+		p.skipSpaces()
+		if p.done() {
+			break
+		}
+		if p.peek() != ',' {
+			return nil, p.errorf("expected a comma")
+		}
+		p.pos++
+		p.skipSpaces()
+		if p.done() {
+			return nil, p.errorf("trailing comma")
+		}
+	}
+	return members, nil
+}
+
+// parser parses the structured fields
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *parser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *parser) skipSpaces() {
+	for !p.done() && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("invalid structured field at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// member parses an inner list or an item, with its parameters
+func (p *parser) member() (Member, error) {
+	var m Member
+	var err error
+	if p.peek() == '(' {
+		p.pos++
+		m.IsList = true
+		m.InnerList = []Member{}
+		for {
+			p.skipSpaces()
+			if p.peek() == ')' {
+				p.pos++
+				break
+			}
+			if p.done() {
+				return m, p.errorf("unterminated inner list")
+			}
+			item, err := p.member()
+			if err != nil {
+				return m, err
+			}
+			if item.IsList {
+				return m, p.errorf("nested inner list")
+			}
+			m.InnerList = append(m.InnerList, item)
+			if c := p.peek(); c != ' ' && c != ')' {
+				return m, p.errorf("expected a space or the end of the inner list")
+			}
+		}
+	} else if m.Item, err = p.item(); err != nil {
+		return m, err
+	}
+	m.Params, err = p.params()
+	return m, err
+}
+
+// params parses the parameters of an item or inner list
+func (p *parser) params() ([]Param, error) {
+	params := []Param{}
+	for p.peek() == ';' {
+		p.pos++
+		p.skipSpaces()
+		name, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		value := Item{Value: true}
+		if p.peek() == '=' {
+			p.pos++
+			if value, err = p.item(); err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, Param{Name: name, Value: value})
+	}
+	return params, nil
+}
+
+// key parses a dictionary or parameter key
+func (p *parser) key() (string, error) {
+	start := p.pos
+	for !p.done() {
+		c := p.input[p.pos]
+		if (c >= 'a' && c <= 'z') || c == '*' || (p.pos > start && ((c >= '0' && c <= '9') || c == '_' || c == '-' || c == '.')) {
+			p.pos++
+			continue
+		}
+		break
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a key")
+	}
+	return p.input[start:p.pos], nil
+}
+
+// item parses a bare item
+func (p *parser) item() (Item, error) {
+	c := p.peek()
+	switch {
+	case c == '"':
+		return p.string()
+	case c == ':':
+		p.pos++
+		end := strings.IndexByte(p.input[p.pos:], ':')
+		if end < 0 {
+			return Item{}, p.errorf("unterminated byte sequence")
+		}
+		data, err := base64.StdEncoding.DecodeString(p.input[p.pos : p.pos+end])
+		if err != nil {
+			return Item{}, p.errorf("invalid byte sequence: %s", err)
+		}
+		p.pos += end + 1
+		return Item{Value: data}, nil
+	case c == '?':
+		p.pos++
+		switch p.peek() {
+		case '1':
+			p.pos++
+			return Item{Value: true}, nil
+		case '0':
+			p.pos++
+			return Item{Value: false}, nil
+		}
+		return Item{}, p.errorf("invalid boolean")
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for !p.done() && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+			p.pos++
+		}
+		n, err := strconv.ParseInt(p.input[start:p.pos], 10, 64)
+		if err != nil {
+			return Item{}, p.errorf("invalid integer")
+		}
+		return Item{Value: n}, nil
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '*':
+		start := p.pos
+		for !p.done() && (isAlphaNum(p.input[p.pos]) || strings.IndexByte(":/!#$%&'*+-.^_`|~", p.input[p.pos]) >= 0) {
+			p.pos++
+		}
+		return Item{Value: p.input[start:p.pos], Token: true}, nil
+	default:
+		return Item{}, p.errorf("unexpected character %q", c)
+	}
+}
+
+// string parses a quoted string
+func (p *parser) string() (Item, error) {
+	p.pos++
+	var b strings.Builder
+	for !p.done() {
+		c := p.input[p.pos]
+		p.pos++
+		switch c {
+		case '\\':
+			if p.done() || (p.input[p.pos] != '"' && p.input[p.pos] != '\\') {
+				return Item{}, p.errorf("invalid escape")
+			}
+			b.WriteByte(p.input[p.pos])
+			p.pos++
+		case '"':
+			return Item{Value: b.String()}, nil
+		default:
+			if c < 0x20 || c > 0x7e {
+				return Item{}, p.errorf("invalid string character")
+			}
+			b.WriteByte(c)
+		}
+	}
+	return Item{}, p.errorf("unterminated string")
+}
+
+func isAlphaNum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -2,12 +2,30 @@ package jwks
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
+	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jws"
 )
 
+var (
+	// ErrAlgorithmNotAllowed is returned when the token alg is not allowed by the KeyHandler
+	ErrAlgorithmNotAllowed = errors.New("JWS algorithm not allowed")
+	// ErrKeyMismatch is returned when the key can not be used with the token alg
+	ErrKeyMismatch = errors.New("JWK does not match the JWS algorithm")
+)
+
+// DefaultAlgorithms is the list of algorithms allowed when the KeyHandler has no allow-list.
+// It only has asymmetric algorithms, since the keys usually come from a public JWKS
+var DefaultAlgorithms = []jwa.SignatureAlgorithm{
+	jwa.RS256, jwa.RS384, jwa.RS512,
+	jwa.PS256, jwa.PS384, jwa.PS512,
+	jwa.ES256, jwa.ES384, jwa.ES512,
+	jwa.EdDSA,
+}
+
 // Copied from: https://github.com/lestrrat-go/jwx/issues/812#issuecomment-1231137870
 
 // KeyFetcher allows other parts of your code to implement a simple key lookup.
@@ -17,9 +35,15 @@ type KeyFetcher func(c context.Context, keyID string) (jwk.Key, error)
 // KeyHandler wraps a KeyFetcher to implement jws.KeyProvider.
 // This is used in tandem with jwt.Parse to provide dynamic key lookup.
 //
-// In a more complex system, you might add logging, metrics, caching, rate limiting...
+// The alg of the token is never trusted by itself: it must be allowed by the KeyHandler
+// and match the alg, kty, use and key_ops of the fetched key (see Check).
 type KeyHandler struct {
 	Fetcher KeyFetcher
+	// Algorithms is the allow-list of signature algorithms. If empty, DefaultAlgorithms are allowed
+	Algorithms []jwa.SignatureAlgorithm
+	// AllowSymmetric allows the HMAC algorithms. It must only be set when the keys are secret,
+	// never when they come from a public JWKS
+	AllowSymmetric bool
 }
 
 func (h *KeyHandler) FetchKeys(c context.Context, result jws.KeySink, sig *jws.Signature, msg *jws.Message) error {
@@ -28,6 +52,11 @@ func (h *KeyHandler) FetchKeys(c context.Context, result jws.KeySink, sig *jws.S
 	alg := sig.ProtectedHeaders().Algorithm()
 	kid := sig.ProtectedHeaders().KeyID()
 
+	// Reject the algorithm before fetching the key
+	if err := h.checkAlgorithm(alg); err != nil {
+		return err
+	}
+
 	// Run the dynamic key lookup
 	key, err := h.Fetcher(c, kid)
 	if err != nil {
@@ -40,11 +69,123 @@ func (h *KeyHandler) FetchKeys(c context.Context, result jws.KeySink, sig *jws.S
 		return fmt.Errorf("Fetching kid '%s' returned different kid '%s'", kid, fetched_kid)
 	}
 
+	// Check that the fetched key can be used with the algorithm
+	if err := h.Check(key, alg); err != nil {
+		return err
+	}
+
 	// Mark as parse candidate
 	result.Key(alg, key)
 	return nil
 }
 
+// Check returns an error if the key can not be used to verify a signature with the algorithm:
+// the algorithm must be allowed, the alg of the key (if any) must be the same, the kty (and crv)
+// must be of the algorithm family, the use (if any) must be sig and the key_ops (if any) must have verify
+func (h *KeyHandler) Check(key jwk.Key, alg jwa.SignatureAlgorithm) error {
+	if err := h.checkAlgorithm(alg); err != nil {
+		return err
+	}
+
+	if keyAlg := key.Algorithm(); keyAlg != nil && keyAlg.String() != "" && keyAlg.String() != alg.String() {
+		return fmt.Errorf("%w: key alg %s, token alg %s", ErrKeyMismatch, keyAlg, alg)
+	}
+
+	keyType, curve := keyFamily(alg)
+	if key.KeyType() != keyType {
+		return fmt.Errorf("%w: key kty %s, token alg %s", ErrKeyMismatch, key.KeyType(), alg)
+	}
+	if curve != "" {
+		ecKey, ok := key.(interface {
+			Crv() jwa.EllipticCurveAlgorithm
+		})
+		if !ok || ecKey.Crv() != curve {
+			return fmt.Errorf("%w: key crv does not match token alg %s", ErrKeyMismatch, alg)
+		}
+	}
+
+	if use := key.KeyUsage(); use != "" && use != string(jwk.ForSignature) {
+		return fmt.Errorf("%w: key use %s", ErrKeyMismatch, use)
+	}
+
+	if ops := key.KeyOps(); len(ops) > 0 {
+		found := false
+		for _, op := range ops {
+			if op == jwk.KeyOpVerify {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: key_ops does not have verify", ErrKeyMismatch)
+		}
+	}
+
+	return nil
+}
+
+// checkAlgorithm returns an error if the algorithm is not allowed
+func (h *KeyHandler) checkAlgorithm(alg jwa.SignatureAlgorithm) error {
+	if alg == jwa.NoSignature || alg == "" {
+		return fmt.Errorf("%w: %s", ErrAlgorithmNotAllowed, jwa.NoSignature)
+	}
+	if IsSymmetric(alg) && !h.AllowSymmetric {
+		return fmt.Errorf("%w: %s", ErrAlgorithmNotAllowed, alg)
+	}
+
+	algorithms := h.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = DefaultAlgorithms
+		if h.AllowSymmetric {
+			algorithms = append([]jwa.SignatureAlgorithm{jwa.HS256, jwa.HS384, jwa.HS512}, algorithms...)
+		}
+	}
+	for _, allowed := range algorithms {
+		if allowed == alg {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrAlgorithmNotAllowed, alg)
+}
+
+// IsSymmetric returns true if the algorithm is an HMAC algorithm
+func IsSymmetric(alg jwa.SignatureAlgorithm) bool {
+	return alg == jwa.HS256 || alg == jwa.HS384 || alg == jwa.HS512
+}
+
+// keyFamily returns the kty (and the crv, for ECDSA) of the keys used with the algorithm
+func keyFamily(alg jwa.SignatureAlgorithm) (jwa.KeyType, jwa.EllipticCurveAlgorithm) {
+	switch alg {
+	case jwa.RS256, jwa.RS384, jwa.RS512, jwa.PS256, jwa.PS384, jwa.PS512:
+		return jwa.RSA, ""
+	case jwa.ES256:
+		return jwa.EC, jwa.P256
+	case jwa.ES384:
+		return jwa.EC, jwa.P384
+	case jwa.ES512:
+		return jwa.EC, jwa.P521
+	case jwa.EdDSA:
+		return jwa.OKP, ""
+	case jwa.HS256, jwa.HS384, jwa.HS512:
+		return jwa.OctetSeq, ""
+	default:
+		return jwa.InvalidKeyType, ""
+	}
+}
+
+// ParseAlgorithms parses the names of the signature algorithms
+func ParseAlgorithms(names []string) ([]jwa.SignatureAlgorithm, error) {
+	algorithms := make([]jwa.SignatureAlgorithm, 0, len(names))
+	for _, name := range names {
+		var alg jwa.SignatureAlgorithm
+		if err := alg.Accept(name); err != nil {
+			return nil, err
+		}
+		algorithms = append(algorithms, alg)
+	}
+	return algorithms, nil
+}
+
 // Trivial usage
 // func Parse(c context.Context, jwtRaw []byte, handler *KeyHandler) (jwt.Token, error) {
 
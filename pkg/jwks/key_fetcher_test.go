@@ -0,0 +1,306 @@
+package jwks
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// newRSAKey returns a new RSA private key with the kid
+func newRSAKey(t *testing.T, kid string) jwk.Key {
+	t.Helper()
+	raw, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Set(jwk.KeyIDKey, kid)
+	return key
+}
+
+// newECKey returns a new EC private key on the curve
+func newECKey(t *testing.T, curve elliptic.Curve) jwk.Key {
+	t.Helper()
+	raw, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// publicKey returns the public key of the private key, with the fields set
+func publicKey(t *testing.T, private jwk.Key, fields map[string]any) jwk.Key {
+	t.Helper()
+	key, err := private.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range fields {
+		if err := key.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return key
+}
+
+func TestKeyHandlerCheck(t *testing.T) {
+	rsaKey := newRSAKey(t, "rsa-1")
+	p256 := newECKey(t, elliptic.P256())
+	p384 := newECKey(t, elliptic.P384())
+	secret, err := jwk.FromRaw([]byte("a shared secret of the HMAC keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		handler *KeyHandler
+		key     jwk.Key
+		alg     jwa.SignatureAlgorithm
+		wantErr error
+	}{
+		{name: "RSA key", handler: &KeyHandler{}, key: publicKey(t, rsaKey, nil), alg: jwa.RS256},
+		{name: "RSA-PSS with an RSA key", handler: &KeyHandler{}, key: publicKey(t, rsaKey, nil), alg: jwa.PS512},
+		{name: "EC key", handler: &KeyHandler{}, key: publicKey(t, p256, nil), alg: jwa.ES256},
+		{name: "alg none", handler: &KeyHandler{}, key: publicKey(t, rsaKey, nil), alg: jwa.NoSignature, wantErr: ErrAlgorithmNotAllowed},
+		{name: "empty alg", handler: &KeyHandler{}, key: publicKey(t, rsaKey, nil), alg: "", wantErr: ErrAlgorithmNotAllowed},
+		{
+			name:    "alg none on the allow-list",
+			handler: &KeyHandler{Algorithms: []jwa.SignatureAlgorithm{jwa.NoSignature, jwa.RS256}},
+			key:     publicKey(t, rsaKey, nil),
+			alg:     jwa.NoSignature,
+			wantErr: ErrAlgorithmNotAllowed,
+		},
+		{name: "HS256 with an RSA public key", handler: &KeyHandler{}, key: publicKey(t, rsaKey, nil), alg: jwa.HS256, wantErr: ErrAlgorithmNotAllowed},
+		{
+			name:    "HS256 with an RSA public key allowing symmetric algorithms",
+			handler: &KeyHandler{AllowSymmetric: true},
+			key:     publicKey(t, rsaKey, nil),
+			alg:     jwa.HS256,
+			wantErr: ErrKeyMismatch,
+		},
+		{
+			name:    "HS256 on the allow-list without AllowSymmetric",
+			handler: &KeyHandler{Algorithms: []jwa.SignatureAlgorithm{jwa.HS256}},
+			key:     secret,
+			alg:     jwa.HS256,
+			wantErr: ErrAlgorithmNotAllowed,
+		},
+		{name: "HS256 with a secret", handler: &KeyHandler{AllowSymmetric: true}, key: secret, alg: jwa.HS256},
+		{name: "RS256 with a secret", handler: &KeyHandler{AllowSymmetric: true}, key: secret, alg: jwa.RS256, wantErr: ErrKeyMismatch},
+		{
+			name:    "alg not on the allow-list",
+			handler: &KeyHandler{Algorithms: []jwa.SignatureAlgorithm{jwa.ES256}},
+			key:     publicKey(t, rsaKey, nil),
+			alg:     jwa.RS256,
+			wantErr: ErrAlgorithmNotAllowed,
+		},
+		{name: "ES256 with an RSA key", handler: &KeyHandler{}, key: publicKey(t, rsaKey, nil), alg: jwa.ES256, wantErr: ErrKeyMismatch},
+		{name: "ES256 with a P-384 key", handler: &KeyHandler{}, key: publicKey(t, p384, nil), alg: jwa.ES256, wantErr: ErrKeyMismatch},
+		{name: "ES384 with a P-256 key", handler: &KeyHandler{}, key: publicKey(t, p256, nil), alg: jwa.ES384, wantErr: ErrKeyMismatch},
+		{name: "EdDSA with an EC key", handler: &KeyHandler{}, key: publicKey(t, p256, nil), alg: jwa.EdDSA, wantErr: ErrKeyMismatch},
+		{
+			name:    "key alg of another algorithm",
+			handler: &KeyHandler{},
+			key:     publicKey(t, rsaKey, map[string]any{jwk.AlgorithmKey: jwa.RS384}),
+			alg:     jwa.RS256,
+			wantErr: ErrKeyMismatch,
+		},
+		{name: "key alg", handler: &KeyHandler{}, key: publicKey(t, rsaKey, map[string]any{jwk.AlgorithmKey: jwa.RS256}), alg: jwa.RS256},
+		{name: "use sig", handler: &KeyHandler{}, key: publicKey(t, rsaKey, map[string]any{jwk.KeyUsageKey: "sig"}), alg: jwa.RS256},
+		{
+			name:    "use enc",
+			handler: &KeyHandler{},
+			key:     publicKey(t, rsaKey, map[string]any{jwk.KeyUsageKey: "enc"}),
+			alg:     jwa.RS256,
+			wantErr: ErrKeyMismatch,
+		},
+		{
+			name:    "key_ops with verify",
+			handler: &KeyHandler{},
+			key:     publicKey(t, rsaKey, map[string]any{jwk.KeyOpsKey: jwk.KeyOperationList{jwk.KeyOpEncrypt, jwk.KeyOpVerify}}),
+			alg:     jwa.RS256,
+		},
+		{
+			name:    "key_ops without verify",
+			handler: &KeyHandler{},
+			key:     publicKey(t, rsaKey, map[string]any{jwk.KeyOpsKey: jwk.KeyOperationList{jwk.KeyOpEncrypt, jwk.KeyOpWrapKey}}),
+			alg:     jwa.RS256,
+			wantErr: ErrKeyMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.handler.Check(tt.key, tt.alg)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Check() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Check() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyHandlerFetchKeys(t *testing.T) {
+	rsaKey := newRSAKey(t, "rsa-1")
+	public := publicKey(t, rsaKey, nil)
+	handler := &KeyHandler{
+		Fetcher: func(_ context.Context, keyID string) (jwk.Key, error) {
+			if keyID != "rsa-1" && keyID != "renamed" {
+				return nil, fmt.Errorf("unknown kid %s", keyID)
+			}
+			return public, nil
+		},
+	}
+	parse := func(token []byte) error {
+		_, err := jwt.Parse(token, jwt.WithKeyProvider(handler), jwt.WithValidate(false))
+		return err
+	}
+
+	claims := jwt.New()
+	claims.Set(jwt.SubjectKey, "user")
+
+	signed, err := jwt.Sign(claims, jwt.WithKey(jwa.RS256, rsaKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(signed); err != nil {
+		t.Fatalf("Parse() error = %v, want nil", err)
+	}
+
+	unsigned, err := jwt.Sign(claims, jwt.WithInsecureNoSignature())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(unsigned); err == nil {
+		t.Fatal("Parse() accepted a token with alg none")
+	}
+
+	// the classic algorithm confusion: an HS256 token whose secret is the PEM of the RSA public key
+	var raw rsa.PublicKey
+	if err := public.Raw(&raw); err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	for _, raw := range [][]byte{pemKey, der} {
+		secret, err := jwk.FromRaw(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret.Set(jwk.KeyIDKey, "rsa-1")
+		forged, err := jwt.Sign(claims, jwt.WithKey(jwa.HS256, secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := parse(forged); err == nil {
+			t.Fatal("Parse() accepted an HS256 token signed with the RSA public key")
+		}
+		handler.AllowSymmetric = true
+		if err := parse(forged); err == nil {
+			t.Fatal("Parse() accepted an HS256 token signed with the RSA public key allowing symmetric algorithms")
+		}
+		handler.AllowSymmetric = false
+	}
+
+	renamedKey, err := rsaKey.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamedKey.Set(jwk.KeyIDKey, "renamed")
+	renamed, err := jwt.Sign(claims, jwt.WithKey(jwa.RS256, renamedKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(renamed); err == nil {
+		t.Fatal("Parse() accepted a key fetched with another kid")
+	}
+}
+
+func TestParseAlgorithms(t *testing.T) {
+	algorithms, err := ParseAlgorithms([]string{"RS256", "ES384", "EdDSA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []jwa.SignatureAlgorithm{jwa.RS256, jwa.ES384, jwa.EdDSA}
+	if len(algorithms) != len(want) {
+		t.Fatalf("ParseAlgorithms() = %v, want %v", algorithms, want)
+	}
+	for i := range want {
+		if algorithms[i] != want[i] {
+			t.Fatalf("ParseAlgorithms() = %v, want %v", algorithms, want)
+		}
+	}
+
+	if algorithms, err := ParseAlgorithms(nil); err != nil || len(algorithms) != 0 {
+		t.Fatalf("ParseAlgorithms(nil) = %v, %v, want an empty list", algorithms, err)
+	}
+	for _, name := range []string{"RS999", "rs256", ""} {
+		if _, err := ParseAlgorithms([]string{"RS256", name}); err == nil {
+			t.Errorf("ParseAlgorithms() accepted %q", name)
+		}
+	}
+}
+
+func TestIsSymmetric(t *testing.T) {
+	for _, alg := range []jwa.SignatureAlgorithm{jwa.HS256, jwa.HS384, jwa.HS512} {
+		if !IsSymmetric(alg) {
+			t.Errorf("IsSymmetric(%s) = false, want true", alg)
+		}
+	}
+	for _, alg := range append([]jwa.SignatureAlgorithm{jwa.NoSignature}, DefaultAlgorithms...) {
+		if IsSymmetric(alg) {
+			t.Errorf("IsSymmetric(%s) = true, want false", alg)
+		}
+	}
+}
+
+func TestKeyHandlerAllowSymmetric(t *testing.T) {
+	tests := []struct {
+		handler *KeyHandler
+		alg     jwa.SignatureAlgorithm
+		allowed bool
+	}{
+		{handler: &KeyHandler{}, alg: jwa.RS256, allowed: true},
+		{handler: &KeyHandler{}, alg: jwa.HS256},
+		{handler: &KeyHandler{AllowSymmetric: true}, alg: jwa.HS256, allowed: true},
+		{handler: &KeyHandler{AllowSymmetric: true}, alg: jwa.HS512, allowed: true},
+		{handler: &KeyHandler{AllowSymmetric: true}, alg: jwa.ES256, allowed: true},
+		{handler: &KeyHandler{AllowSymmetric: true, Algorithms: []jwa.SignatureAlgorithm{jwa.HS256}}, alg: jwa.HS256, allowed: true},
+		{handler: &KeyHandler{AllowSymmetric: true, Algorithms: []jwa.SignatureAlgorithm{jwa.HS256}}, alg: jwa.HS384},
+		{handler: &KeyHandler{AllowSymmetric: true, Algorithms: []jwa.SignatureAlgorithm{jwa.HS256}}, alg: jwa.RS256},
+		{handler: &KeyHandler{AllowSymmetric: true}, alg: jwa.NoSignature},
+	}
+	for _, tt := range tests {
+		err := tt.handler.checkAlgorithm(tt.alg)
+		if (err == nil) != tt.allowed {
+			t.Errorf("checkAlgorithm(%s) with %+v = %v, want allowed %t", tt.alg, tt.handler, err, tt.allowed)
+		}
+	}
+}
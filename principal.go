@@ -0,0 +1,23 @@
+package goauth
+
+import (
+	"context"
+
+	"github.com/bancodobrasil/goauth/principal"
+)
+
+// Principal is the authenticated identity stored in the request context by the handlers
+type Principal = principal.Principal
+
+// PrincipalFromContext returns the principal of the authenticated request, if any.
+// When the request was authenticated by more than one handler, the principal of
+// the first successful handler is returned
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	return principal.FromContext(ctx)
+}
+
+// PrincipalsFromContext returns the principals of all the handlers that
+// authenticated the request, in the order they were executed
+func PrincipalsFromContext(ctx context.Context) []*Principal {
+	return principal.AllFromContext(ctx)
+}
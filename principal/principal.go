@@ -0,0 +1,78 @@
+package principal
+
+import (
+	"context"
+	"time"
+)
+
+// Principal is the authenticated identity of a request
+type Principal struct {
+	// Subject is the identifier of the authenticated entity (e.g. the sub claim of a JWT)
+	Subject string
+	// Issuer is the entity that issued the credentials (e.g. the iss claim of a JWT)
+	Issuer string
+	// Audiences is the list of audiences the credentials were issued for
+	Audiences []string
+	// Scopes is the list of scopes granted to the credentials
+	Scopes []string
+	// Roles is the list of roles granted to the authenticated entity
+	Roles []string
+	// Claims is the raw set of claims of the credentials
+	Claims map[string]any
+	// Handler is the name of the authentication handler that authenticated the request
+	Handler string
+	// APIKeyID is the identifier of the API key used to authenticate the request, if any
+	APIKeyID string
+	// IssuedAt is the time the credentials were issued, if known
+	IssuedAt time.Time
+	// ExpiresAt is the time the credentials expire, if known
+	ExpiresAt time.Time
+}
+
+// HasScope reports whether the principal was granted the given scope
+func (p *Principal) HasScope(scope string) bool {
+	return contains(p.Scopes, scope)
+}
+
+// HasRole reports whether the principal was granted the given role
+func (p *Principal) HasRole(role string) bool {
+	return contains(p.Roles, role)
+}
+
+// contextKey is the unexported type used to store the principals in the context,
+// so it cannot collide with keys defined by other packages
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries the principal. Principals already
+// stored in ctx are kept, so a chain of handlers can store one principal each
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	existing := AllFromContext(ctx)
+	principals := make([]*Principal, 0, len(existing)+1)
+	principals = append(principals, existing...)
+	principals = append(principals, p)
+	return context.WithValue(ctx, contextKey{}, principals)
+}
+
+// FromContext returns the first principal stored in ctx, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principals := AllFromContext(ctx)
+	if len(principals) == 0 {
+		return nil, false
+	}
+	return principals[0], true
+}
+
+// AllFromContext returns all the principals stored in ctx, in the order they were added
+func AllFromContext(ctx context.Context) []*Principal {
+	principals, _ := ctx.Value(contextKey{}).([]*Principal)
+	return principals
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
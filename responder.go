@@ -0,0 +1,83 @@
+package goauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ErrorResponder writes the response of a request aborted by the middleware
+type ErrorResponder interface {
+	Respond(w http.ResponseWriter, r *http.Request, err *AuthMiddlewareError)
+}
+
+// ErrorResponderFunc is an adapter to use ordinary functions as ErrorResponder
+type ErrorResponderFunc func(w http.ResponseWriter, r *http.Request, err *AuthMiddlewareError)
+
+// Respond calls f(w, r, err)
+func (f ErrorResponderFunc) Respond(w http.ResponseWriter, r *http.Request, err *AuthMiddlewareError) {
+	f(w, r, err)
+}
+
+// JSONErrorResponder responds with a {"error": "<message>"} JSON object. It is the default ErrorResponder
+type JSONErrorResponder struct{}
+
+// Respond implements the ErrorResponder interface
+func (JSONErrorResponder) Respond(w http.ResponseWriter, r *http.Request, err *AuthMiddlewareError) {
+	writeJSON(w, "application/json", err, map[string]string{"error": err.Message})
+}
+
+// ProblemErrorResponder responds with an application/problem+json object (RFC 7807)
+type ProblemErrorResponder struct {
+	// Type is the URI reference that identifies the problem type. Defaults to about:blank
+	Type string
+}
+
+// Respond implements the ErrorResponder interface
+func (p ProblemErrorResponder) Respond(w http.ResponseWriter, r *http.Request, err *AuthMiddlewareError) {
+	problemType := p.Type
+	if problemType == "" {
+		problemType = "about:blank"
+	}
+	writeJSON(w, "application/problem+json", err, map[string]any{
+		"type":     problemType,
+		"title":    http.StatusText(err.Code),
+		"status":   err.Code,
+		"detail":   err.Message,
+		"instance": r.URL.Path,
+	})
+}
+
+// PlainTextErrorResponder responds with the error message as plain text
+type PlainTextErrorResponder struct{}
+
+// Respond implements the ErrorResponder interface
+func (PlainTextErrorResponder) Respond(w http.ResponseWriter, r *http.Request, err *AuthMiddlewareError) {
+	err.WriteChallenges(w.Header())
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(err.Code)
+	w.Write([]byte(err.Message + "\n"))
+}
+
+// ParseErrorResponder returns the built-in ErrorResponder for the format: json, problem or text
+func ParseErrorResponder(format string) (ErrorResponder, bool) {
+	switch strings.TrimSpace(strings.ToLower(format)) {
+	case "json":
+		return JSONErrorResponder{}, true
+	case "problem":
+		return ProblemErrorResponder{}, true
+	case "text":
+		return PlainTextErrorResponder{}, true
+	default:
+		return nil, false
+	}
+}
+
+// writeJSON writes the challenges, the status code and the body of the response
+func writeJSON(w http.ResponseWriter, contentType string, err *AuthMiddlewareError, body any) {
+	err.WriteChallenges(w.Header())
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(err.Code)
+	json.NewEncoder(w).Encode(body)
+}